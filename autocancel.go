@@ -0,0 +1,167 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Actions reported in AutoCancelEvent
+const (
+	AutoCancelCancelled = "CANCELLED"
+	AutoCancelSkipped   = "SKIPPED"
+	AutoCancelFailed    = "FAILED"
+)
+
+// DefaultAutoCancelInterval is how often an AutoCanceller without an
+// Interval looks for abandoned payment requests
+const DefaultAutoCancelInterval = 30 * time.Second
+
+// AutoCancelEvent reports what an AutoCanceller did with an abandoned
+// payment request
+type AutoCancelEvent struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	OrderID   string    `json:"orderId"`
+	Action    string    `json:"action"`
+	Status    string    `json:"status,omitempty"` // status reported by TokiPay
+	Err       error     `json:"-"`
+}
+
+// AutoCanceller cancels payment requests still pending once their
+// time-to-live has passed. Requests are found in the payment store, so
+// tracking survives restarts; create them through a RecordingClient so they
+// are stored. Each request's status is checked before it is cancelled, and
+// one that was approved in the meantime is updated instead. Deeplink
+// payments have no request ID to check or cancel them with until their
+// callback is recorded, and are skipped.
+type AutoCanceller struct {
+	Client TokiPay
+	Store  PaymentStore
+
+	// TTL is how long a payment request may stay pending
+	TTL time.Duration
+
+	// Interval between sweeps in Run. Defaults to DefaultAutoCancelInterval.
+	Interval time.Duration
+
+	// Methods limits cancellation to payments created with these methods.
+	// Defaults to every method.
+	Methods []string
+
+	// OnEvent is called for every payment the canceller acts on
+	OnEvent func(AutoCancelEvent)
+
+	// OnError is called when a sweep fails to list payments. Run keeps
+	// running and retries on the next sweep.
+	OnError func(error)
+}
+
+// NewAutoCanceller creates a canceller for payment requests older than ttl
+func NewAutoCanceller(client TokiPay, store PaymentStore, ttl time.Duration) *AutoCanceller {
+	return &AutoCanceller{Client: client, Store: store, TTL: ttl}
+}
+
+// Run sweeps for abandoned payment requests every Interval until ctx is
+// done, then returns ctx.Err()
+func (a *AutoCanceller) Run(ctx context.Context) error {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = DefaultAutoCancelInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.Sweep(ctx); err != nil && ctx.Err() == nil && a.OnError != nil {
+			a.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep cancels every pending payment request older than TTL and returns
+// the number cancelled. Failures on single payments are reported through
+// OnEvent and retried on the next sweep.
+func (a *AutoCanceller) Sweep(ctx context.Context) (int, error) {
+	if a.TTL <= 0 {
+		return 0, errors.New("auto-cancel TTL must be positive")
+	}
+
+	payments, err := a.Store.ListPayments(ctx, PaymentFilter{
+		Statuses:      []string{StatusPending},
+		CreatedBefore: time.Now().Add(-a.TTL),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pending payments: %w", err)
+	}
+
+	client := a.recordingClient()
+	cancelled := 0
+	for _, p := range payments {
+		if err := ctx.Err(); err != nil {
+			return cancelled, err
+		}
+		if p.RequestID == "" || len(a.Methods) > 0 && !slices.Contains(a.Methods, p.Method) {
+			continue
+		}
+
+		e := a.cancel(client, p)
+		if e.Action == AutoCancelCancelled {
+			cancelled++
+		}
+		if a.OnEvent != nil {
+			a.OnEvent(e)
+		}
+	}
+	return cancelled, nil
+}
+
+// cancel checks one payment request and cancels it if it is still pending
+func (a *AutoCanceller) cancel(client *RecordingClient, p Payment) AutoCancelEvent {
+	e := AutoCancelEvent{RequestID: p.RequestID, OrderID: p.OrderID}
+
+	resp, err := client.CheckPaymentStatus(p.RequestID)
+	var serr *StoreError
+	switch {
+	case errors.As(err, &serr):
+		// the status is known even though recording it failed
+	case err != nil:
+		e.Time, e.Action, e.Err = time.Now(), AutoCancelFailed, fmt.Errorf("failed to check status: %w", err)
+		return e
+	}
+	e.Status = resp.Status
+
+	if resp.Status != StatusPending {
+		e.Time, e.Action, e.Err = time.Now(), AutoCancelSkipped, err
+		return e
+	}
+
+	err = client.CancelPayment(p.RequestID)
+	e.Time = time.Now()
+	switch {
+	case errors.As(err, &serr):
+		e.Action, e.Status, e.Err = AutoCancelCancelled, StatusCancelled, err
+	case err != nil:
+		e.Action, e.Err = AutoCancelFailed, fmt.Errorf("failed to cancel: %w", err)
+	default:
+		e.Action, e.Status = AutoCancelCancelled, StatusCancelled
+	}
+	return e
+}
+
+// recordingClient returns a client that records status checks and
+// cancellations. A RecordingClient passed as Client is used as is.
+func (a *AutoCanceller) recordingClient() *RecordingClient {
+	if rc, ok := a.Client.(*RecordingClient); ok {
+		return rc
+	}
+	return NewRecordingClient(a.Client, a.Store)
+}
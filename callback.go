@@ -0,0 +1,70 @@
+package tokipay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Callback header names sent for organization transactions
+const (
+	HeaderVATID   = "VAT_ID"
+	HeaderVATType = "VAT_TYPE"
+)
+
+// ParseCallback decodes a callback sent by TokiPay to a success or failure
+// URL. The VAT headers are validated, but invalid ones do not fail the
+// callback, so that its payment status is not lost: the problem is
+// reported in the headers' VATError.
+func ParseCallback(r *http.Request) (*CallbackRequest, *CallbackHeaders, error) {
+	var callback CallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode callback: %w", err)
+	}
+
+	if callback.RequestID == "" {
+		return nil, nil, fmt.Errorf("callback is missing requestId")
+	}
+
+	headers := &CallbackHeaders{
+		VATID:   r.Header.Get(HeaderVATID),
+		VATType: r.Header.Get(HeaderVATType),
+	}
+	headers.VATError = headers.Validate()
+
+	return &callback, headers, nil
+}
+
+// CallbackHandler returns an http.Handler that parses TokiPay callbacks and
+// passes them to fn. It responds with a SuccessResponse when fn succeeds and
+// an ErrorResponse otherwise.
+func CallbackHandler(fn func(callback CallbackRequest, headers CallbackHeaders) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callback, headers, err := ParseCallback(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_callback",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+
+		if err := fn(*callback, *headers); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{
+				Error:   "callback_failed",
+				Message: err.Error(),
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, SuccessResponse{Message: "callback received", Success: true})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
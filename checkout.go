@@ -0,0 +1,300 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultCheckoutTTL is the lifetime given to checkout sessions when the
+// Checkout has no TTL. TokiPay does not report when a request expires.
+const DefaultCheckoutTTL = 15 * time.Minute
+
+// ErrNoRequestID is returned by Cancel on a deeplink session, which TokiPay
+// does not assign a request ID to cancel it with, and by CheckStatus on one
+// when the checkout has no PaymentStore to read its status from
+var ErrNoRequestID = errors.New("checkout session has no request ID")
+
+// CheckoutOrder describes an order independently of the payment method
+type CheckoutOrder struct {
+	OrderID    string  `json:"orderId"`
+	Amount     float64 `json:"amount"`
+	Notes      string  `json:"notes,omitempty"`
+	SuccessURL string  `json:"successUrl"`
+	FailureURL string  `json:"failureUrl"`
+
+	// Items must sum to Amount when set. Mobile payments render them into
+	// ProductsInfo and EbarimtText unless those are given.
+	Items LineItems `json:"items,omitempty"`
+
+	// Mobile payments only
+	PhoneNo         string   `json:"phoneNo,omitempty"`
+	CountryCode     string   `json:"countryCode,omitempty"` // defaults to DefaultCountryCode
+	Type            string   `json:"type,omitempty"`        // defaults to THIRD_PARTY_PAY
+	SuccessText     string   `json:"successText,omitempty"`
+	EbarimtText     string   `json:"ebarimtText,omitempty"`
+	ProductsInfo    []string `json:"productsInfo,omitempty"`
+	PaymentCategory string   `json:"paymentCategory,omitempty"`
+}
+
+// CheckoutSession is a payment request in a shape common to all methods.
+// Sessions marshal to JSON for the frontend; use Checkout.Resume to act on
+// one that was decoded.
+type CheckoutSession struct {
+	Method        string    `json:"method"`
+	OrderID       string    `json:"orderId"`
+	Amount        float64   `json:"amount"`
+	RequestID     string    `json:"requestId,omitempty"`
+	TransactionID string    `json:"transactionId,omitempty"`
+	QRPayload     string    `json:"qrPayload,omitempty"` // data to encode in the QR code, see Checkout.QRPayload
+	Deeplink      string    `json:"deeplink,omitempty"`
+	Status        string    `json:"status"`
+	TransNumber   string    `json:"transNumber,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+
+	// Attempts lists the methods tried when the session was started by a
+	// FallbackChain
+	Attempts []FallbackAttempt `json:"attempts,omitempty"`
+
+	client TokiPay
+	store  PaymentStore
+}
+
+// Checkout starts checkout sessions with any payment method
+type Checkout struct {
+	Client TokiPay
+
+	// TTL sets the sessions' ExpiresAt. Defaults to DefaultCheckoutTTL.
+	TTL time.Duration
+
+	// SuccessURL and FailureURL are used for orders without their own
+	SuccessURL string
+	FailureURL string
+
+	// Store is where deeplink sessions read their status. TokiPay assigns
+	// deeplink payments no request ID to check, so their status is the one
+	// recorded by RecordingClient.RecordCallback, looked up by transaction
+	// ID. Defaults to the Store of a RecordingClient Client.
+	Store PaymentStore
+
+	// QRPayload returns the data to encode in the QR code of a QR payment.
+	// TokiPay's API documentation does not name the field; the default
+	// encodes the request ID, as the QR example of this package does.
+	QRPayload func(resp *QRPaymentResponse) string
+}
+
+// NewCheckout creates a checkout on client
+func NewCheckout(client TokiPay) *Checkout {
+	return &Checkout{Client: client}
+}
+
+// Start creates a payment request for the order with the given method,
+// MethodQR, MethodMobile or MethodDeeplink. If the client is a
+// RecordingClient that created the request but failed to record it, the
+// session is returned together with the *StoreError.
+func (c *Checkout) Start(order CheckoutOrder, method string) (*CheckoutSession, error) {
+	s := &CheckoutSession{
+		Method:    method,
+		OrderID:   order.OrderID,
+		Amount:    order.Amount,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+		client:    c.Client,
+		store:     c.store(),
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultCheckoutTTL
+	}
+	s.ExpiresAt = s.CreatedAt.Add(ttl)
+
+	if order.SuccessURL == "" {
+		order.SuccessURL = c.SuccessURL
+	}
+	if order.FailureURL == "" {
+		order.FailureURL = c.FailureURL
+	}
+
+	if len(order.Items) > 0 {
+		if err := order.Items.Validate(order.Amount); err != nil {
+			return nil, fmt.Errorf("invalid line items: %w", err)
+		}
+	}
+
+	var err error
+	switch method {
+	case MethodQR:
+		resp, cerr := c.Client.CreateQRPayment(QRPaymentRequest{
+			SuccessURL: order.SuccessURL,
+			FailureURL: order.FailureURL,
+			OrderID:    order.OrderID,
+			Amount:     order.Amount,
+			Notes:      order.Notes,
+		})
+		if resp == nil {
+			return nil, cerr
+		}
+		err = cerr
+		s.RequestID = resp.RequestID
+		s.TransactionID = resp.TransactionID
+		s.QRPayload = resp.RequestID
+		if c.QRPayload != nil {
+			s.QRPayload = c.QRPayload(resp)
+		}
+
+	case MethodMobile:
+		if order.PhoneNo == "" {
+			return nil, errors.New("mobile checkout requires a phone number")
+		}
+		req := MobilePaymentRequest{
+			SuccessURL:      order.SuccessURL,
+			FailureURL:      order.FailureURL,
+			OrderID:         order.OrderID,
+			Amount:          order.Amount,
+			Notes:           order.Notes,
+			PhoneNo:         order.PhoneNo,
+			CountryCode:     order.CountryCode,
+			Type:            order.Type,
+			SuccessText:     order.SuccessText,
+			EbarimtText:     order.EbarimtText,
+			ProductsInfo:    order.ProductsInfo,
+			PaymentCategory: order.PaymentCategory,
+		}
+		if req.CountryCode == "" {
+			req.CountryCode = DefaultCountryCode
+		}
+		if req.Type == "" {
+			req.Type = TypeThirdPartyPay
+		}
+		if len(order.Items) > 0 && req.ProductsInfo == nil {
+			req.ProductsInfo = order.Items.ProductsInfo()
+		}
+		if len(order.Items) > 0 && req.EbarimtText == "" {
+			req.EbarimtText = order.Items.EbarimtText()
+		}
+		resp, cerr := c.Client.CreateMobilePayment(req)
+		if resp == nil {
+			return nil, cerr
+		}
+		err = cerr
+		s.RequestID = resp.RequestID
+
+	case MethodDeeplink:
+		resp, cerr := c.Client.CreateDeeplinkPayment(DeeplinkPaymentRequest{
+			SuccessURL: order.SuccessURL,
+			FailureURL: order.FailureURL,
+			OrderID:    order.OrderID,
+			Amount:     order.Amount,
+			Notes:      order.Notes,
+		})
+		if resp == nil {
+			return nil, cerr
+		}
+		err = cerr
+		s.TransactionID = resp.TransactionID
+		s.Deeplink = resp.Deeplink
+
+	default:
+		return nil, fmt.Errorf("unknown payment method %q", method)
+	}
+
+	return s, err
+}
+
+// Resume attaches a decoded session to the checkout's client and store
+func (c *Checkout) Resume(s *CheckoutSession) *CheckoutSession {
+	s.client = c.Client
+	s.store = c.store()
+	return s
+}
+
+func (c *Checkout) store() PaymentStore {
+	if c.Store != nil {
+		return c.Store
+	}
+	if rc, ok := c.Client.(*RecordingClient); ok {
+		return rc.Store
+	}
+	return nil
+}
+
+// Expired reports whether TokiPay expired the session or it is past
+// ExpiresAt without being approved
+func (s *CheckoutSession) Expired() bool {
+	return s.Status == StatusExpired || (s.Status != StatusApproved && time.Now().After(s.ExpiresAt))
+}
+
+// CheckStatus checks the payment status with TokiPay and updates the
+// session. A deeplink session reads the status stored for its transaction
+// ID instead; see Checkout.Store.
+func (s *CheckoutSession) CheckStatus() (*PaymentStatusResponse, error) {
+	var resp *PaymentStatusResponse
+	var err error
+	if s.RequestID == "" {
+		resp, err = s.storedStatus()
+	} else {
+		resp, err = s.client.CheckPaymentStatus(s.RequestID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.Status = resp.Status
+	if resp.TransNumber != "" {
+		s.TransNumber = resp.TransNumber
+	}
+	return resp, nil
+}
+
+// storedStatus returns the status of a deeplink session's payment as
+// recorded in the store
+func (s *CheckoutSession) storedStatus() (*PaymentStatusResponse, error) {
+	if s.store == nil || s.TransactionID == "" {
+		return nil, ErrNoRequestID
+	}
+	p, err := s.store.GetPayment(context.Background(), s.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deeplink payment %s: %w", s.TransactionID, err)
+	}
+	return &PaymentStatusResponse{Status: p.Status, TransNumber: p.TransNumber, Fee: p.Fee}, nil
+}
+
+// Cancel cancels the payment request. TokiPay has no cancellation for
+// deeplink payments, which expire on their own; Cancel returns
+// ErrNoRequestID for them.
+func (s *CheckoutSession) Cancel() error {
+	if s.RequestID == "" {
+		return ErrNoRequestID
+	}
+	if err := s.client.CancelPayment(s.RequestID); err != nil {
+		return err
+	}
+	s.Status = StatusCancelled
+	return nil
+}
+
+// Refund refunds an approved session. Zero refunds the full amount. The
+// status is refreshed first when the session has no TransNumber yet; a
+// deeplink session gets it from its stored payment.
+func (s *CheckoutSession) Refund(amount float64) (*RefundResponse, error) {
+	if s.TransNumber == "" {
+		if _, err := s.CheckStatus(); err != nil {
+			return nil, err
+		}
+	}
+	if s.Status != StatusApproved {
+		return nil, fmt.Errorf("checkout session is %s, only approved payments can be refunded", s.Status)
+	}
+	if s.TransNumber == "" {
+		return nil, errors.New("approved checkout session has no TransNumber to refund yet")
+	}
+
+	req := RefundRequest{TransNumber: s.TransNumber}
+	if amount > 0 {
+		req.Amount = strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+	return s.client.RefundPayment(req)
+}
@@ -0,0 +1,143 @@
+package tokipay_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func newCheckout(srv *tokipaytest.Server) *tokipay.Checkout {
+	checkout := tokipay.NewCheckout(srv.NewClient())
+	checkout.SuccessURL = "https://example.com/success"
+	checkout.FailureURL = "https://example.com/failure"
+	return checkout
+}
+
+func TestCheckoutQR(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	checkout := newCheckout(srv)
+
+	session, err := checkout.Start(tokipay.CheckoutOrder{OrderID: "ORDER_1", Amount: 1000}, tokipay.MethodQR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.RequestID == "" || session.TransactionID == "" || session.QRPayload != session.RequestID ||
+		session.Status != tokipay.StatusPending || session.Expired() {
+		t.Fatalf("QR session = %+v", *session)
+	}
+	if !session.ExpiresAt.Equal(session.CreatedAt.Add(tokipay.DefaultCheckoutTTL)) {
+		t.Errorf("ExpiresAt = %v, want CreatedAt + DefaultCheckoutTTL", session.ExpiresAt)
+	}
+	if remote, _ := srv.Payment(session.RequestID); remote.SuccessURL != checkout.SuccessURL {
+		t.Errorf("payment success URL = %q, want the checkout's", remote.SuccessURL)
+	}
+
+	if _, err := session.Refund(0); err == nil {
+		t.Error("refund of a pending session succeeded")
+	}
+	if err := srv.Approve(session.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.CheckStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != tokipay.StatusApproved || session.TransNumber == "" || session.Expired() {
+		t.Errorf("approved session = %+v", *session)
+	}
+	if _, err := session.Refund(400); err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := srv.Payment(session.RequestID); remote.Refunded != 400 {
+		t.Errorf("refunded %v, want 400", remote.Refunded)
+	}
+
+	checkout.QRPayload = func(resp *tokipay.QRPaymentResponse) string { return "tokipay:" + resp.TransactionID }
+	other, err := checkout.Start(tokipay.CheckoutOrder{OrderID: "ORDER_2", Amount: 1000}, tokipay.MethodQR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.QRPayload != "tokipay:"+other.TransactionID {
+		t.Errorf("QRPayload = %q, want the custom payload", other.QRPayload)
+	}
+	if err := other.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := srv.Payment(other.RequestID); other.Status != tokipay.StatusCancelled || remote.Status != tokipay.StatusCancelled {
+		t.Errorf("cancelled session is %s, TokiPay has %s", other.Status, remote.Status)
+	}
+}
+
+func TestCheckoutMobile(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	checkout := newCheckout(srv)
+
+	order := tokipay.CheckoutOrder{
+		OrderID: "ORDER_1",
+		Amount:  4500,
+		Items: tokipay.LineItems{
+			{Name: "Coffee", Quantity: 2, UnitPrice: 1500},
+			{Name: "Cake", Quantity: 1, UnitPrice: 1500},
+		},
+	}
+	if _, err := checkout.Start(order, tokipay.MethodMobile); err == nil || !strings.Contains(err.Error(), "phone number") {
+		t.Errorf("mobile checkout without a phone number error = %v", err)
+	}
+
+	order.PhoneNo = "99119911"
+	session, err := checkout.Start(order, tokipay.MethodMobile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.RequestID == "" || session.QRPayload != "" || session.Deeplink != "" {
+		t.Fatalf("mobile session = %+v", *session)
+	}
+	remote, _ := srv.Payment(session.RequestID)
+	if remote.PhoneNo != "99119911" || remote.CountryCode != tokipay.DefaultCountryCode {
+		t.Errorf("mobile payment = %+v", remote)
+	}
+
+	if err := srv.Expire(session.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.CheckStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != tokipay.StatusExpired || !session.Expired() {
+		t.Errorf("expired session = %+v", *session)
+	}
+	if err := session.Cancel(); err == nil {
+		t.Error("cancelling an expired session succeeded")
+	}
+
+	order.Amount = 5000
+	if _, err := checkout.Start(order, tokipay.MethodMobile); err == nil || !strings.Contains(err.Error(), "line items") {
+		t.Errorf("checkout with items not summing to the amount error = %v", err)
+	}
+	if _, err := checkout.Start(order, "CARD"); err == nil {
+		t.Error("checkout with an unknown method succeeded")
+	}
+}
+
+func TestCheckoutDeeplinkWithoutStore(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	session, err := newCheckout(srv).Start(tokipay.CheckoutOrder{OrderID: "ORDER_1", Amount: 1000}, tokipay.MethodDeeplink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.RequestID != "" || session.TransactionID == "" || session.Deeplink == "" {
+		t.Fatalf("deeplink session = %+v", *session)
+	}
+	if _, err := session.CheckStatus(); !errors.Is(err, tokipay.ErrNoRequestID) {
+		t.Errorf("status of a deeplink session without a store error = %v", err)
+	}
+	if err := session.Cancel(); !errors.Is(err, tokipay.ErrNoRequestID) {
+		t.Errorf("cancelling a deeplink session error = %v", err)
+	}
+}
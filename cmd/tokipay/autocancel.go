@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// autoCancelEvent is the JSON form of tokipay.AutoCancelEvent
+type autoCancelEvent struct {
+	tokipay.AutoCancelEvent
+	Error string `json:"error,omitempty"`
+}
+
+func runAutoCancel(args []string) error {
+	fs, cf := newFlagSet("autocancel", "")
+	var sf storeFlags
+	sf.register(fs)
+	ttl := fs.Duration("ttl", 15*time.Minute, "cancel payment requests pending for longer than this")
+	interval := fs.Duration("interval", tokipay.DefaultAutoCancelInterval, "time between sweeps")
+	once := fs.Bool("once", false, "sweep once and exit")
+	var methods stringsFlag
+	fs.Var(&methods, "method", "payment method to cancel: QR or MOBILE (repeatable, default both)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *ttl <= 0 || *interval <= 0 {
+		return usageError("-ttl and -interval must be positive")
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, closeStore, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	enc := json.NewEncoder(os.Stdout)
+	a := tokipay.NewAutoCanceller(client, store, *ttl)
+	a.Interval = *interval
+	a.Methods = methods
+	a.OnEvent = func(e tokipay.AutoCancelEvent) {
+		if cf.output == formatJSON {
+			out := autoCancelEvent{AutoCancelEvent: e}
+			if e.Err != nil {
+				out.Error = e.Err.Error()
+			}
+			enc.Encode(out)
+			return
+		}
+		printAutoCancelEvent(e)
+	}
+	a.OnError = func(err error) {
+		fmt.Fprintf(os.Stderr, "tokipay autocancel: %v\n", err)
+	}
+
+	if *once {
+		_, err := a.Sweep(ctx)
+		return err
+	}
+	if err := a.Run(ctx); ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func printAutoCancelEvent(e tokipay.AutoCancelEvent) {
+	ts := e.Time.Format("15:04:05")
+	if e.Err != nil {
+		fmt.Fprintf(os.Stdout, "%s  %s  %s  %s: %v\n", ts, e.RequestID, e.OrderID, e.Action, e.Err)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s  %s  %s  %s  %s\n", ts, e.RequestID, e.OrderID, e.Action, e.Status)
+}
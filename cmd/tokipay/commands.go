@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// newFlagSet creates the flag set for a command with the shared client flags
+func newFlagSet(name, args string) (*flag.FlagSet, *clientFlags) {
+	fs := newCommandFlagSet(name, args)
+	cf := &clientFlags{}
+	cf.register(fs)
+	return fs, cf
+}
+
+// newCommandFlagSet creates the flag set for a command that does not call TokiPay
+func newCommandFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tokipay %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// paymentFlags are the order fields shared by the payment creation commands
+type paymentFlags struct {
+	orderID    string
+	amount     float64
+	successURL string
+	failureURL string
+	notes      string
+}
+
+func (f *paymentFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.orderID, "order-id", "", "merchant order ID (required)")
+	fs.Float64Var(&f.amount, "amount", 0, "amount in MNT (required)")
+	fs.StringVar(&f.successURL, "success-url", "", "callback URL for successful payments (required)")
+	fs.StringVar(&f.failureURL, "failure-url", "", "callback URL for failed payments (required)")
+	fs.StringVar(&f.notes, "notes", "", "payment notes")
+}
+
+func (f *paymentFlags) validate() error {
+	switch {
+	case f.orderID == "":
+		return usageError("-order-id is required")
+	case f.amount <= 0:
+		return usageError("-amount must be greater than 0")
+	case f.successURL == "" || f.failureURL == "":
+		return usageError("-success-url and -failure-url are required")
+	}
+	return nil
+}
+
+// stringsFlag collects a repeatable string flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runToken(args []string) error {
+	fs, cf := newFlagSet("token", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+	if err := client.GetAccessToken(); err != nil {
+		return err
+	}
+
+	return printResult(cf.output, struct {
+		AccessToken string    `json:"accessToken"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}{client.AccessToken.Reveal(), client.TokenExpiry})
+}
+
+func runQR(args []string) error {
+	fs, cf := newFlagSet("qr", "")
+	var pf paymentFlags
+	pf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := pf.validate(); err != nil {
+		return err
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: pf.successURL,
+		FailureURL: pf.failureURL,
+		OrderID:    pf.orderID,
+		Amount:     pf.amount,
+		Notes:      pf.notes,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(cf.output, resp)
+}
+
+func runMobile(args []string) error {
+	fs, cf := newFlagSet("mobile", "")
+	var pf paymentFlags
+	pf.register(fs)
+	var products stringsFlag
+	phone := fs.String("phone", "", "customer phone number (required)")
+	countryCode := fs.String("country-code", tokipay.DefaultCountryCode, "customer phone country code")
+	payType := fs.String("type", tokipay.TypeThirdPartyPay, "payment type: SPOS or THIRD_PARTY_PAY")
+	successText := fs.String("success-text", "", "text shown to the customer after payment")
+	ebarimtText := fs.String("ebarimt-text", "", "e-barimt text")
+	category := fs.String("category", "", "payment category")
+	fs.Var(&products, "product", "product line shown to the customer (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := pf.validate(); err != nil {
+		return err
+	}
+	if *phone == "" {
+		return usageError("-phone is required")
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreateMobilePayment(tokipay.MobilePaymentRequest{
+		SuccessURL:      pf.successURL,
+		FailureURL:      pf.failureURL,
+		OrderID:         pf.orderID,
+		Amount:          pf.amount,
+		Notes:           pf.notes,
+		PhoneNo:         *phone,
+		CountryCode:     *countryCode,
+		Type:            *payType,
+		SuccessText:     *successText,
+		EbarimtText:     *ebarimtText,
+		ProductsInfo:    products,
+		PaymentCategory: *category,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(cf.output, resp)
+}
+
+func runDeeplink(args []string) error {
+	fs, cf := newFlagSet("deeplink", "")
+	var pf paymentFlags
+	pf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := pf.validate(); err != nil {
+		return err
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreateDeeplinkPayment(tokipay.DeeplinkPaymentRequest{
+		SuccessURL: pf.successURL,
+		FailureURL: pf.failureURL,
+		OrderID:    pf.orderID,
+		Amount:     pf.amount,
+		Notes:      pf.notes,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(cf.output, resp)
+}
+
+// statusRow is a status check result for one payment request
+type statusRow struct {
+	RequestID string `json:"requestId"`
+	tokipay.PaymentStatusResponse
+	Error string `json:"error,omitempty"`
+
+	// stopped is set by watch when it gave up before a final status
+	stopped bool
+}
+
+func runStatus(args []string) error {
+	fs, cf := newFlagSet("status", "<requestId>...")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("at least one request ID is required")
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	rows := make([]statusRow, 0, fs.NArg())
+	for _, requestID := range fs.Args() {
+		row := statusRow{RequestID: requestID}
+		resp, err := client.CheckPaymentStatus(requestID)
+		if err != nil {
+			row.Error = err.Error()
+			failed++
+		} else {
+			row.PaymentStatusResponse = *resp
+		}
+		rows = append(rows, row)
+	}
+
+	if err := printResult(cf.output, rows); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return &exitError{code: exitError1, err: fmt.Errorf("%d of %d status checks failed", failed, len(rows))}
+	}
+	return nil
+}
+
+func runCancel(args []string) error {
+	fs, cf := newFlagSet("cancel", "<requestId>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one request ID is required")
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	requestID := fs.Arg(0)
+	if err := client.CancelPayment(requestID); err != nil {
+		return err
+	}
+	return printResult(cf.output, struct {
+		RequestID string `json:"requestId"`
+		Status    string `json:"status"`
+	}{requestID, tokipay.StatusCancelled})
+}
+
+func runRefund(args []string) error {
+	fs, cf := newFlagSet("refund", "<transNumber>")
+	amount := fs.String("amount", "", "amount to refund, full refund when empty")
+	var sf storeFlags
+	sf.register(fs)
+	reason := fs.String("reason", "", "refund reason, recorded in the refund ledger")
+	operator := fs.String("operator", "", "operator ID, recorded in the refund ledger")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one transaction number is required")
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	if !sf.enabled() {
+		resp, err := client.RefundPayment(tokipay.RefundRequest{
+			TransNumber: fs.Arg(0),
+			Amount:      *amount,
+		})
+		if err != nil {
+			return err
+		}
+		return printResult(cf.output, resp)
+	}
+
+	// with a payment database the refund goes through the ledger
+	var value float64
+	if *amount != "" {
+		if value, err = strconv.ParseFloat(*amount, 64); err != nil || value <= 0 {
+			return usageError("invalid -amount %q", *amount)
+		}
+	}
+
+	ctx := context.Background()
+	store, closeStore, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	refund, err := tokipay.NewRefundManager(client, store, store).Refund(ctx, tokipay.RefundParams{
+		TransNumber: fs.Arg(0),
+		Amount:      value,
+		Reason:      *reason,
+		OperatorID:  *operator,
+	})
+	if refund != nil {
+		if perr := printResult(cf.output, refund); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+func runVAT(args []string) error {
+	fs, cf := newFlagSet("vat", "<transactionId>")
+	var req tokipay.VATRegistrationRequest
+	fs.StringVar(&req.DDTD, "ddtd", "", "e-barimt DDTD (required)")
+	fs.StringVar(&req.TotalAmount, "total", "", "total amount")
+	fs.StringVar(&req.VATAmount, "vat-amount", "", "VAT amount")
+	fs.TextVar(&req.CreatedDate, "date", tokipay.Date{}, "receipt date as MM/DD/YYYY")
+	fs.StringVar(&req.MerchantName, "merchant-name", "", "merchant name")
+	fs.StringVar(&req.MerchantTIN, "merchant-tin", "", "merchant TIN")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one transaction ID is required")
+	}
+	if req.DDTD == "" {
+		return usageError("-ddtd is required")
+	}
+	req.TransactionID = fs.Arg(0)
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.RegisterVAT(req)
+	if err != nil {
+		return err
+	}
+	return printResult(cf.output, resp)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/term"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipayconfig"
+)
+
+// profileExtensions are the config file formats a profile may be written in,
+// in the order they are looked for
+var profileExtensions = []string{".yaml", ".yml", ".json", ".toml"}
+
+// clientFlags are the flags shared by every command that talks to TokiPay
+type clientFlags struct {
+	env        string
+	baseURL    string
+	username   string
+	merchantID string
+	timeout    time.Duration
+	profile    string
+	configFile string
+	output     string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.env, "env", "", "environment: prod or test (env TOKIPAY_ENV)")
+	fs.StringVar(&f.baseURL, "base-url", "", "override the API base URL (env TOKIPAY_BASE_URL)")
+	fs.StringVar(&f.username, "username", "", "API username (env TOKIPAY_USERNAME); the password is read from TOKIPAY_PASSWORD, the config file or a prompt")
+	fs.StringVar(&f.merchantID, "merchant-id", "", "merchant ID (env TOKIPAY_MERCHANT_ID)")
+	fs.StringVar(&f.profile, "profile", "", "read ~/.tokipay/<profile>.yaml, .yml, .json or .toml (env TOKIPAY_PROFILE, default \"default\")")
+	fs.StringVar(&f.configFile, "config", "", "config file to read instead of the profile (env "+tokipayconfig.EnvConfigFile+")")
+	fs.StringVar(&f.output, "o", formatTable, "output format: table or json")
+	fs.DurationVar(&f.timeout, "timeout", 0, "HTTP timeout (default from the config, 30s)")
+}
+
+// config resolves the client settings with tokipayconfig, as a service
+// would: the defaults, then the config file, then the TOKIPAY_* variables
+// read by Config.LoadEnv. Flags are applied last and take precedence. The
+// password has no flag, which would show it in the process list and the
+// shell history; without one in the environment or the config file, it is
+// asked for on the terminal.
+func (f *clientFlags) config() (*tokipayconfig.Config, error) {
+	cfg := tokipayconfig.Default()
+	path, err := f.configPath()
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, set := range []struct {
+		value string
+		field *string
+	}{
+		{f.env, &cfg.Environment},
+		{f.baseURL, &cfg.BaseURL},
+		{f.username, &cfg.Username},
+		{f.merchantID, &cfg.MerchantID},
+	} {
+		if set.value != "" {
+			*set.field = set.value
+		}
+	}
+	if f.timeout > 0 {
+		cfg.Timeout = tokipayconfig.Duration(f.timeout)
+	}
+
+	if cfg.Password == "" && cfg.PasswordFile == "" {
+		password, err := promptPassword()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Password = tokipay.Secret(password)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, usageError("invalid settings: %v", err)
+	}
+	return &cfg, nil
+}
+
+// configPath returns the config file to read: the one named by -config or
+// TOKIPAY_CONFIG_FILE, or else the profile's file in ~/.tokipay. A missing
+// default profile is not an error; a missing named profile is.
+func (f *clientFlags) configPath() (string, error) {
+	if path := firstNonEmpty(f.configFile, os.Getenv(tokipayconfig.EnvConfigFile)); path != "" {
+		return path, nil
+	}
+
+	name := firstNonEmpty(f.profile, os.Getenv("TOKIPAY_PROFILE"))
+	home, err := os.UserHomeDir()
+	if err != nil {
+		if name != "" {
+			return "", fmt.Errorf("failed to find profile %q: %w", name, err)
+		}
+		return "", nil
+	}
+	dir := filepath.Join(home, ".tokipay")
+	for _, ext := range profileExtensions {
+		path := filepath.Join(dir, firstNonEmpty(name, "default")+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read profile: %w", err)
+		}
+	}
+	if name != "" {
+		return "", fmt.Errorf("profile %q not found in %s", name, dir)
+	}
+	return "", nil
+}
+
+// client builds a TokiPay client from the resolved settings
+func (f *clientFlags) client() (*tokipay.TokiPayClient, error) {
+	if f.output != formatTable && f.output != formatJSON {
+		return nil, usageError("unknown output format %q, want %s or %s", f.output, formatTable, formatJSON)
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Client()
+}
+
+// promptPassword reads the password from the terminal without echoing it.
+// It returns an empty password when stdin is not a terminal.
+func promptPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", nil
+	}
+	fmt.Fprint(os.Stderr, "TokiPay password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(password), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
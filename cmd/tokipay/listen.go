@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// receivedCallback is a callback recorded by listen
+type receivedCallback struct {
+	Time      time.Time               `json:"time"`
+	Path      string                  `json:"path"`
+	Callback  tokipay.CallbackRequest `json:"callback"`
+	Headers   tokipay.CallbackHeaders `json:"headers"`
+	VATError  string                  `json:"vatError,omitempty"`
+	Forwarded int                     `json:"forwardedStatus,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+}
+
+// listener receives callbacks, prints and records them and optionally
+// relays them to a local application
+type listener struct {
+	output  string
+	forward string
+	client  *http.Client
+
+	mu     sync.Mutex
+	record io.Writer
+}
+
+func runListen(args []string) error {
+	fs := newCommandFlagSet("listen", "")
+	port := fs.Int("port", 8080, "port to listen on")
+	host := fs.String("host", "127.0.0.1", "interface to listen on")
+	recordPath := fs.String("record", "", "append received callbacks to this JSONL file")
+	forward := fs.String("forward", "", "relay callbacks to this base URL, keeping the request path")
+	output := fs.String("o", formatTable, "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageError("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if *output != formatTable && *output != formatJSON {
+		return usageError("unknown output format %q, want %s or %s", *output, formatTable, formatJSON)
+	}
+
+	l := &listener{
+		output:  *output,
+		forward: strings.TrimSuffix(*forward, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if *recordPath != "" {
+		f, err := os.OpenFile(*recordPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open record file: %w", err)
+		}
+		defer f.Close()
+		l.record = f
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(*host, fmt.Sprint(*port)))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "listening for callbacks on http://%s\n", ln.Addr())
+	if l.forward != "" {
+		fmt.Fprintf(os.Stderr, "forwarding callbacks to %s\n", l.forward)
+	}
+
+	srv := &http.Server{Handler: l, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP handles one callback with tokipay.CallbackHandler, so it
+// answers TokiPay as an application using the package would. When
+// forwarding, a callback the application does not accept fails.
+func (l *listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	received := receivedCallback{Time: time.Now(), Path: r.URL.Path}
+	parsed := false
+	rw := &responseCapture{ResponseWriter: w}
+	tokipay.CallbackHandler(func(callback tokipay.CallbackRequest, headers tokipay.CallbackHeaders) error {
+		parsed = true
+		received.Callback = callback
+		received.Headers = headers
+		if headers.VATError != nil {
+			received.VATError = headers.VATError.Error()
+		}
+		if l.forward == "" {
+			return nil
+		}
+		status, err := l.relay(r, body)
+		received.Forwarded = status
+		if err != nil {
+			received.Error = err.Error()
+		}
+		return err
+	}).ServeHTTP(rw, r)
+
+	if !parsed {
+		var resp tokipay.ErrorResponse
+		json.Unmarshal(rw.body.Bytes(), &resp)
+		received.Error = resp.Message
+	}
+	l.report(received)
+}
+
+// responseCapture keeps a copy of the response body written through it
+type responseCapture struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (c *responseCapture) Write(p []byte) (int, error) {
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+// relay posts the callback to the forward URL with its original path and
+// VAT headers and returns the application's status code. A status other
+// than 2xx is an error.
+func (l *listener) relay(r *http.Request, body []byte) (int, error) {
+	target := l.forward + r.URL.RequestURI()
+	req, err := http.NewRequest(r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create forward request: %w", err)
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	for _, name := range []string{tokipay.HeaderVATID, tokipay.HeaderVATType} {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to forward callback: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("application answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// report prints a received callback and appends it to the record file
+func (l *listener) report(c receivedCallback) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.record != nil {
+		line, _ := json.Marshal(c)
+		l.record.Write(append(line, '\n'))
+	}
+
+	if l.output == formatJSON {
+		json.NewEncoder(os.Stdout).Encode(c)
+		return
+	}
+
+	fmt.Printf("%s  POST %s\n", c.Time.Format("15:04:05"), c.Path)
+	if c.Error != "" && c.Callback.RequestID == "" {
+		fmt.Printf("  error:       %s\n\n", c.Error)
+		return
+	}
+	fmt.Printf("  status:      %s\n", c.Callback.Status)
+	fmt.Printf("  orderId:     %s\n", c.Callback.OrderID)
+	fmt.Printf("  requestId:   %s\n", c.Callback.RequestID)
+	fmt.Printf("  amount:      %v\n", c.Callback.Amount)
+	if c.Headers.VATID != "" || c.Headers.VATType != "" {
+		fmt.Printf("  VAT_ID:      %s\n", c.Headers.VATID)
+		fmt.Printf("  VAT_TYPE:    %s\n", c.Headers.VATType)
+	}
+	if c.VATError != "" {
+		fmt.Printf("  VAT error:   %s\n", c.VATError)
+	}
+	if c.Forwarded != 0 {
+		fmt.Printf("  forwarded:   %d %s\n", c.Forwarded, http.StatusText(c.Forwarded))
+	}
+	if c.Error != "" {
+		fmt.Printf("  error:       %s\n", c.Error)
+	}
+	fmt.Println()
+}
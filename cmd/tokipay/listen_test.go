@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+func TestListener(t *testing.T) {
+	var forwarded []string
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.Path+" "+r.Header.Get(tokipay.HeaderVATID))
+		if r.URL.Path == "/reject" {
+			http.Error(w, "order not found", http.StatusNotFound)
+		}
+	}))
+	defer app.Close()
+
+	callback := `{"orderId":"ORDER_1","requestId":"rq-000001","status":"SUCCESS","amount":1000}`
+	tests := []struct {
+		name    string
+		forward string
+		path    string
+		body    string
+		vatID   string
+		status  int
+		record  string // substring of the recorded callback
+	}{
+		{name: "received", path: "/success", body: callback, status: http.StatusOK, record: `"requestId":"rq-000001"`},
+		{name: "invalid", path: "/success", body: "{", status: http.StatusBadRequest, record: `"error":"`},
+		{name: "invalid VAT headers", path: "/success", body: callback, vatID: "12", status: http.StatusOK, record: `"vatError":"`},
+		{name: "forwarded", forward: app.URL, path: "/success", body: callback, vatID: "5317878", status: http.StatusOK, record: `"forwardedStatus":200`},
+		{name: "rejected by the application", forward: app.URL, path: "/reject", body: callback, status: http.StatusInternalServerError, record: "application answered 404"},
+		{name: "application down", forward: "http://127.0.0.1:1", path: "/success", body: callback, status: http.StatusInternalServerError, record: "failed to forward callback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record bytes.Buffer
+			l := &listener{output: formatJSON, forward: tt.forward, client: &http.Client{Timeout: 5 * time.Second}, record: &record}
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.vatID != "" {
+				req.Header.Set(tokipay.HeaderVATID, tt.vatID)
+				req.Header.Set(tokipay.HeaderVATType, "ORGANIZATION")
+			}
+			w := httptest.NewRecorder()
+			captureOutput(t, func() { l.ServeHTTP(w, req) })
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var received receivedCallback
+			if err := json.Unmarshal(record.Bytes(), &received); err != nil {
+				t.Fatalf("recorded callback %q: %v", record.String(), err)
+			}
+			if !strings.Contains(record.String(), tt.record) {
+				t.Errorf("recorded callback %s does not contain %q", record.String(), tt.record)
+			}
+		})
+	}
+
+	if len(forwarded) != 2 || forwarded[0] != "/success 5317878" {
+		t.Errorf("forwarded callbacks = %q", forwarded)
+	}
+}
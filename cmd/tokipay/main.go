@@ -0,0 +1,121 @@
+// Command tokipay checks, creates and refunds TokiPay payments from the
+// command line.
+//
+// Usage:
+//
+//	tokipay <command> [flags] [args]
+//
+// Settings are resolved with package tokipayconfig, as in a service: the
+// config file (-config, or the profile ~/.tokipay/<profile>.yaml), then the
+// TOKIPAY_* environment variables, then flags. The password has no flag;
+// without TOKIPAY_PASSWORD or a config file, it is asked for on the terminal.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a tokipay subcommand
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"token":      {"Print an access token", runToken},
+	"qr":         {"Create a QR payment request", runQR},
+	"mobile":     {"Create a mobile payment request", runMobile},
+	"deeplink":   {"Create a deeplink payment request", runDeeplink},
+	"status":     {"Check the status of payment requests", runStatus},
+	"cancel":     {"Cancel a payment request", runCancel},
+	"refund":     {"Refund a payment", runRefund},
+	"vat":        {"Register organization VAT details", runVAT},
+	"watch":      {"Poll payment requests until they are final", runWatch},
+	"listen":     {"Receive callbacks locally and optionally relay them", runListen},
+	"reconcile":  {"Reconcile stored payments with TokiPay", runReconcile},
+	"settlement": {"Match a settlement file against stored payments", runSettlement},
+	"autocancel": {"Cancel payment requests left pending too long", runAutoCancel},
+	"vatreport":  {"List failed VAT registrations and resubmit them", runVATReport},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		return exitUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tokipay: unknown command %q\n\n", args[0])
+		usage()
+		return exitUsage
+	}
+
+	if err := cmd.run(args[1:]); err != nil {
+		var exit *exitError
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			return exitUsage
+		case errors.As(err, &exit):
+			if exit.err != nil {
+				fmt.Fprintf(os.Stderr, "tokipay %s: %v\n", args[0], exit.err)
+			}
+			return exit.code
+		default:
+			fmt.Fprintf(os.Stderr, "tokipay %s: %v\n", args[0], err)
+			return exitError1
+		}
+	}
+	return exitOK
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: tokipay <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'tokipay <command> -h' for command flags.")
+}
+
+// Exit codes
+const (
+	exitOK     = 0
+	exitError1 = 1
+	exitUsage  = 2
+)
+
+// exitError makes the command exit with a specific code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+// usageError reports a command line mistake and exits with exitUsage
+func usageError(format string, args ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
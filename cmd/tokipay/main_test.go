@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/sqlstore"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipayconfig"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+// clearEnv ignores the TOKIPAY_* variables and profiles of the environment
+func clearEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	for _, key := range []string{"TOKIPAY_ENV", "TOKIPAY_BASE_URL", "TOKIPAY_USERNAME", "TOKIPAY_PASSWORD",
+		"TOKIPAY_USERNAME_FILE", "TOKIPAY_PASSWORD_FILE", "TOKIPAY_MERCHANT_ID", "TOKIPAY_TIMEOUT",
+		"TOKIPAY_CONFIG_FILE", "TOKIPAY_PROFILE", "TOKIPAY_DB"} {
+		t.Setenv(key, "")
+	}
+}
+
+// runCLI runs the command line with stdout and stderr captured, without
+// the settings of the environment; the password, which has no flag, is the
+// test server's.
+func runCLI(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	clearEnv(t)
+	t.Setenv("TOKIPAY_PASSWORD", tokipaytest.DefaultPassword)
+
+	stdout, stderr = captureOutput(t, func() { code = run(args) })
+	return code, stdout, stderr
+}
+
+// captureOutput runs fn with stdout and stderr written to files and
+// returns what was written
+func captureOutput(t *testing.T, fn func()) (stdout, stderr string) {
+	t.Helper()
+	outFile, errFile := captureFile(t), captureFile(t)
+	defer func(out, err *os.File) { os.Stdout, os.Stderr = out, err }(os.Stdout, os.Stderr)
+	os.Stdout, os.Stderr = outFile, errFile
+
+	fn()
+	return readCapture(t, outFile), readCapture(t, errFile)
+}
+
+func captureFile(t *testing.T) *os.File {
+	f, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func readCapture(t *testing.T, f *os.File) string {
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// clientArgs are the flags that point a command at srv
+func clientArgs(srv *tokipaytest.Server) []string {
+	return []string{"-base-url", srv.URL, "-username", srv.Username, "-merchant-id", srv.MerchantID}
+}
+
+// newPaymentDB creates a migrated SQLite payment database and returns its
+// path and a recording client on srv that stores into it
+func newPaymentDB(t *testing.T, srv *tokipaytest.Server) (string, *tokipay.RecordingClient) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "payments.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := sqlstore.New(db, sqlstore.SQLite)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return path, tokipay.NewRecordingClient(srv.NewClient(), store)
+}
+
+func createQR(t *testing.T, client tokipay.TokiPay, orderID string) string {
+	t.Helper()
+	resp, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    orderID,
+		Amount:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.RequestID
+}
+
+type cliTest struct {
+	name   string
+	args   []string
+	code   int
+	output string // substring of stdout, or of stderr when the command fails
+}
+
+func runCLITests(t *testing.T, tests []cliTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tt.code, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.output) && !strings.Contains(stderr, tt.output) {
+				t.Errorf("output does not contain %q\nstdout:\n%s\nstderr:\n%s", tt.output, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	pending := createQR(t, client, "ORDER_PENDING")
+	cancelled := createQR(t, client, "ORDER_CANCELLED")
+	approved := createQR(t, client, "ORDER_APPROVED")
+	if err := srv.Approve(approved); err != nil {
+		t.Fatal(err)
+	}
+	paid, _ := srv.Payment(approved)
+
+	with := func(args ...string) []string {
+		return slices.Concat(args[:1], clientArgs(srv), args[1:])
+	}
+	// pay adds the callback URLs the payment creation commands require
+	pay := func(args ...string) []string {
+		return with(slices.Concat(args, []string{"-success-url", "https://example.com/success", "-failure-url", "https://example.com/failure"})...)
+	}
+
+	runCLITests(t, []cliTest{
+		{"no command", nil, exitUsage, "Usage: tokipay <command>"},
+		{"unknown command", []string{"pay"}, exitUsage, `unknown command "pay"`},
+		{"help", []string{"status", "-h"}, exitUsage, "Usage: tokipay status"},
+		{"token", with("token"), exitOK, "AccessToken"},
+		{"bad credentials", []string{"token", "-base-url", srv.URL, "-username", "x", "-merchant-id", "z"}, exitError1, "invalid username or password"},
+		{"password flag", with("token", "-password", "secret"), exitError1, "flag provided but not defined: -password"},
+		{"unknown format", with("token", "-o", "xml"), exitUsage, `unknown output format "xml"`},
+		{"qr", pay("qr", "-order-id", "ORDER_QR", "-amount", "1500"), exitOK, "RequestID"},
+		{"qr without order", pay("qr", "-amount", "1500"), exitUsage, "-order-id is required"},
+		{"qr duplicate order", pay("qr", "-order-id", "ORDER_QR", "-amount", "1500"), exitError1, "duplicate orderId"},
+		{"mobile without phone", pay("mobile", "-order-id", "ORDER_M", "-amount", "1500"), exitUsage, "-phone"},
+		{"deeplink json", pay("deeplink", "-o", "json", "-order-id", "ORDER_D", "-amount", "1500"), exitOK, `"deeplink":`},
+		{"status", with("status", pending, approved), exitOK, tokipay.StatusApproved},
+		{"status unknown", with("status", pending, "missing"), exitError1, "1 of 2 status checks failed"},
+		{"status without id", with("status"), exitUsage, "at least one request ID"},
+		{"cancel", with("cancel", cancelled), exitOK, tokipay.StatusCancelled},
+		{"cancel twice", with("cancel", cancelled), exitError1, "already CANCELLED"},
+		{"refund", with("refund", "-amount", "400", paid.TransNumber), exitOK, paid.TransNumber},
+		{"refund unknown", with("refund", "999"), exitError1, "transaction not found"},
+		{"vat without ddtd", with("vat", paid.TransactionID), exitUsage, "-ddtd is required"},
+	})
+}
+
+func TestClientConfig(t *testing.T) {
+	clearEnv(t)
+	dir := filepath.Join(os.Getenv("HOME"), ".tokipay")
+	writeFile := func(path, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(filepath.Join(dir, "default.yaml"), "environment: prod\nusername: default-user\npassword: default-pass\nmerchantId: default-merchant\ntimeout: 10s\n")
+	writeFile(filepath.Join(dir, "brand-b.json"), `{"username": "b-user", "password": "b-pass", "merchantId": "b-merchant"}`)
+	other := filepath.Join(t.TempDir(), "tokipay.toml")
+	writeFile(other, "username = \"toml-user\"\npassword = \"toml-pass\"\nmerchantId = \"toml-merchant\"\n")
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		want     tokipayconfig.Config
+		contains string // error
+	}{
+		{name: "default profile", want: tokipayconfig.Config{Environment: "prod", Username: "default-user", MerchantID: "default-merchant", Timeout: tokipayconfig.Duration(10 * time.Second)}},
+		{name: "env over file", env: map[string]string{"TOKIPAY_MERCHANT_ID": "env-merchant", "TOKIPAY_ENV": "test"},
+			want: tokipayconfig.Config{Environment: "test", Username: "default-user", MerchantID: "env-merchant", Timeout: tokipayconfig.Duration(10 * time.Second)}},
+		{name: "flags over env", env: map[string]string{"TOKIPAY_MERCHANT_ID": "env-merchant"}, args: []string{"-merchant-id", "flag-merchant", "-timeout", "5s"},
+			want: tokipayconfig.Config{Environment: "prod", Username: "default-user", MerchantID: "flag-merchant", Timeout: tokipayconfig.Duration(5 * time.Second)}},
+		{name: "named profile", args: []string{"-profile", "brand-b"},
+			want: tokipayconfig.Config{Environment: "test", Username: "b-user", MerchantID: "b-merchant", Timeout: tokipayconfig.Duration(30 * time.Second)}},
+		{name: "profile from env", env: map[string]string{"TOKIPAY_PROFILE": "brand-b"},
+			want: tokipayconfig.Config{Environment: "test", Username: "b-user", MerchantID: "b-merchant", Timeout: tokipayconfig.Duration(30 * time.Second)}},
+		{name: "config file", env: map[string]string{"TOKIPAY_CONFIG_FILE": other},
+			want: tokipayconfig.Config{Environment: "test", Username: "toml-user", MerchantID: "toml-merchant", Timeout: tokipayconfig.Duration(30 * time.Second)}},
+		{name: "missing profile", args: []string{"-profile", "brand-c"}, contains: `profile "brand-c" not found`},
+		{name: "invalid setting", args: []string{"-env", "staging"}, contains: `environment "staging" is not prod or test`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			fs, cf := newFlagSet("test", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := cf.config()
+			if tt.contains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.contains) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.contains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Environment != tt.want.Environment || cfg.Username != tt.want.Username || cfg.MerchantID != tt.want.MerchantID || cfg.Timeout != tt.want.Timeout {
+				t.Errorf("config = %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlattenTimestamp(t *testing.T) {
+	ts := tokipay.Timestamp{Time: time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)}
+	fields := flatten("", reflect.ValueOf(struct {
+		Status    string
+		Timestamp tokipay.Timestamp
+	}{"APPROVED", ts}))
+	want := []field{{"Status", "APPROVED"}, {"Timestamp", ts.String()}}
+	if !slices.Equal(fields, want) {
+		t.Errorf("flatten = %+v, want %+v", fields, want)
+	}
+}
+
+func TestWatchCommand(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	approved := createQR(t, client, "ORDER_APPROVED")
+	expired := createQR(t, client, "ORDER_EXPIRED")
+	pending := createQR(t, client, "ORDER_PENDING")
+	if err := srv.Approve(approved); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Expire(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	watch := func(args ...string) []string {
+		return slices.Concat([]string{"watch"}, clientArgs(srv), []string{"-interval", "10ms", "-max-interval", "20ms"}, args)
+	}
+	runCLITests(t, []cliTest{
+		{"approved", watch(approved), exitOK, tokipay.StatusApproved},
+		{"expired", watch(approved, expired), exitExpired, tokipay.StatusExpired},
+		{"timeout", watch("-max-wait", "50ms", pending), exitTimeout, "stopped waiting"},
+		{"status errors", watch("-max-errors", "2", "missing"), exitError1, "payment request not found"},
+		{"bad interval", watch("-interval", "1s", "-max-interval", "10ms", pending), exitUsage, "-interval must be positive"},
+	})
+}
+
+func TestStoreCommands(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	db, client := newPaymentDB(t, srv)
+
+	approved := createQR(t, client, "ORDER_APPROVED")
+	abandoned := createQR(t, client, "ORDER_ABANDONED")
+	if err := srv.Approve(approved); err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(args ...string) []string {
+		return slices.Concat(args[:1], clientArgs(srv), []string{"-db", db}, args[1:])
+	}
+	runCLITests(t, []cliTest{
+		{"reconcile without db", append([]string{"reconcile"}, clientArgs(srv)...), exitUsage, "-db is required"},
+		{"reconcile dry run", with("reconcile", "-min-age", "0", "-dry-run"), exitDiscrepancies, "(dry run)"},
+		{"reconcile", with("reconcile", "-min-age", "0"), exitDiscrepancies, tokipay.DiscrepancyStatusChanged},
+		{"reconcile approved", with("reconcile", "-min-age", "0", "-status", tokipay.StatusApproved), exitDiscrepancies, tokipay.DiscrepancyMissedCallback},
+		{"autocancel bad ttl", with("autocancel", "-once", "-ttl", "0"), exitUsage, "-ttl and -interval must be positive"},
+		{"autocancel", with("autocancel", "-once", "-ttl", "1ns"), exitOK, abandoned},
+		{"vatreport", with("vatreport"), exitOK, "failed VAT registrations 0"},
+		{"vatreport keys without resubmit", with("vatreport", "key"), exitUsage, "only accepted with -resubmit"},
+	})
+
+	if p, _ := srv.Payment(abandoned); p.Status != tokipay.StatusCancelled {
+		t.Errorf("abandoned payment is %s on TokiPay, want %s", p.Status, tokipay.StatusCancelled)
+	}
+}
+
+func TestSettlementCommand(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	db, _ := newPaymentDB(t, srv)
+
+	file := filepath.Join(t.TempDir(), "settlement.csv")
+	if err := os.WriteFile(file, []byte("bad"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runCLITests(t, []cliTest{
+		{"no file", []string{"settlement", "-db", db}, exitUsage, "expected one settlement file"},
+		{"unknown format", []string{"settlement", "-db", db, "-o", "xml", file}, exitUsage, `unknown output format "xml"`},
+		{"missing file", []string{"settlement", "-db", db, file + ".missing"}, exitError1, "no such file"},
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats selectable with -o
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// printResult writes v to stdout in the selected format. Tables list one
+// field per row, or one row per element when v is a slice of structs.
+func printResult(format string, v any) error {
+	if format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Slice {
+		printRows(w, rv)
+	} else {
+		fmt.Fprintln(w, "FIELD\tVALUE")
+		for _, f := range flatten("", rv) {
+			fmt.Fprintf(w, "%s\t%s\n", f.name, f.value)
+		}
+	}
+	return w.Flush()
+}
+
+func printRows(w io.Writer, rv reflect.Value) {
+	for i := 0; i < rv.Len(); i++ {
+		fields := flatten("", reflect.Indirect(rv.Index(i)))
+		if i == 0 {
+			for j, f := range fields {
+				fmt.Fprint(w, tabbed(j, f.name))
+			}
+			fmt.Fprintln(w)
+		}
+		for j, f := range fields {
+			fmt.Fprint(w, tabbed(j, f.value))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+type field struct {
+	name  string
+	value string
+}
+
+// flatten lists the exported fields of a struct, descending into nested
+// structs and pointers with dotted names
+func flatten(prefix string, rv reflect.Value) []field {
+	if rv.Kind() != reflect.Struct {
+		return []field{{name: prefix, value: fmt.Sprint(rv.Interface())}}
+	}
+
+	var fields []field
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name := sf.Name
+		if sf.Anonymous {
+			name = prefix
+		} else if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				// keep the columns of nil structs so table rows line up
+				for _, f := range flatten(name, reflect.Zero(fv.Type().Elem())) {
+					fields = append(fields, field{name: f.name, value: "-"})
+				}
+				continue
+			}
+			fv = fv.Elem()
+		}
+		switch {
+		case fv.Type() == reflect.TypeOf(time.Time{}):
+			fields = append(fields, field{name: name, value: fv.Interface().(time.Time).Format(time.RFC3339)})
+		case fv.Kind() == reflect.Struct && fv.Type().Implements(stringerType):
+			// formatted values such as timestamps are one column
+			fields = append(fields, field{name: name, value: fv.Interface().(fmt.Stringer).String()})
+		case fv.Kind() == reflect.Struct:
+			fields = append(fields, flatten(name, fv)...)
+		default:
+			fields = append(fields, field{name: name, value: fmt.Sprint(fv.Interface())})
+		}
+	}
+	return fields
+}
+
+func tabbed(i int, s string) string {
+	if i == 0 {
+		return s
+	}
+	return "\t" + s
+}
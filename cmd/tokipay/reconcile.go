@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// exitDiscrepancies is returned by reconcile when discrepancies were found
+const exitDiscrepancies = 6
+
+func runReconcile(args []string) error {
+	fs, cf := newFlagSet("reconcile", "")
+	var sf storeFlags
+	sf.register(fs)
+	var statuses stringsFlag
+	fs.Var(&statuses, "status", "local status to reconcile (repeatable, default PENDING)")
+	var requestIDs stringsFlag
+	fs.Var(&requestIDs, "request-id", "request ID to check even if it is not stored (repeatable)")
+	dryRun := fs.Bool("dry-run", false, "report discrepancies without updating the database")
+	minAge := fs.Duration("min-age", time.Minute, "skip payments created more recently than this")
+	limit := fs.Int("limit", 0, "maximum number of payments to check, 0 checks all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, closeStore, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	report, err := tokipay.Reconcile(ctx, client, store, tokipay.ReconcileOptions{
+		DryRun:     *dryRun,
+		Statuses:   statuses,
+		MinAge:     *minAge,
+		Limit:      *limit,
+		RequestIDs: requestIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	if cf.output == formatJSON {
+		if err := printResult(cf.output, report); err != nil {
+			return err
+		}
+	} else {
+		if len(report.Discrepancies) > 0 {
+			if err := printResult(cf.output, report.Discrepancies); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		mode := ""
+		if report.DryRun {
+			mode = " (dry run)"
+		}
+		fmt.Printf("checked %d, updated %d, discrepancies %d%s\n",
+			report.Checked, report.Updated, len(report.Discrepancies), mode)
+	}
+
+	if len(report.Discrepancies) > 0 {
+		return &exitError{code: exitDiscrepancies}
+	}
+	return nil
+}
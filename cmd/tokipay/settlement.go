@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// formatCSV is the extra output format of the settlement command
+const formatCSV = "csv"
+
+func runSettlement(args []string) error {
+	fs := newCommandFlagSet("settlement", "<file.csv|->")
+	var sf storeFlags
+	sf.register(fs)
+	output := fs.String("o", formatTable, "output format: table, json or csv")
+	tolerance := fs.Float64("fee-tolerance", 0, "largest fee difference still reported as matched")
+	feeRate := fs.Float64("fee-rate", 0, "expected fee as a fraction of the amount when the stored fee is unknown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("expected one settlement file")
+	}
+	switch *output {
+	case formatTable, formatJSON, formatCSV:
+	default:
+		return usageError("unknown output format %q, want %s, %s or %s", *output, formatTable, formatJSON, formatCSV)
+	}
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	lines, err := tokipay.ParseSettlement(in)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store, closeStore, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	report, err := tokipay.MatchSettlement(ctx, store, lines, tokipay.SettlementOptions{
+		FeeTolerance: *tolerance,
+		FeeRate:      *feeRate,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case formatJSON:
+		err = report.WriteJSON(os.Stdout)
+	case formatCSV:
+		err = report.WriteCSV(os.Stdout)
+	default:
+		if len(report.Lines) > 0 {
+			if err := printResult(formatTable, report.Lines); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		fmt.Printf("matched %d, unmatched %d, fee variances %d, amount mismatches %d\n",
+			report.Matched, report.Unmatched, report.FeeVariances, report.AmountMismatches)
+		fmt.Printf("total amount %.2f, total fee %.2f, fee variance %.2f\n",
+			report.TotalAmount, report.TotalFee, report.TotalFeeVariance)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.Discrepancies() > 0 {
+		return &exitError{code: exitDiscrepancies}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/techpartners-asia/tokipay-third-party-service-go/sqlstore"
+)
+
+// storeFlags select the SQLite payment database used by store-backed commands
+type storeFlags struct {
+	path string
+}
+
+func (f *storeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.path, "db", "", "SQLite payment database (env TOKIPAY_DB)")
+}
+
+// enabled reports whether a payment database was selected
+func (f *storeFlags) enabled() bool {
+	f.path = firstNonEmpty(f.path, os.Getenv("TOKIPAY_DB"))
+	return f.path != ""
+}
+
+// open opens and migrates the payment database. The returned func closes it.
+func (f *storeFlags) open(ctx context.Context) (*sqlstore.Store, func(), error) {
+	if !f.enabled() {
+		return nil, nil, usageError("-db is required")
+	}
+	if _, err := os.Stat(f.path); err != nil {
+		return nil, nil, fmt.Errorf("failed to open payment database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", f.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open payment database: %w", err)
+	}
+
+	store := sqlstore.New(db, sqlstore.SQLite)
+	if err := store.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return store, func() { db.Close() }, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// vatFailure is a table row of a failed VAT registration
+type vatFailure struct {
+	PaymentKey    string
+	OrderID       string
+	TransactionID string
+	DDTD          string
+	Attempts      int
+	UpdatedAt     time.Time
+	Error         string
+}
+
+func runVATReport(args []string) error {
+	fs, cf := newFlagSet("vatreport", "[payment-key...]")
+	var sf storeFlags
+	sf.register(fs)
+	resubmit := fs.Bool("resubmit", false, "send the stored requests of the listed, or all, failed registrations again")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 && !*resubmit {
+		return usageError("payment keys are only accepted with -resubmit")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, closeStore, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	w := tokipay.NewVATWorkflow(nil, store, store, nil)
+	failed, err := w.Failed(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *resubmit {
+		if w.Client, err = cf.client(); err != nil {
+			return err
+		}
+		for _, r := range failed {
+			if fs.NArg() > 0 && !slices.Contains(fs.Args(), r.PaymentKey) {
+				continue
+			}
+			if _, err := w.Resubmit(ctx, r.PaymentKey); err != nil {
+				fmt.Fprintf(os.Stderr, "tokipay vatreport: %s: %v\n", r.PaymentKey, err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "tokipay vatreport: %s: registered\n", r.PaymentKey)
+		}
+		if failed, err = w.Failed(ctx); err != nil {
+			return err
+		}
+	}
+
+	if cf.output == formatJSON {
+		if err := printResult(cf.output, failed); err != nil {
+			return err
+		}
+	} else {
+		rows := make([]vatFailure, len(failed))
+		for i, r := range failed {
+			rows[i] = vatFailure{
+				PaymentKey:    r.PaymentKey,
+				OrderID:       r.OrderID,
+				TransactionID: r.Request.TransactionID,
+				DDTD:          r.Request.DDTD,
+				Attempts:      r.Attempts,
+				UpdatedAt:     r.UpdatedAt,
+				Error:         r.Error,
+			}
+		}
+		if len(rows) > 0 {
+			if err := printResult(cf.output, rows); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		fmt.Printf("failed VAT registrations %d\n", len(failed))
+	}
+
+	if len(failed) > 0 {
+		return &exitError{code: exitDiscrepancies}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// Exit codes reported by watch. When payments end differently the highest
+// code wins, so a single cancellation outweighs any number of approvals.
+const (
+	exitExpired     = 3
+	exitCancelled   = 4
+	exitTimeout     = 5
+	exitInterrupted = 130
+)
+
+// watchEvent is a status transition observed by watch
+type watchEvent struct {
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"requestId"`
+	From        string    `json:"from,omitempty"`
+	Status      string    `json:"status"`
+	TransNumber string    `json:"transNumber,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// watcher polls the status of one payment request with backoff
+type watcher struct {
+	client      tokipay.TokiPay
+	interval    time.Duration
+	maxInterval time.Duration
+	maxErrors   int
+	emit        func(watchEvent)
+}
+
+func runWatch(args []string) error {
+	fs, cf := newFlagSet("watch", "<requestId>...")
+	interval := fs.Duration("interval", 2*time.Second, "initial polling interval")
+	maxInterval := fs.Duration("max-interval", 30*time.Second, "maximum polling interval")
+	maxWait := fs.Duration("max-wait", 0, "give up after this long, 0 waits forever")
+	maxErrors := fs.Int("max-errors", 5, "consecutive status errors tolerated per payment")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("at least one request ID is required")
+	}
+	if *interval <= 0 || *maxInterval < *interval {
+		return usageError("-interval must be positive and not above -max-interval")
+	}
+
+	client, err := cf.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxWait)
+		defer cancel()
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(os.Stdout)
+	w := &watcher{
+		client:      client,
+		interval:    *interval,
+		maxInterval: *maxInterval,
+		maxErrors:   *maxErrors,
+		emit: func(e watchEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if cf.output == formatJSON {
+				enc.Encode(e)
+				return
+			}
+			printEvent(e)
+		},
+	}
+
+	final := make([]statusRow, fs.NArg())
+	var wg sync.WaitGroup
+	for i, requestID := range fs.Args() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			final[i] = w.watch(ctx, requestID)
+		}()
+	}
+	wg.Wait()
+
+	if cf.output == formatTable {
+		fmt.Println()
+		if err := printResult(cf.output, final); err != nil {
+			return err
+		}
+	}
+
+	code := watchExitCode(final)
+	if code == exitTimeout && errors.Is(ctx.Err(), context.Canceled) {
+		code = exitInterrupted
+	}
+	if code != exitOK {
+		return &exitError{code: code}
+	}
+	return nil
+}
+
+// watch polls until the payment reaches a final status, errors persist or
+// ctx is done. Polling backs off while the status is unchanged or failing.
+func (w *watcher) watch(ctx context.Context, requestID string) statusRow {
+	row := statusRow{RequestID: requestID}
+	delay := w.interval
+	errCount := 0
+
+	for {
+		resp, err := w.client.CheckPaymentStatus(requestID)
+		switch {
+		case err != nil:
+			errCount++
+			w.emit(watchEvent{Time: time.Now(), RequestID: requestID, Status: row.Status, Error: err.Error()})
+			if errCount >= w.maxErrors {
+				row.Error = err.Error()
+				return row
+			}
+			delay = min(delay*3/2, w.maxInterval)
+		case resp.Status != row.Status:
+			errCount = 0
+			w.emit(watchEvent{
+				Time:        time.Now(),
+				RequestID:   requestID,
+				From:        row.Status,
+				Status:      resp.Status,
+				TransNumber: resp.TransNumber,
+			})
+			row.PaymentStatusResponse = *resp
+			row.Error = ""
+			delay = w.interval
+			if tokipay.IsFinalStatus(resp.Status) {
+				return row
+			}
+		default:
+			errCount = 0
+			delay = min(delay*3/2, w.maxInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			row.stopped = true
+			if row.Error == "" {
+				row.Error = "stopped waiting: " + context.Cause(ctx).Error()
+			}
+			return row
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watchExitCode maps the final statuses to the watch exit code
+func watchExitCode(rows []statusRow) int {
+	code := exitOK
+	for _, row := range rows {
+		c := exitError1
+		switch {
+		case row.Status == tokipay.StatusApproved:
+			c = exitOK
+		case row.Status == tokipay.StatusExpired:
+			c = exitExpired
+		case row.Status == tokipay.StatusCancelled:
+			c = exitCancelled
+		case row.stopped:
+			c = exitTimeout
+		}
+		code = max(code, c)
+	}
+	return code
+}
+
+func printEvent(e watchEvent) {
+	ts := e.Time.Format("15:04:05")
+	switch {
+	case e.Error != "":
+		fmt.Fprintf(os.Stdout, "%s  %s  error: %s\n", ts, e.RequestID, e.Error)
+	case e.From == "":
+		fmt.Fprintf(os.Stdout, "%s  %s  %s\n", ts, e.RequestID, e.Status)
+	case e.TransNumber != "":
+		fmt.Fprintf(os.Stdout, "%s  %s  %s -> %s  trans=%s\n", ts, e.RequestID, e.From, e.Status, e.TransNumber)
+	default:
+		fmt.Fprintf(os.Stdout, "%s  %s  %s -> %s\n", ts, e.RequestID, e.From, e.Status)
+	}
+}
@@ -1,6 +1,10 @@
 package tokipay
 
 const (
+	// Base URLs
+	ProductionBaseURL = "https://ms-api.toki.mn"
+	TestBaseURL       = "https://qams-api.toki.mn"
+
 	// API Endpoints
 	TokenEndpoint         = "/third-party-service/v1/auth/token"
 	QRPaymentEndpoint     = "/third-party-service/v1/payment-request/merchant-qr"
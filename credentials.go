@@ -0,0 +1,140 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variables read by a zero EnvCredentials
+const (
+	EnvUsername = "TOKIPAY_USERNAME"
+	EnvPassword = "TOKIPAY_PASSWORD"
+)
+
+// Credentials are the username and password of the TokiPay token endpoint
+type Credentials struct {
+	Username string
+	Password Secret
+}
+
+func (c Credentials) validate() error {
+	if c.Username == "" || c.Password == "" {
+		return errors.New("credentials have no username or password")
+	}
+	return nil
+}
+
+// CredentialsProvider supplies the credentials of a client. The client asks
+// for them before every call, so a provider that returns new credentials
+// rotates them: the cached access token of the old credentials is dropped
+// and the next call fetches a token with the new ones.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials provides fixed credentials
+type StaticCredentials Credentials
+
+// Credentials implements CredentialsProvider
+func (s StaticCredentials) Credentials(context.Context) (Credentials, error) {
+	c := Credentials(s)
+	return c, c.validate()
+}
+
+// EnvCredentials reads the credentials from environment variables on every
+// call. Empty variable names default to EnvUsername and EnvPassword.
+type EnvCredentials struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+// Credentials implements CredentialsProvider
+func (e EnvCredentials) Credentials(context.Context) (Credentials, error) {
+	usernameVar, passwordVar := e.UsernameVar, e.PasswordVar
+	if usernameVar == "" {
+		usernameVar = EnvUsername
+	}
+	if passwordVar == "" {
+		passwordVar = EnvPassword
+	}
+
+	c := Credentials{Username: os.Getenv(usernameVar), Password: Secret(os.Getenv(passwordVar))}
+	if c.Username == "" || c.Password == "" {
+		return c, fmt.Errorf("environment variables %s and %s must be set", usernameVar, passwordVar)
+	}
+	return c, nil
+}
+
+// FileCredentials reads the credentials from two files, such as the keys of
+// a Kubernetes secret mounted as a volume. The files are checked on every
+// call and read again once either has changed, so a rotated secret takes
+// effect without a restart. Surrounding whitespace, such as a trailing
+// newline, is trimmed.
+type FileCredentials struct {
+	UsernameFile string
+	PasswordFile string
+
+	mu    sync.Mutex
+	creds Credentials
+	stats [2]os.FileInfo
+}
+
+// NewFileCredentials creates a provider reading the two files
+//
+//	creds := tokipay.NewFileCredentials("/var/run/secrets/tokipay/username", "/var/run/secrets/tokipay/password")
+func NewFileCredentials(usernameFile, passwordFile string) *FileCredentials {
+	return &FileCredentials{UsernameFile: usernameFile, PasswordFile: passwordFile}
+}
+
+// Credentials implements CredentialsProvider
+func (f *FileCredentials) Credentials(context.Context) (Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var stats [2]os.FileInfo
+	changed := false
+	for i, path := range []string{f.UsernameFile, f.PasswordFile} {
+		// Stat follows the symlinks Kubernetes swaps on an update
+		st, err := os.Stat(path)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to read credentials: %w", err)
+		}
+		prev := f.stats[i]
+		if prev == nil || !os.SameFile(prev, st) || !prev.ModTime().Equal(st.ModTime()) || prev.Size() != st.Size() {
+			changed = true
+		}
+		stats[i] = st
+	}
+	if !changed {
+		return f.creds, nil
+	}
+
+	username, err := os.ReadFile(f.UsernameFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	password, err := os.ReadFile(f.PasswordFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	c := Credentials{
+		Username: strings.TrimSpace(string(username)),
+		Password: Secret(strings.TrimSpace(string(password))),
+	}
+	if err := c.validate(); err != nil {
+		return Credentials{}, fmt.Errorf("%s, %s: %w", f.UsernameFile, f.PasswordFile, err)
+	}
+
+	f.creds, f.stats = c, stats
+	return c, nil
+}
+
+// Format implements fmt.Formatter. Every verb prints the file names only,
+// never the cached credentials.
+func (f *FileCredentials) Format(s fmt.State, verb rune) {
+	fmt.Fprintf(s, "FileCredentials(%s, %s)", f.UsernameFile, f.PasswordFile)
+}
@@ -0,0 +1,101 @@
+package tokipay_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+// writeSecret updates a secret volume the way Kubernetes does: the files
+// are written to a new directory and the ..data symlink is swapped to it
+func writeSecret(t *testing.T, dir, version, username, password string) {
+	t.Helper()
+	target := filepath.Join(dir, "..v"+version)
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"username": username, "password": password + "\n"} {
+		if err := os.WriteFile(filepath.Join(target, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(target), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileCredentialsRotation(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeSecret(t, dir, "1", srv.Username, srv.Password)
+	creds := tokipay.NewFileCredentials(filepath.Join(dir, "username"), filepath.Join(dir, "password"))
+	client := tokipay.NewWithCredentials(srv.URL, creds, srv.MerchantID).(*tokipay.TokiPayClient)
+
+	status := func() {
+		t.Helper()
+		if _, err := client.CheckPaymentStatus("missing"); err == nil {
+			t.Fatal("status of an unknown request succeeded")
+		} else if client.AccessToken == "" {
+			t.Fatalf("no token: %v", err)
+		}
+	}
+	status()
+	first := client.AccessToken
+
+	status()
+	if client.AccessToken != first {
+		t.Error("token fetched again without a rotation")
+	}
+
+	srv.Password = "rotated"
+	writeSecret(t, dir, "2", srv.Username, srv.Password)
+	status()
+	if client.AccessToken == first {
+		t.Error("token kept after the secret was rotated")
+	}
+
+	got, err := creds.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Password != "rotated" {
+		t.Errorf("password = %q, want trimmed %q", got.Password, "rotated")
+	}
+
+	os.Remove(filepath.Join(dir, "password"))
+	if err := client.GetAccessToken(); err == nil {
+		t.Error("token request with a missing password file succeeded")
+	}
+}
+
+func TestEnvAndStaticCredentials(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("SHOP_TOKIPAY_USER", "shop")
+	t.Setenv("SHOP_TOKIPAY_PASS", "secret")
+	t.Setenv(tokipay.EnvUsername, "")
+
+	env := tokipay.EnvCredentials{UsernameVar: "SHOP_TOKIPAY_USER", PasswordVar: "SHOP_TOKIPAY_PASS"}
+	if got, err := env.Credentials(ctx); err != nil || got != (tokipay.Credentials{Username: "shop", Password: "secret"}) {
+		t.Errorf("EnvCredentials = %+v, %v", got, err)
+	}
+	if _, err := (tokipay.EnvCredentials{}).Credentials(ctx); err == nil {
+		t.Error("EnvCredentials without TOKIPAY_USERNAME succeeded")
+	}
+
+	if _, err := (tokipay.StaticCredentials{Username: "shop"}).Credentials(ctx); err == nil {
+		t.Error("StaticCredentials without a password succeeded")
+	}
+}
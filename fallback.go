@@ -0,0 +1,215 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Error categories returned by CategorizeError
+const (
+	// ErrorCategoryCustomer means TokiPay rejected the customer for the
+	// method with one of the CustomerErrorCodes
+	ErrorCategoryCustomer = "CUSTOMER"
+
+	// ErrorCategoryRequest means TokiPay rejected the request itself, or it
+	// failed validation before it was sent
+	ErrorCategoryRequest = "REQUEST"
+
+	// ErrorCategoryAuth means the credentials, token or merchant were rejected
+	ErrorCategoryAuth = "AUTH"
+
+	// ErrorCategoryConflict means the order ID was already used
+	ErrorCategoryConflict = "CONFLICT"
+
+	// ErrorCategoryTransport means TokiPay could not be reached or its
+	// response could not be read. The request may have been created.
+	ErrorCategoryTransport = "TRANSPORT"
+
+	// ErrorCategoryUnknown is any other error, including a 5xx code. The
+	// outcome of the call is unknown and the request may have been created.
+	ErrorCategoryUnknown = "UNKNOWN"
+)
+
+// DefaultFallbackCategories are the error categories that move a
+// FallbackChain on to its next step. Only a customer rejection is certain to
+// leave no payment request behind that the customer could still pay. Since
+// CustomerErrorCodes is empty by default, so is the fallback.
+var DefaultFallbackCategories = []string{ErrorCategoryCustomer}
+
+// CustomerErrorCodes are the envelope codes TokiPay rejects a customer with,
+// categorized as ErrorCategoryCustomer. TokiPay documents no such code and
+// answers an unknown phone number with a 400 code, as it does an invalid
+// request, so the list is empty: add a code once TokiPay confirms it for
+// your merchant. Error messages are never used to tell customers apart.
+var CustomerErrorCodes []int
+
+// CategorizeError sorts an error from a TokiPay call into an error category.
+// The category comes from the error's type and the code of TokiPay's error
+// envelope; an error that is neither a rejection nor a transport failure is
+// ErrorCategoryUnknown.
+func CategorizeError(err error) string {
+	var rerr *ResponseError
+	var verr *ValidationError
+	var terr *TransportError
+	var nerr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &rerr):
+		return categorizeResponse(rerr)
+	case errors.As(err, &verr):
+		return ErrorCategoryRequest
+	case errors.As(err, &terr), errors.As(err, &nerr),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return ErrorCategoryTransport
+	}
+	return ErrorCategoryUnknown
+}
+
+// categorizeResponse sorts an error envelope by its code. Any other
+// rejection is a request error.
+func categorizeResponse(e *ResponseError) string {
+	switch {
+	case slices.Contains(CustomerErrorCodes, e.Code):
+		return ErrorCategoryCustomer
+	case e.Code == http.StatusUnauthorized, e.Code == http.StatusForbidden:
+		return ErrorCategoryAuth
+	case e.Code == http.StatusConflict:
+		return ErrorCategoryConflict
+	case e.Rejected():
+		return ErrorCategoryRequest
+	}
+	return ErrorCategoryUnknown
+}
+
+// Devices in a CheckoutContext
+const (
+	DeviceMobile  = "MOBILE"
+	DeviceDesktop = "DESKTOP"
+)
+
+// CheckoutContext describes where the customer is checking out
+type CheckoutContext struct {
+	// Device is DeviceMobile or DeviceDesktop. Empty matches every step.
+	Device string
+}
+
+// DeviceFromUserAgent guesses the device from a User-Agent header
+func DeviceFromUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, s := range []string{"mobile", "android", "iphone", "ipad", "ipod"} {
+		if strings.Contains(ua, s) {
+			return DeviceMobile
+		}
+	}
+	return DeviceDesktop
+}
+
+// FallbackStep is one payment method in a FallbackChain
+type FallbackStep struct {
+	Method string
+
+	// Devices limits the step to these devices. Empty allows every device.
+	Devices []string
+
+	// FallbackOn lists the error categories that move on to the next step.
+	// Defaults to DefaultFallbackCategories.
+	FallbackOn []string
+}
+
+// FallbackAttempt records a payment method tried by a FallbackChain
+type FallbackAttempt struct {
+	Method   string `json:"method"`
+	Category string `json:"category,omitempty"` // empty for the method that succeeded
+	Error    string `json:"error,omitempty"`
+}
+
+// FallbackError is returned when no step of a FallbackChain succeeded
+type FallbackError struct {
+	Attempts []FallbackAttempt
+
+	// Err is the error of the last attempt, or nil if no step applied
+	Err error
+}
+
+func (e *FallbackError) Error() string {
+	if len(e.Attempts) == 0 {
+		return "no payment method applies to this checkout"
+	}
+	methods := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		methods[i] = a.Method
+	}
+	return fmt.Sprintf("payment failed with %s: %v", strings.Join(methods, ", "), e.Err)
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// FallbackChain tries payment methods in order until one succeeds. A step
+// is skipped when it does not apply to the checkout context, or when it is
+// a mobile payment and the order has no phone number. The chain moves on
+// from a failed step only for the step's fallback categories; any other
+// error ends the checkout.
+type FallbackChain struct {
+	Checkout *Checkout
+	Steps    []FallbackStep
+}
+
+// NewFallbackChain creates a chain that starts sessions with checkout
+func NewFallbackChain(checkout *Checkout, steps ...FallbackStep) *FallbackChain {
+	return &FallbackChain{Checkout: checkout, Steps: steps}
+}
+
+// DefaultFallbackSteps tries a mobile push, then a deeplink on mobile
+// devices, then a QR code on desktops
+func DefaultFallbackSteps() []FallbackStep {
+	return []FallbackStep{
+		{Method: MethodMobile},
+		{Method: MethodDeeplink, Devices: []string{DeviceMobile}},
+		{Method: MethodQR, Devices: []string{DeviceDesktop}},
+	}
+}
+
+// Start creates a session with the first method that succeeds. The
+// session's Method is the method used and Attempts lists every method tried.
+func (f *FallbackChain) Start(order CheckoutOrder, cctx CheckoutContext) (*CheckoutSession, error) {
+	var attempts []FallbackAttempt
+	var lastErr error
+
+	for _, step := range f.Steps {
+		if cctx.Device != "" && len(step.Devices) > 0 && !slices.Contains(step.Devices, cctx.Device) {
+			continue
+		}
+		if step.Method == MethodMobile && order.PhoneNo == "" {
+			continue
+		}
+
+		s, err := f.Checkout.Start(order, step.Method)
+		if s != nil {
+			attempts = append(attempts, FallbackAttempt{Method: step.Method})
+			s.Attempts = attempts
+			return s, err
+		}
+
+		category := CategorizeError(err)
+		attempts = append(attempts, FallbackAttempt{Method: step.Method, Category: category, Error: err.Error()})
+		lastErr = err
+
+		fallbackOn := step.FallbackOn
+		if fallbackOn == nil {
+			fallbackOn = DefaultFallbackCategories
+		}
+		if !slices.Contains(fallbackOn, category) {
+			break
+		}
+	}
+
+	return nil, &FallbackError{Attempts: attempts, Err: lastErr}
+}
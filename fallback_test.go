@@ -0,0 +1,145 @@
+package tokipay_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+// customerCode stands in for a customer rejection code added to
+// CustomerErrorCodes
+const customerCode = 460
+
+func withCustomerCode(t *testing.T) {
+	codes := tokipay.CustomerErrorCodes
+	tokipay.CustomerErrorCodes = []int{customerCode}
+	t.Cleanup(func() { tokipay.CustomerErrorCodes = codes })
+}
+
+func TestCategorizeError(t *testing.T) {
+	withCustomerCode(t)
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"unknown phone", &tokipay.ResponseError{Code: 400, Message: "Phone number is not registered"}, tokipay.ErrorCategoryRequest},
+		{"not found", &tokipay.ResponseError{Code: 404, Message: "not found"}, tokipay.ErrorCategoryRequest},
+		{"unprocessable customer", &tokipay.ResponseError{Code: 422, Message: "customer is blocked"}, tokipay.ErrorCategoryRequest},
+		{"customer code", &tokipay.ResponseError{Code: customerCode, Message: "any message"}, tokipay.ErrorCategoryCustomer},
+		{"invalid request", &tokipay.ResponseError{Code: 400, Message: "amount must be greater than 0"}, tokipay.ErrorCategoryRequest},
+		{"unauthorized", &tokipay.ResponseError{Code: 401, Message: "invalid phone token"}, tokipay.ErrorCategoryAuth},
+		{"unknown merchant", &tokipay.ResponseError{Code: 403, Message: "unknown merchant"}, tokipay.ErrorCategoryAuth},
+		{"duplicate order", &tokipay.ResponseError{Code: 409, Message: "duplicate orderId"}, tokipay.ErrorCategoryConflict},
+		{"server error", &tokipay.ResponseError{Code: 500, Message: "customer service unavailable"}, tokipay.ErrorCategoryUnknown},
+		{"no code", &tokipay.ResponseError{Message: "customer not found"}, tokipay.ErrorCategoryUnknown},
+		{"wrapped", fmt.Errorf("checkout: %w", &tokipay.ResponseError{Code: 409}), tokipay.ErrorCategoryConflict},
+		{"validation", &tokipay.ValidationError{Field: "amount", Reason: "must be positive"}, tokipay.ErrorCategoryRequest},
+		{"transport", &tokipay.TransportError{Err: errors.New("failed to read response: unexpected EOF")}, tokipay.ErrorCategoryTransport},
+		{"deadline", fmt.Errorf("wait: %w", context.DeadlineExceeded), tokipay.ErrorCategoryTransport},
+		{"store", &tokipay.StoreError{Op: "payment", Err: errors.New("disk full")}, tokipay.ErrorCategoryUnknown},
+		{"plain message", errors.New("phone number not registered"), tokipay.ErrorCategoryUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokipay.CategorizeError(tt.err); got != tt.want {
+				t.Errorf("CategorizeError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	withCustomerCode(t)
+	order := tokipay.CheckoutOrder{OrderID: "ORDER_1", Amount: 1000, PhoneNo: "99119911"}
+	customerFault := tokipaytest.Fault{StatusCode: customerCode, Message: "phone number is not registered"}
+
+	tests := []struct {
+		name     string
+		order    tokipay.CheckoutOrder
+		device   string
+		faulted  []string // endpoints failed with fault
+		fault    tokipaytest.Fault
+		method   string   // method of the session, empty if Start fails
+		attempts []string // categories of the attempts, empty for the one that succeeded
+	}{
+		{name: "mobile succeeds", order: order, device: tokipay.DeviceDesktop, method: tokipay.MethodMobile, attempts: []string{""}},
+		{name: "unknown phone on desktop", order: order, device: tokipay.DeviceDesktop, faulted: []string{tokipaytest.EndpointMobile}, fault: customerFault,
+			method: tokipay.MethodQR, attempts: []string{tokipay.ErrorCategoryCustomer, ""}},
+		{name: "unknown phone on mobile", order: order, device: tokipay.DeviceMobile, faulted: []string{tokipaytest.EndpointMobile}, fault: customerFault,
+			method: tokipay.MethodDeeplink, attempts: []string{tokipay.ErrorCategoryCustomer, ""}},
+		{name: "unknown phone with a request code", order: order, device: tokipay.DeviceDesktop, faulted: []string{tokipaytest.EndpointMobile},
+			fault:    tokipaytest.Fault{StatusCode: http.StatusBadRequest, Message: "phone number is not registered"},
+			attempts: []string{tokipay.ErrorCategoryRequest}},
+		{name: "no phone number", order: tokipay.CheckoutOrder{OrderID: "ORDER_1", Amount: 1000}, device: tokipay.DeviceDesktop,
+			method: tokipay.MethodQR, attempts: []string{""}},
+		{name: "server error", order: order, device: tokipay.DeviceDesktop, faulted: []string{tokipaytest.EndpointMobile}, fault: tokipaytest.InternalError(),
+			attempts: []string{tokipay.ErrorCategoryUnknown}},
+		{name: "malformed response", order: order, device: tokipay.DeviceDesktop, faulted: []string{tokipaytest.EndpointMobile}, fault: tokipaytest.Fault{Malformed: true},
+			attempts: []string{tokipay.ErrorCategoryTransport}},
+		{name: "invalid request", order: order, device: tokipay.DeviceDesktop, faulted: []string{tokipaytest.EndpointMobile},
+			fault:    tokipaytest.Fault{StatusCode: http.StatusBadRequest, Message: "invalid amount"},
+			attempts: []string{tokipay.ErrorCategoryRequest}},
+		{name: "every method fails", order: order, device: tokipay.DeviceDesktop, faulted: []string{tokipaytest.EndpointMobile, tokipaytest.EndpointQR}, fault: customerFault,
+			attempts: []string{tokipay.ErrorCategoryCustomer, tokipay.ErrorCategoryCustomer}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tokipaytest.NewServer()
+			defer srv.Close()
+			for _, endpoint := range tt.faulted {
+				srv.FailNext(endpoint, 1, tt.fault)
+			}
+
+			chain := tokipay.NewFallbackChain(newCheckout(srv), tokipay.DefaultFallbackSteps()...)
+			session, err := chain.Start(tt.order, tokipay.CheckoutContext{Device: tt.device})
+
+			var attempts []tokipay.FallbackAttempt
+			if tt.method != "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if session.Method != tt.method {
+					t.Errorf("session method = %s, want %s", session.Method, tt.method)
+				}
+				attempts = session.Attempts
+			} else {
+				var ferr *tokipay.FallbackError
+				if !errors.As(err, &ferr) {
+					t.Fatalf("Start error = %v, want a *FallbackError", err)
+				}
+				attempts = ferr.Attempts
+			}
+
+			if len(attempts) != len(tt.attempts) {
+				t.Fatalf("attempts = %+v, want categories %q", attempts, tt.attempts)
+			}
+			for i, a := range attempts {
+				if a.Category != tt.attempts[i] {
+					t.Errorf("attempt %d (%s) category = %q, want %q", i, a.Method, a.Category, tt.attempts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackChainNoStep(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	chain := tokipay.NewFallbackChain(newCheckout(srv), tokipay.FallbackStep{Method: tokipay.MethodDeeplink, Devices: []string{tokipay.DeviceMobile}})
+	_, err := chain.Start(tokipay.CheckoutOrder{OrderID: "ORDER_1", Amount: 1000}, tokipay.CheckoutContext{Device: tokipay.DeviceDesktop})
+	var ferr *tokipay.FallbackError
+	if !errors.As(err, &ferr) || len(ferr.Attempts) != 0 || ferr.Err != nil {
+		t.Errorf("Start with no applicable step error = %v", err)
+	}
+	if len(srv.Payments()) != 0 {
+		t.Error("a payment was created")
+	}
+}
@@ -0,0 +1,221 @@
+// Package tokitime holds the time types of TokiPay's API. The tokipay
+// package and its internal client alias them, so this package imports
+// neither.
+package tokitime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Ulaanbaatar is the time zone of TokiPay's dates. It falls back to a fixed
+// UTC+8 zone, which Mongolia has kept without daylight saving time since
+// 2017, when the system has no time zone database.
+var Ulaanbaatar = loadUlaanbaatar()
+
+func loadUlaanbaatar() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Ulaanbaatar"); err == nil {
+		return loc
+	}
+	return time.FixedZone("ULAT", 8*60*60)
+}
+
+// Layouts of Date and DateTime, in Ulaanbaatar time
+const (
+	DateLayout     = "01/02/2006"
+	DateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// Timestamp is an instant sent as Unix milliseconds, such as the timestamp
+// of every TokiPay response. Values below 1e11 are read as Unix seconds.
+// The time is a named field rather than embedded, so none of time.Time's
+// encodings are promoted past the ones below.
+type Timestamp struct {
+	Time time.Time
+}
+
+// NewTimestamp returns t as a Timestamp in Ulaanbaatar time
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{t.In(Ulaanbaatar)}
+}
+
+// IsZero reports whether the timestamp is unset
+func (t Timestamp) IsZero() bool {
+	return t.Time.IsZero()
+}
+
+// String returns the timestamp in RFC 3339 in Ulaanbaatar time, or "" when zero
+func (t Timestamp) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Time.In(Ulaanbaatar).Format(time.RFC3339Nano)
+}
+
+// MarshalJSON writes the timestamp as Unix milliseconds, or 0 when zero
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("0"), nil
+	}
+	return strconv.AppendInt(nil, t.Time.UnixMilli(), 10), nil
+}
+
+// UnmarshalJSON reads Unix milliseconds or seconds, as a number or a
+// string. 0, "" and null leave the timestamp zero.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if s := string(data); s == "" || s == "0" || s == "null" {
+		*t = Timestamp{}
+		return nil
+	}
+
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		var f float64
+		if f, err = strconv.ParseFloat(string(data), 64); err != nil {
+			return fmt.Errorf("invalid timestamp %s", data)
+		}
+		n = int64(f)
+	}
+	if n > -1e11 && n < 1e11 {
+		n *= 1000
+	}
+	*t = Timestamp{time.UnixMilli(n).In(Ulaanbaatar)}
+	return nil
+}
+
+// Date is a calendar day in Ulaanbaatar sent as MM/DD/YYYY, such as the
+// createdDate of a VAT registration
+type Date struct {
+	Time time.Time
+}
+
+// NewDate returns the day t falls on in Ulaanbaatar
+func NewDate(t time.Time) Date {
+	if t.IsZero() {
+		return Date{}
+	}
+	y, m, d := t.In(Ulaanbaatar).Date()
+	return Date{time.Date(y, m, d, 0, 0, 0, 0, Ulaanbaatar)}
+}
+
+// ParseDate parses a MM/DD/YYYY date. An empty string is the zero Date.
+func ParseDate(s string) (Date, error) {
+	if s == "" {
+		return Date{}, nil
+	}
+	t, err := time.ParseInLocation(DateLayout, s, Ulaanbaatar)
+	if err != nil {
+		return Date{}, fmt.Errorf("invalid date %q, must be a date as MM/DD/YYYY", s)
+	}
+	return Date{t}, nil
+}
+
+// IsZero reports whether the date is unset
+func (d Date) IsZero() bool {
+	return d.Time.IsZero()
+}
+
+// String returns the date as MM/DD/YYYY, or "" when zero
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Time.In(Ulaanbaatar).Format(DateLayout)
+}
+
+// MarshalText writes the date as MM/DD/YYYY
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText reads a MM/DD/YYYY date
+func (d *Date) UnmarshalText(text []byte) error {
+	date, err := ParseDate(string(text))
+	if err != nil {
+		return err
+	}
+	*d = date
+	return nil
+}
+
+// MarshalJSON writes the date as a MM/DD/YYYY string
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a MM/DD/YYYY string. null leaves the date unchanged.
+func (d *Date) UnmarshalJSON(data []byte) error {
+	return unmarshalText(data, d.UnmarshalText)
+}
+
+// DateTime is an instant sent as local Ulaanbaatar time, such as the paid
+// and refund dates of a transaction. RFC 3339 values are accepted too.
+type DateTime struct {
+	Time time.Time
+}
+
+// NewDateTime returns t as a DateTime in Ulaanbaatar time
+func NewDateTime(t time.Time) DateTime {
+	return DateTime{t.In(Ulaanbaatar)}
+}
+
+// IsZero reports whether the time is unset
+func (t DateTime) IsZero() bool {
+	return t.Time.IsZero()
+}
+
+// String returns the time in DateTimeLayout, or "" when zero
+func (t DateTime) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Time.In(Ulaanbaatar).Format(DateTimeLayout)
+}
+
+// MarshalText writes the time in DateTimeLayout
+func (t DateTime) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText reads a time in DateTimeLayout or RFC 3339
+func (t *DateTime) UnmarshalText(text []byte) error {
+	s := string(text)
+	if s == "" {
+		*t = DateTime{}
+		return nil
+	}
+	parsed, err := time.ParseInLocation(DateTimeLayout, s, Ulaanbaatar)
+	if err != nil {
+		if parsed, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return fmt.Errorf("invalid date and time %q", s)
+		}
+	}
+	*t = DateTime{parsed.In(Ulaanbaatar)}
+	return nil
+}
+
+// MarshalJSON writes the time as a string in DateTimeLayout
+func (t DateTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON reads a string in DateTimeLayout or RFC 3339. null leaves
+// the time unchanged.
+func (t *DateTime) UnmarshalJSON(data []byte) error {
+	return unmarshalText(data, t.UnmarshalText)
+}
+
+func unmarshalText(data []byte, fn func([]byte) error) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return fn([]byte(s))
+}
@@ -0,0 +1,137 @@
+package tokipay
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxAmount is the largest amount whose cents a float64 still holds
+// exactly. Larger line totals are rejected as overflowing.
+const maxAmount = 9e13
+
+// LineItem is one product or service in an order. UnitPrice is the retail
+// price in MNT, including VAT and city tax when they apply.
+type LineItem struct {
+	Name               string  `json:"name"`
+	Quantity           float64 `json:"quantity"`
+	UnitPrice          float64 `json:"unitPrice"`
+	VAT                bool    `json:"vat"`                          // subject to VAT
+	CityTax            bool    `json:"cityTax"`                      // subject to city tax
+	Barcode            string  `json:"barcode,omitempty"`            // product barcode
+	ClassificationCode string  `json:"classificationCode,omitempty"` // e-barimt product classification code
+}
+
+// Total returns the line's quantity times its unit price
+func (i LineItem) Total() float64 {
+	return roundAmount(i.Quantity * i.UnitPrice)
+}
+
+// ProductInfo renders the line for ProductsInfo, as "Coffee 1500 MNT" or
+// "Coffee x2 3000 MNT"
+func (i LineItem) ProductInfo() string {
+	if i.Quantity == 1 {
+		return fmt.Sprintf("%s %s MNT", i.Name, formatAmount(i.Total()))
+	}
+	return fmt.Sprintf("%s x%s %s MNT", i.Name, formatAmount(i.Quantity), formatAmount(i.Total()))
+}
+
+// LineItems are the lines of an order
+type LineItems []LineItem
+
+// Total returns the sum of the line totals
+func (items LineItems) Total() float64 {
+	var total float64
+	for _, i := range items {
+		total += i.Total()
+	}
+	return roundAmount(total)
+}
+
+// VATTotal returns the sum of the line totals subject to VAT
+func (items LineItems) VATTotal() float64 {
+	var total float64
+	for _, i := range items {
+		if i.VAT {
+			total += i.Total()
+		}
+	}
+	return roundAmount(total)
+}
+
+// CityTaxTotal returns the sum of the line totals subject to city tax
+func (items LineItems) CityTaxTotal() float64 {
+	var total float64
+	for _, i := range items {
+		if i.CityTax {
+			total += i.Total()
+		}
+	}
+	return roundAmount(total)
+}
+
+// ProductsInfo renders the lines for MobilePaymentRequest.ProductsInfo
+func (items LineItems) ProductsInfo() []string {
+	info := make([]string, len(items))
+	for n, i := range items {
+		info[n] = i.ProductInfo()
+	}
+	return info
+}
+
+// EbarimtText renders the lines for MobilePaymentRequest.EbarimtText, one
+// line per item followed by the total
+func (items LineItems) EbarimtText() string {
+	var b strings.Builder
+	for _, i := range items {
+		fmt.Fprintf(&b, "%s %s x %s = %s MNT", i.Name, formatAmount(i.Quantity), formatAmount(i.UnitPrice), formatAmount(i.Total()))
+		if i.Barcode != "" {
+			fmt.Fprintf(&b, " [%s]", i.Barcode)
+		}
+		if i.ClassificationCode != "" {
+			fmt.Fprintf(&b, " (%s)", i.ClassificationCode)
+		}
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "Total: %s MNT", formatAmount(items.Total()))
+	return b.String()
+}
+
+// Validate checks every line and that the lines sum to amount
+func (items LineItems) Validate(amount float64) error {
+	if len(items) == 0 {
+		return errors.New("no line items")
+	}
+	for n, i := range items {
+		switch {
+		case i.Name == "":
+			return fmt.Errorf("line item %d has no name", n+1)
+		case math.IsNaN(i.Quantity) || i.Quantity <= 0:
+			return fmt.Errorf("line item %d (%s) quantity must be greater than 0", n+1, i.Name)
+		case math.IsNaN(i.UnitPrice) || i.UnitPrice < 0:
+			return fmt.Errorf("line item %d (%s) unit price must not be negative", n+1, i.Name)
+		case i.Quantity*i.UnitPrice > maxAmount:
+			return fmt.Errorf("line item %d (%s) total overflows", n+1, i.Name)
+		}
+	}
+	total := items.Total()
+	if total > maxAmount {
+		return errors.New("line items total overflows")
+	}
+	if math.Abs(total-amount) >= 0.005 {
+		return fmt.Errorf("line items total %s MNT, amount is %s MNT", formatAmount(total), formatAmount(amount))
+	}
+	return nil
+}
+
+// Apply validates the lines against the request amount and sets the
+// request's ProductsInfo and EbarimtText
+func (items LineItems) Apply(req *MobilePaymentRequest) error {
+	if err := items.Validate(req.Amount); err != nil {
+		return err
+	}
+	req.ProductsInfo = items.ProductsInfo()
+	req.EbarimtText = items.EbarimtText()
+	return nil
+}
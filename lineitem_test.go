@@ -0,0 +1,107 @@
+package tokipay
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLineItemsTotals(t *testing.T) {
+	tests := []struct {
+		name                     string
+		items                    LineItems
+		total, vatTotal, cityTax float64
+	}{
+		{"float sum", LineItems{{Name: "A", Quantity: 1, UnitPrice: 0.1, VAT: true}, {Name: "B", Quantity: 1, UnitPrice: 0.2}}, 0.3, 0.1, 0},
+		{"each line rounded", LineItems{
+			{Name: "A", Quantity: 1, UnitPrice: 0.005, VAT: true},
+			{Name: "B", Quantity: 1, UnitPrice: 0.005, VAT: true, CityTax: true},
+			{Name: "C", Quantity: 1, UnitPrice: 0.005},
+		}, 0.03, 0.02, 0.01},
+		{"fractional quantities", LineItems{
+			{Name: "Fuel", Quantity: 12.345, UnitPrice: 2890, VAT: true, CityTax: true},
+			{Name: "Water", Quantity: 3, UnitPrice: 33.333, VAT: true},
+		}, 35777.05, 35777.05, 35677.05},
+		{"free item", LineItems{{Name: "Gift", Quantity: 1, UnitPrice: 0}}, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.items.Total(); got != tt.total {
+				t.Errorf("Total = %v, want %v", got, tt.total)
+			}
+			if got := tt.items.VATTotal(); got != tt.vatTotal {
+				t.Errorf("VATTotal = %v, want %v", got, tt.vatTotal)
+			}
+			if got := tt.items.CityTaxTotal(); got != tt.cityTax {
+				t.Errorf("CityTaxTotal = %v, want %v", got, tt.cityTax)
+			}
+			if err := tt.items.Validate(tt.total); err != nil {
+				t.Errorf("Validate(Total) = %v", err)
+			}
+		})
+	}
+}
+
+func TestLineItemsValidate(t *testing.T) {
+	coffee := LineItem{Name: "Coffee", Quantity: 2, UnitPrice: 1500}
+	tests := []struct {
+		name   string
+		items  LineItems
+		amount float64
+		err    string // substring of the error, empty for none
+	}{
+		{"valid", LineItems{coffee}, 3000, ""},
+		{"within half a mongo", LineItems{coffee}, 3000.004, ""},
+		{"amount differs", LineItems{coffee}, 3000.01, "line items total 3000 MNT, amount is 3000.01 MNT"},
+		{"no lines", nil, 0, "no line items"},
+		{"empty lines", LineItems{}, 0, "no line items"},
+		{"no name", LineItems{{Quantity: 1, UnitPrice: 100}}, 100, "line item 1 has no name"},
+		{"zero quantity", LineItems{coffee, {Name: "Cake", UnitPrice: 100}}, 3000, "line item 2 (Cake) quantity"},
+		{"negative quantity", LineItems{{Name: "Cake", Quantity: -1, UnitPrice: 100}}, -100, "quantity must be greater than 0"},
+		{"NaN quantity", LineItems{{Name: "Cake", Quantity: math.NaN(), UnitPrice: 100}}, 100, "quantity must be greater than 0"},
+		{"negative price", LineItems{{Name: "Discount", Quantity: 1, UnitPrice: -500}}, -500, "unit price must not be negative"},
+		{"NaN price", LineItems{{Name: "Cake", Quantity: 1, UnitPrice: math.NaN()}}, 0, "unit price must not be negative"},
+		{"line overflows", LineItems{{Name: "Gold", Quantity: 1e200, UnitPrice: 1e200}}, math.Inf(1), "line item 1 (Gold) total overflows"},
+		{"infinite price", LineItems{{Name: "Gold", Quantity: 1, UnitPrice: math.Inf(1)}}, math.Inf(1), "total overflows"},
+		{"line beyond cents", LineItems{{Name: "Gold", Quantity: 1e7, UnitPrice: 1e7}}, 1e14, "total overflows"},
+		{"sum overflows", LineItems{{Name: "A", Quantity: 1, UnitPrice: 6e13}, {Name: "B", Quantity: 1, UnitPrice: 6e13}}, 1.2e14, "line items total overflows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.items.Validate(tt.amount)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLineItemsRender(t *testing.T) {
+	items := LineItems{
+		{Name: "Coffee", Quantity: 2, UnitPrice: 1500, Barcode: "8650001234567", ClassificationCode: "2399"},
+		{Name: "Cake", Quantity: 1, UnitPrice: 2500.5},
+	}
+	wantInfo := []string{"Coffee x2 3000 MNT", "Cake 2500.5 MNT"}
+	if got := items.ProductsInfo(); strings.Join(got, "|") != strings.Join(wantInfo, "|") {
+		t.Errorf("ProductsInfo = %q, want %q", got, wantInfo)
+	}
+	wantText := "Coffee 2 x 1500 = 3000 MNT [8650001234567] (2399)\nCake 1 x 2500.5 = 2500.5 MNT\nTotal: 5500.5 MNT"
+	if got := items.EbarimtText(); got != wantText {
+		t.Errorf("EbarimtText = %q, want %q", got, wantText)
+	}
+
+	req := MobilePaymentRequest{Amount: 5500.5}
+	if err := items.Apply(&req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ProductsInfo) != 2 || req.EbarimtText != wantText {
+		t.Errorf("applied request = %+v", req)
+	}
+	req = MobilePaymentRequest{Amount: 5000}
+	if err := items.Apply(&req); err == nil || req.ProductsInfo != nil {
+		t.Errorf("Apply to a different amount = %v, request %+v", err, req)
+	}
+}
@@ -0,0 +1,272 @@
+package tokipay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrUnknownMerchant is returned for a merchant key that is not in a MerchantRegistry
+var ErrUnknownMerchant = errors.New("unknown merchant")
+
+// MerchantConfig holds the TokiPay credentials of one merchant
+type MerchantConfig struct {
+	BaseURL    string
+	Username   string
+	Password   Secret
+	MerchantID string
+
+	// APIKey defaults to ThirdPartyAPIKey
+	APIKey string
+
+	// Credentials, if set, replaces Username and Password
+	Credentials CredentialsProvider
+}
+
+func (c MerchantConfig) validate() error {
+	switch {
+	case c.BaseURL == "":
+		return errors.New("merchant config has no base URL")
+	case c.Credentials == nil && (c.Username == "" || c.Password == ""):
+		return errors.New("merchant config has no username or password")
+	case c.MerchantID == "":
+		return errors.New("merchant config has no merchant ID")
+	}
+	return nil
+}
+
+// MerchantRegistry holds the clients of several merchants by key, such as
+// one per brand. Every merchant has its own client and access token, and all
+// clients share one HTTP client and so its connection pool. Merchants can be
+// added, removed and rotated while the registry is in use.
+type MerchantRegistry struct {
+	httpClient *http.Client
+
+	mu      sync.RWMutex
+	clients map[string]*TokiPayClient
+}
+
+// NewMerchantRegistry creates an empty registry whose clients share
+// httpClient. A nil httpClient is replaced by one with a 30 second timeout.
+func NewMerchantRegistry(httpClient *http.Client) *MerchantRegistry {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &MerchantRegistry{httpClient: httpClient, clients: make(map[string]*TokiPayClient)}
+}
+
+// Add registers a merchant under key. Adding a key twice, or a merchant ID
+// already registered under another key, is an error; use Rotate to change a
+// merchant's credentials.
+func (r *MerchantRegistry) Add(key string, cfg MerchantConfig) error {
+	if key == "" {
+		return errors.New("merchant key is empty")
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("merchant %s: %w", key, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[key]; ok {
+		return fmt.Errorf("merchant %s is already registered", key)
+	}
+	if err := r.checkMerchantID(key, cfg.MerchantID); err != nil {
+		return err
+	}
+	r.clients[key] = r.newClient(cfg)
+	return nil
+}
+
+// Rotate replaces the credentials of a registered merchant. The merchant
+// gets a new client, so the access token of the old credentials is never
+// reused; calls already running finish with the old client. The merchant ID
+// must not be registered under another key.
+func (r *MerchantRegistry) Rotate(key string, cfg MerchantConfig) error {
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("merchant %s: %w", key, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.clients[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownMerchant, key)
+	}
+	if err := r.checkMerchantID(key, cfg.MerchantID); err != nil {
+		return err
+	}
+	r.clients[key] = r.newClient(cfg)
+	return nil
+}
+
+// checkMerchantID returns an error if merchantID is registered under a key
+// other than key. The caller holds r.mu.
+func (r *MerchantRegistry) checkMerchantID(key, merchantID string) error {
+	for other, c := range r.clients {
+		if other != key && c.MerchantID == merchantID {
+			return fmt.Errorf("merchant ID %s is already registered as merchant %s", merchantID, other)
+		}
+	}
+	return nil
+}
+
+// Remove unregisters a merchant and reports whether it was registered
+func (r *MerchantRegistry) Remove(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.clients[key]
+	delete(r.clients, key)
+	return ok
+}
+
+// Keys returns the registered merchant keys in order
+func (r *MerchantRegistry) Keys() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]string, 0, len(r.clients))
+	for key := range r.clients {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Client returns the current client of a merchant. Hold on to it only for
+// a single call or two; Merchant follows rotations and removals.
+func (r *MerchantRegistry) Client(key string) (*TokiPayClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.clients[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMerchant, key)
+	}
+	return c, nil
+}
+
+// KeyForMerchantID returns the key of the merchant with the TokiPay
+// merchant ID, such as the MerchantID of a stored Payment. A merchant ID is
+// registered under at most one key.
+func (r *MerchantRegistry) KeyForMerchantID(merchantID string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for key, c := range r.clients {
+		if c.MerchantID == merchantID {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Merchant returns a TokiPay that routes every call to the merchant's
+// current client. Once the merchant is removed, calls fail with
+// ErrUnknownMerchant.
+func (r *MerchantRegistry) Merchant(key string) TokiPay {
+	return &merchantClient{registry: r, key: key}
+}
+
+func (r *MerchantRegistry) newClient(cfg MerchantConfig) *TokiPayClient {
+	apiKey := cfg.APIKey
+	if apiKey == "" {
+		apiKey = ThirdPartyAPIKey
+	}
+	return &TokiPayClient{
+		BaseURL:     cfg.BaseURL,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		MerchantID:  cfg.MerchantID,
+		APIKey:      apiKey,
+		HTTPClient:  r.httpClient,
+		Credentials: cfg.Credentials,
+	}
+}
+
+// merchantClient looks up its merchant's client on every call
+type merchantClient struct {
+	registry *MerchantRegistry
+	key      string
+}
+
+var _ TokiPay = (*merchantClient)(nil)
+
+// merchantID returns the merchant ID of the current client, or "" once the
+// merchant is removed
+func (m *merchantClient) merchantID() string {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return ""
+	}
+	return c.MerchantID
+}
+
+func (m *merchantClient) GetAccessToken() error {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return err
+	}
+	return c.GetAccessToken()
+}
+
+func (m *merchantClient) CreateQRPayment(req QRPaymentRequest) (*QRPaymentResponse, error) {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateQRPayment(req)
+}
+
+func (m *merchantClient) CreateMobilePayment(req MobilePaymentRequest) (*MobilePaymentResponse, error) {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateMobilePayment(req)
+}
+
+func (m *merchantClient) CreateDeeplinkPayment(req DeeplinkPaymentRequest) (*DeeplinkPaymentResponse, error) {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateDeeplinkPayment(req)
+}
+
+func (m *merchantClient) CheckPaymentStatus(requestID string) (*PaymentStatusResponse, error) {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckPaymentStatus(requestID)
+}
+
+func (m *merchantClient) CancelPayment(requestID string) error {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return err
+	}
+	return c.CancelPayment(requestID)
+}
+
+func (m *merchantClient) RefundPayment(req RefundRequest) (*RefundResponse, error) {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return c.RefundPayment(req)
+}
+
+func (m *merchantClient) RegisterVAT(req VATRegistrationRequest) (*VATRegistrationResponse, error) {
+	c, err := m.registry.Client(m.key)
+	if err != nil {
+		return nil, err
+	}
+	return c.RegisterVAT(req)
+}
@@ -0,0 +1,105 @@
+package tokipay_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestMerchantRegistry(t *testing.T) {
+	brandA := tokipaytest.NewServer()
+	defer brandA.Close()
+	brandB := tokipaytest.NewServer()
+	defer brandB.Close()
+	brandB.MerchantID = "merchant-b"
+
+	config := func(srv *tokipaytest.Server) tokipay.MerchantConfig {
+		return tokipay.MerchantConfig{BaseURL: srv.URL, Username: srv.Username, Password: tokipay.Secret(srv.Password), MerchantID: srv.MerchantID}
+	}
+	httpClient := &http.Client{}
+	registry := tokipay.NewMerchantRegistry(httpClient)
+	if err := registry.Add("a", config(brandA)); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Add("b", config(brandB)); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Add("a", config(brandA)); err == nil {
+		t.Error("adding a key twice succeeded")
+	}
+	if err := registry.Add("c", tokipay.MerchantConfig{BaseURL: brandA.URL}); err == nil {
+		t.Error("adding a merchant without credentials succeeded")
+	}
+	if err := registry.Add("c", config(brandB)); err == nil {
+		t.Error("adding a merchant ID under a second key succeeded")
+	}
+	if keys := registry.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("Keys() = %v", keys)
+	}
+	if key, ok := registry.KeyForMerchantID("merchant-b"); !ok || key != "b" {
+		t.Errorf("KeyForMerchantID = %q, %v", key, ok)
+	}
+
+	qr := func(merchant tokipay.TokiPay, orderID string) (*tokipay.QRPaymentResponse, error) {
+		return merchant.CreateQRPayment(tokipay.QRPaymentRequest{
+			SuccessURL: "https://example.com/success",
+			FailureURL: "https://example.com/failure",
+			OrderID:    orderID,
+			Amount:     1000,
+		})
+	}
+	a, b := registry.Merchant("a"), registry.Merchant("b")
+	if _, err := qr(a, "ORDER_A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qr(b, "ORDER_B"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := brandA.PaymentByOrder("ORDER_A"); !ok {
+		t.Error("brand A payment not created on brand A")
+	}
+	if _, ok := brandA.PaymentByOrder("ORDER_B"); ok {
+		t.Error("brand B payment created on brand A")
+	}
+	if p, ok := brandB.PaymentByOrder("ORDER_B"); !ok || p.MerchantID != "merchant-b" {
+		t.Errorf("brand B payment = %+v, %v", p, ok)
+	}
+
+	clientA, _ := registry.Client("a")
+	clientB, _ := registry.Client("b")
+	if clientA == clientB || clientA.AccessToken == "" || clientB.AccessToken == "" {
+		t.Error("merchants do not have their own client and token")
+	}
+	if clientA.HTTPClient != httpClient || clientB.HTTPClient != httpClient {
+		t.Error("merchants do not share the HTTP client")
+	}
+
+	// rotated credentials take effect through the routed client
+	brandA.Password = "rotated"
+	rotated := config(brandA)
+	if err := registry.Rotate("a", rotated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qr(a, "ORDER_A2"); err != nil {
+		t.Fatalf("payment after rotation: %v", err)
+	}
+	if c, _ := registry.Client("a"); c == clientA || c.AccessToken == clientA.AccessToken {
+		t.Error("rotation kept the old client or token")
+	}
+	if err := registry.Rotate("missing", rotated); !errors.Is(err, tokipay.ErrUnknownMerchant) {
+		t.Errorf("rotating an unknown merchant error = %v", err)
+	}
+	if err := registry.Rotate("a", config(brandB)); err == nil {
+		t.Error("rotating to the merchant ID of another key succeeded")
+	}
+
+	if !registry.Remove("b") || registry.Remove("b") {
+		t.Error("Remove did not report the registered merchant once")
+	}
+	if _, err := qr(b, "ORDER_B2"); !errors.Is(err, tokipay.ErrUnknownMerchant) {
+		t.Errorf("payment with a removed merchant error = %v, want ErrUnknownMerchant", err)
+	}
+}
@@ -0,0 +1,224 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Discrepancy kinds reported by Reconcile
+const (
+	DiscrepancyStatusChanged    = "STATUS_CHANGED"
+	DiscrepancyMissedCallback   = "MISSED_CALLBACK"
+	DiscrepancyAmountMismatch   = "AMOUNT_MISMATCH"
+	DiscrepancyUnexpectedRefund = "UNEXPECTED_REFUND"
+	DiscrepancyCheckFailed      = "CHECK_FAILED"
+	DiscrepancyMissingRemote    = "MISSING_REMOTE"
+	DiscrepancyMissingLocal     = "MISSING_LOCAL"
+)
+
+// ReconcileOptions configure a reconciliation run
+type ReconcileOptions struct {
+	// DryRun reports discrepancies without updating the store
+	DryRun bool
+
+	// Statuses selects the local statuses to reconcile. Defaults to PENDING.
+	Statuses []string
+
+	// MinAge skips payments created more recently than this
+	MinAge time.Duration
+
+	// Limit caps the number of payments checked. Zero checks all.
+	Limit int
+
+	// RequestIDs are checked in addition to the stored payments selected
+	// above, such as request IDs taken from TokiPay's merchant portal. One
+	// that is not stored but known to TokiPay is reported as
+	// DiscrepancyMissingLocal.
+	RequestIDs []string
+}
+
+// Discrepancy is a difference between a local payment and TokiPay
+type Discrepancy struct {
+	RequestID string `json:"requestId"`
+	OrderID   string `json:"orderId"`
+	Kind      string `json:"kind"`
+	Local     string `json:"local,omitempty"`
+	Remote    string `json:"remote,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// ReconciliationReport summarizes a reconciliation run
+type ReconciliationReport struct {
+	DryRun        bool          `json:"dryRun"`
+	Checked       int           `json:"checked"`
+	Updated       int           `json:"updated"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	StartedAt     time.Time     `json:"startedAt"`
+	FinishedAt    time.Time     `json:"finishedAt"`
+}
+
+// Reconcile checks stored payments against TokiPay, applies status
+// transitions to the store and reports every discrepancy found. A stored
+// payment TokiPay does not know is reported as DiscrepancyMissingRemote.
+// Any other failed status check is reported and does not stop the run;
+// store errors do.
+// Deeplink payments have no request ID to check their status with until
+// their callback is recorded, and are skipped.
+func Reconcile(ctx context.Context, client TokiPay, store PaymentStore, opts ReconcileOptions) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{DryRun: opts.DryRun, StartedAt: time.Now()}
+	defer func() { report.FinishedAt = time.Now() }()
+
+	filter := PaymentFilter{Statuses: opts.Statuses, Limit: opts.Limit}
+	if len(filter.Statuses) == 0 {
+		filter.Statuses = []string{StatusPending}
+	}
+	if opts.MinAge > 0 {
+		filter.CreatedBefore = report.StartedAt.Add(-opts.MinAge)
+	}
+
+	payments, err := store.ListPayments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", err)
+	}
+	listed := make(map[string]bool, len(payments))
+	for _, p := range payments {
+		listed[p.RequestID] = true
+	}
+	for _, requestID := range opts.RequestIDs {
+		if listed[requestID] {
+			continue
+		}
+		listed[requestID] = true
+		p, err := store.GetPayment(ctx, requestID)
+		switch {
+		case errors.Is(err, ErrPaymentNotFound):
+			report.missingLocal(client, requestID)
+		case err != nil:
+			return report, fmt.Errorf("failed to get payment %s: %w", requestID, err)
+		default:
+			payments = append(payments, *p)
+		}
+	}
+
+	for i := range payments {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		p := &payments[i]
+		if p.RequestID == "" {
+			continue
+		}
+		report.Checked++
+
+		resp, err := client.CheckPaymentStatus(p.RequestID)
+		var rerr *ResponseError
+		switch {
+		case errors.As(err, &rerr) && rerr.Code == http.StatusNotFound:
+			report.add(p, DiscrepancyMissingRemote, p.Status, "", err.Error())
+			continue
+		case err != nil:
+			report.add(p, DiscrepancyCheckFailed, p.Status, "", err.Error())
+			continue
+		}
+
+		events, err := store.ListEvents(ctx, p.Key)
+		if err != nil {
+			return report, fmt.Errorf("failed to list events for %s: %w", p.RequestID, err)
+		}
+		report.compare(p, resp, events)
+
+		if resp.Status == p.Status && resp.TransNumber == p.TransNumber {
+			continue
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		from := p.Status
+		ApplyStatus(p, resp)
+		p.UpdatedAt = time.Now()
+		if err := store.SavePayment(ctx, p); err != nil {
+			return report, fmt.Errorf("failed to save payment %s: %w", p.RequestID, err)
+		}
+		err = store.AddEvent(ctx, &PaymentEvent{
+			PaymentKey: p.Key,
+			Type:       EventStatusChecked,
+			Status:     resp.Status,
+			Detail:     "reconciled from " + from,
+			CreatedAt:  p.UpdatedAt,
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to add event for %s: %w", p.RequestID, err)
+		}
+		report.Updated++
+	}
+
+	return report, nil
+}
+
+// missingLocal checks a request ID that is not stored and reports it when
+// TokiPay knows it
+func (r *ReconciliationReport) missingLocal(client TokiPay, requestID string) {
+	r.Checked++
+	p := &Payment{RequestID: requestID}
+	resp, err := client.CheckPaymentStatus(requestID)
+	var rerr *ResponseError
+	switch {
+	case errors.As(err, &rerr) && rerr.Code == http.StatusNotFound:
+		// unknown on both sides
+	case err != nil:
+		r.add(p, DiscrepancyCheckFailed, "", "", err.Error())
+	default:
+		r.add(p, DiscrepancyMissingLocal, "none", resp.Status, "known to TokiPay but not stored")
+	}
+}
+
+// compare records the discrepancies between a stored payment, its history
+// and the status reported by TokiPay
+func (r *ReconciliationReport) compare(p *Payment, resp *PaymentStatusResponse, events []PaymentEvent) {
+	if resp.Status != p.Status {
+		r.add(p, DiscrepancyStatusChanged, p.Status, resp.Status, "")
+	}
+
+	if resp.Status == StatusApproved && p.CallbackStatus != StatusSuccess {
+		local := p.CallbackStatus
+		if local == "" {
+			local = "none"
+		}
+		r.add(p, DiscrepancyMissedCallback, local, StatusSuccess, "approved by TokiPay without a success callback")
+	}
+
+	var refunded float64
+	for _, e := range events {
+		switch e.Type {
+		case EventCallback:
+			if e.Status == StatusSuccess && e.Amount != p.Amount {
+				r.add(p, DiscrepancyAmountMismatch, fmt.Sprint(p.Amount), fmt.Sprint(e.Amount), "callback amount differs from order amount")
+			}
+		case EventRefunded:
+			refunded += e.Amount
+		}
+	}
+
+	if refunded > 0 && resp.Status != StatusApproved {
+		r.add(p, DiscrepancyUnexpectedRefund, fmt.Sprint(refunded), resp.Status, "refunds recorded for a payment that is not approved")
+	}
+	if refunded > p.Amount {
+		r.add(p, DiscrepancyUnexpectedRefund, fmt.Sprint(p.Amount), fmt.Sprint(refunded), "refunds exceed the payment amount")
+	}
+}
+
+func (r *ReconciliationReport) add(p *Payment, kind, local, remote, detail string) {
+	r.Discrepancies = append(r.Discrepancies, Discrepancy{
+		RequestID: p.RequestID,
+		OrderID:   p.OrderID,
+		Kind:      kind,
+		Local:     local,
+		Remote:    remote,
+		Detail:    detail,
+	})
+}
@@ -0,0 +1,279 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// StoreError reports that a TokiPay call succeeded but recording its result
+// in the PaymentStore failed. The call's response is returned alongside it.
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("failed to record %s: %v", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// RecordingClient wraps a TokiPay client and records every created payment,
+// status check, callback, cancellation, refund and VAT registration in a
+// PaymentStore
+type RecordingClient struct {
+	TokiPay
+	Store PaymentStore
+}
+
+var _ TokiPay = (*RecordingClient)(nil)
+
+// NewRecordingClient creates a client that records payments made through client in store
+func NewRecordingClient(client TokiPay, store PaymentStore) *RecordingClient {
+	return &RecordingClient{TokiPay: client, Store: store}
+}
+
+// CreateQRPayment creates a QR payment request and stores it as pending
+func (c *RecordingClient) CreateQRPayment(req QRPaymentRequest) (*QRPaymentResponse, error) {
+	resp, err := c.TokiPay.CreateQRPayment(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, c.created(&Payment{
+		OrderID:       req.OrderID,
+		RequestID:     resp.RequestID,
+		TransactionID: resp.TransactionID,
+		Method:        MethodQR,
+		Amount:        req.Amount,
+	})
+}
+
+// CreateMobilePayment creates a mobile payment request and stores it as pending
+func (c *RecordingClient) CreateMobilePayment(req MobilePaymentRequest) (*MobilePaymentResponse, error) {
+	resp, err := c.TokiPay.CreateMobilePayment(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, c.created(&Payment{
+		OrderID:   req.OrderID,
+		RequestID: resp.RequestID,
+		Method:    MethodMobile,
+		Amount:    req.Amount,
+	})
+}
+
+// CreateDeeplinkPayment creates a deeplink payment request and stores it as
+// pending. Deeplink responses carry no request ID, so the payment is stored
+// without one and its Key is its transaction ID.
+func (c *RecordingClient) CreateDeeplinkPayment(req DeeplinkPaymentRequest) (*DeeplinkPaymentResponse, error) {
+	resp, err := c.TokiPay.CreateDeeplinkPayment(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, c.created(&Payment{
+		OrderID:       req.OrderID,
+		TransactionID: resp.TransactionID,
+		Method:        MethodDeeplink,
+		Amount:        req.Amount,
+	})
+}
+
+// CheckPaymentStatus checks the status of a payment and stores the result
+func (c *RecordingClient) CheckPaymentStatus(requestID string) (*PaymentStatusResponse, error) {
+	resp, err := c.TokiPay.CheckPaymentStatus(requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, c.update("status check", requestID, func(p *Payment) *PaymentEvent {
+		ApplyStatus(p, resp)
+		return &PaymentEvent{Type: EventStatusChecked, Status: resp.Status}
+	})
+}
+
+// CancelPayment cancels a payment request and stores it as cancelled
+func (c *RecordingClient) CancelPayment(requestID string) error {
+	if err := c.TokiPay.CancelPayment(requestID); err != nil {
+		return err
+	}
+
+	return c.update("cancellation", requestID, func(p *Payment) *PaymentEvent {
+		p.Status = StatusCancelled
+		return &PaymentEvent{Type: EventCancelled, Status: StatusCancelled}
+	})
+}
+
+// RefundPayment processes a refund and adds it to the payment's refunded amount
+func (c *RecordingClient) RefundPayment(req RefundRequest) (*RefundResponse, error) {
+	resp, err := c.TokiPay.RefundPayment(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	p, err := c.Store.GetPaymentByTransNumber(ctx, req.TransNumber)
+	if errors.Is(err, ErrPaymentNotFound) {
+		return resp, nil
+	}
+	if err != nil {
+		return resp, &StoreError{Op: "refund", Err: err}
+	}
+
+	amount := p.Amount - p.RefundedAmount
+	if req.Amount != "" {
+		if amount, err = strconv.ParseFloat(req.Amount, 64); err != nil {
+			return resp, &StoreError{Op: "refund", Err: fmt.Errorf("invalid refund amount %q: %w", req.Amount, err)}
+		}
+	}
+
+	return resp, c.update("refund", p.Key, func(p *Payment) *PaymentEvent {
+		p.RefundedAmount += amount
+		return &PaymentEvent{
+			Type:      EventRefunded,
+			Amount:    amount,
+			Reference: resp.TxnNumber,
+			Detail:    resp.TopupTransnumber,
+		}
+	})
+}
+
+// RegisterVAT registers organization VAT details and marks the payment as registered
+func (c *RecordingClient) RegisterVAT(req VATRegistrationRequest) (*VATRegistrationResponse, error) {
+	resp, err := c.TokiPay.RegisterVAT(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	p, err := c.Store.GetPaymentByTransNumber(ctx, req.TransactionID)
+	if errors.Is(err, ErrPaymentNotFound) {
+		var payments []Payment
+		payments, err = c.Store.ListPayments(ctx, PaymentFilter{TransactionID: req.TransactionID, Limit: 1})
+		if err == nil && len(payments) == 0 {
+			return resp, nil
+		}
+		if err == nil {
+			p = &payments[0]
+		}
+	}
+	if err != nil {
+		return resp, &StoreError{Op: "VAT registration", Err: err}
+	}
+
+	return resp, c.update("VAT registration", p.Key, func(p *Payment) *PaymentEvent {
+		p.VATRegistered = true
+		return &PaymentEvent{
+			Type:      EventVATRegistered,
+			Status:    resp.Status,
+			Reference: req.DDTD,
+			Detail:    resp.Message,
+		}
+	})
+}
+
+// RecordCallback stores a callback received from TokiPay. A SUCCESS callback
+// marks a pending payment as approved. VAT headers are stored unless they
+// have a VATError. A deeplink payment, stored without a
+// request ID, is found by the callback's order ID and takes the callback's
+// request ID, so that its status can be checked from then on.
+func (c *RecordingClient) RecordCallback(ctx context.Context, callback CallbackRequest, headers CallbackHeaders) error {
+	p, err := c.Store.GetPayment(ctx, callback.RequestID)
+	if errors.Is(err, ErrPaymentNotFound) && callback.OrderID != "" {
+		if byOrder, oerr := c.Store.GetPaymentByOrder(ctx, callback.OrderID); oerr == nil && byOrder.RequestID == "" {
+			p, err = byOrder, nil
+			p.RequestID = callback.RequestID
+		}
+	}
+	if err != nil {
+		return &StoreError{Op: "callback", Err: err}
+	}
+
+	p.CallbackStatus = callback.Status
+	if callback.Status == StatusSuccess && !IsFinalStatus(p.Status) {
+		p.Status = StatusApproved
+	}
+	if headers.VATID != "" && headers.VATError == nil {
+		p.VATID = headers.VATID
+		p.VATType = headers.VATType
+	}
+
+	return c.save(ctx, "callback", p, &PaymentEvent{
+		Type:   EventCallback,
+		Status: callback.Status,
+		Amount: callback.Amount,
+	})
+}
+
+// ApplyStatus copies a status response onto a stored payment
+func ApplyStatus(p *Payment, resp *PaymentStatusResponse) {
+	p.Status = resp.Status
+	if resp.TransNumber != "" {
+		p.TransNumber = resp.TransNumber
+	}
+	if resp.Fee != 0 {
+		p.Fee = resp.Fee
+	}
+	if resp.VATDetails != nil {
+		p.VATType = resp.VATDetails.VATType
+		p.VATID = resp.VATDetails.VATID
+	}
+}
+
+// merchantIdentifier is implemented by clients that know the merchant ID
+// their payments are created for
+type merchantIdentifier interface {
+	merchantID() string
+}
+
+// created stores a newly created payment as pending
+func (c *RecordingClient) created(p *Payment) error {
+	if client, ok := c.TokiPay.(merchantIdentifier); ok {
+		p.MerchantID = client.merchantID()
+	}
+	p.Status = StatusPending
+	p.CreatedAt = time.Now()
+
+	return c.save(context.Background(), "payment", p, &PaymentEvent{
+		Type:   EventCreated,
+		Status: StatusPending,
+		Amount: p.Amount,
+	})
+}
+
+// update loads the payment with the given Key, applies fn and saves it
+// with the event fn returns. Payments missing from the store are skipped.
+func (c *RecordingClient) update(op, key string, fn func(p *Payment) *PaymentEvent) error {
+	ctx := context.Background()
+	p, err := c.Store.GetPayment(ctx, key)
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil
+	}
+	if err != nil {
+		return &StoreError{Op: op, Err: err}
+	}
+
+	return c.save(ctx, op, p, fn(p))
+}
+
+func (c *RecordingClient) save(ctx context.Context, op string, p *Payment, e *PaymentEvent) error {
+	now := time.Now()
+	p.UpdatedAt = now
+	if err := c.Store.SavePayment(ctx, p); err != nil {
+		return &StoreError{Op: op, Err: err}
+	}
+
+	e.PaymentKey = p.Key
+	e.CreatedAt = now
+	if err := c.Store.AddEvent(ctx, e); err != nil {
+		return &StoreError{Op: op, Err: err}
+	}
+	return nil
+}
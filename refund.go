@@ -0,0 +1,315 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRefundNotFound is returned by Resolve for a refund not in the ledger
+var ErrRefundNotFound = errors.New("refund not found")
+
+// Refund statuses recorded in a RefundStore. A refund stays pending while
+// its outcome is unknown; Resolve settles it.
+const (
+	RefundPending   = "PENDING"
+	RefundCompleted = "COMPLETED"
+	RefundFailed    = "FAILED"
+)
+
+// Refund is one refund of a settled transaction
+type Refund struct {
+	ID               int64     `json:"id,omitempty"`
+	TransNumber      string    `json:"transNumber"`
+	PaymentKey       string    `json:"paymentKey,omitempty"`
+	Amount           float64   `json:"amount"`
+	Reason           string    `json:"reason,omitempty"`
+	OperatorID       string    `json:"operatorId,omitempty"`
+	Status           string    `json:"status"`
+	TxnNumber        string    `json:"txnNumber,omitempty"`
+	TopupTransnumber string    `json:"topupTransnumber,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// RefundStore persists the refund ledger
+type RefundStore interface {
+	// AddRefund inserts a refund and sets its ID, unless the refund and the
+	// transaction's completed and pending refunds together exceed captured,
+	// in which case it returns an *OverRefundError. The check and the insert
+	// are one atomic step, so refunds added concurrently by other processes
+	// are counted.
+	AddRefund(ctx context.Context, r *Refund, captured float64) error
+
+	// UpdateRefund saves the status, references and error of a refund
+	UpdateRefund(ctx context.Context, r *Refund) error
+
+	// ListRefunds returns the refunds of a transaction, oldest first
+	ListRefunds(ctx context.Context, transNumber string) ([]Refund, error)
+}
+
+// OverRefundError is returned when a refund would exceed the amount left to refund
+type OverRefundError struct {
+	TransNumber string
+	Amount      float64
+	Remaining   float64
+}
+
+func (e *OverRefundError) Error() string {
+	if e.Remaining <= 0 {
+		return fmt.Sprintf("transaction %s has nothing left to refund", e.TransNumber)
+	}
+	return fmt.Sprintf("refund of %v exceeds the %v left to refund on transaction %s", e.Amount, e.Remaining, e.TransNumber)
+}
+
+// RefundBalance is the refund position of a transaction
+type RefundBalance struct {
+	TransNumber string  `json:"transNumber"`
+	Captured    float64 `json:"captured"`
+	Refunded    float64 `json:"refunded"`
+	Pending     float64 `json:"pending"`
+	Remaining   float64 `json:"remaining"`
+}
+
+// RefundParams describe a refund made through a RefundManager
+type RefundParams struct {
+	TransNumber string
+
+	// Amount to refund. Zero refunds everything left.
+	Amount float64
+
+	Reason     string
+	OperatorID string
+}
+
+// RefundManager refunds transactions through a ledger that tracks cumulative
+// refunds per TransNumber and rejects refunds exceeding the captured amount.
+// Client should not be a RecordingClient: the manager records refunds on
+// the stored payment itself.
+type RefundManager struct {
+	Client   TokiPay
+	Payments PaymentStore
+	Refunds  RefundStore
+
+	mu    sync.Mutex
+	locks map[string]*refundLock
+}
+
+// refundLock serializes the refunds of one transaction while refs callers
+// hold or wait for it
+type refundLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewRefundManager creates a refund manager
+func NewRefundManager(client TokiPay, payments PaymentStore, refunds RefundStore) *RefundManager {
+	return &RefundManager{Client: client, Payments: payments, Refunds: refunds}
+}
+
+// Balance returns how much of a transaction was captured and refunded.
+// Pending refunds count against the remaining amount.
+func (m *RefundManager) Balance(ctx context.Context, transNumber string) (*RefundBalance, error) {
+	p, err := m.Payments.GetPaymentByTransNumber(ctx, transNumber)
+	if err != nil {
+		return nil, err
+	}
+	return m.balance(ctx, p)
+}
+
+func (m *RefundManager) balance(ctx context.Context, p *Payment) (*RefundBalance, error) {
+	refunds, err := m.Refunds.ListRefunds(ctx, p.TransNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refunds: %w", err)
+	}
+
+	b := &RefundBalance{TransNumber: p.TransNumber, Captured: p.Amount}
+	for _, r := range refunds {
+		switch r.Status {
+		case RefundCompleted:
+			b.Refunded += r.Amount
+		case RefundPending:
+			b.Pending += r.Amount
+		}
+	}
+	b.Refunded = roundAmount(b.Refunded)
+	b.Pending = roundAmount(b.Pending)
+	b.Remaining = roundAmount(b.Captured - b.Refunded - b.Pending)
+	return b, nil
+}
+
+// Refund refunds part or all of an approved transaction. Each refund is
+// added to the ledger as pending before TokiPay is called; the RefundStore
+// checks it against the captured amount as it adds it, so refunds made by
+// other processes at the same time are counted too. If TokiPay accepts the refund but recording it fails, the refund
+// is returned with a *StoreError. A refund TokiPay declines is failed; when
+// the outcome is unknown, as after a timeout, the refund is returned still
+// pending with the error and must be settled with Resolve.
+func (m *RefundManager) Refund(ctx context.Context, params RefundParams) (*Refund, error) {
+	unlock := m.lock(params.TransNumber)
+	defer unlock()
+
+	p, err := m.Payments.GetPaymentByTransNumber(ctx, params.TransNumber)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != StatusApproved {
+		return nil, fmt.Errorf("payment %s is %s, only approved payments can be refunded", p.Key, p.Status)
+	}
+
+	balance, err := m.balance(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Amount < 0 {
+		return nil, errors.New("refund amount must not be negative")
+	}
+	amount := params.Amount
+	if amount == 0 {
+		amount = balance.Remaining
+	}
+	if amount <= 0 || amount-balance.Remaining >= 0.005 {
+		return nil, &OverRefundError{TransNumber: params.TransNumber, Amount: amount, Remaining: balance.Remaining}
+	}
+
+	now := time.Now()
+	r := &Refund{
+		TransNumber: params.TransNumber,
+		PaymentKey:  p.Key,
+		Amount:      roundAmount(amount),
+		Reason:      params.Reason,
+		OperatorID:  params.OperatorID,
+		Status:      RefundPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := m.Refunds.AddRefund(ctx, r, p.Amount); err != nil {
+		var over *OverRefundError
+		if errors.As(err, &over) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to add refund: %w", err)
+	}
+
+	resp, err := m.Client.RefundPayment(RefundRequest{
+		TransNumber: params.TransNumber,
+		Amount:      strconv.FormatFloat(r.Amount, 'f', -1, 64),
+	})
+	r.UpdatedAt = time.Now()
+	if err != nil {
+		// Only a refund TokiPay declined is failed. After a timeout or an
+		// unreadable response the refund may have been made, so it stays
+		// pending and keeps counting against the balance until resolved.
+		var rerr *ResponseError
+		if errors.As(err, &rerr) && rerr.Rejected() {
+			r.Status = RefundFailed
+		}
+		r.Error = err.Error()
+		if serr := m.Refunds.UpdateRefund(ctx, r); serr != nil {
+			return r, errors.Join(err, &StoreError{Op: "refund", Err: serr})
+		}
+		return r, err
+	}
+
+	r.TxnNumber = resp.TxnNumber
+	r.TopupTransnumber = resp.TopupTransnumber
+	return r, m.complete(ctx, p, r)
+}
+
+// Resolve settles a refund left pending because TokiPay's answer was lost,
+// once its outcome is known, such as from a settlement file or TokiPay
+// support. A completed refund is recorded on the payment like any other;
+// txnNumber is TokiPay's reference for it, if known.
+func (m *RefundManager) Resolve(ctx context.Context, transNumber string, id int64, completed bool, txnNumber string) (*Refund, error) {
+	unlock := m.lock(transNumber)
+	defer unlock()
+
+	refunds, err := m.Refunds.ListRefunds(ctx, transNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refunds: %w", err)
+	}
+	i := slices.IndexFunc(refunds, func(r Refund) bool { return r.ID == id })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %d on transaction %s", ErrRefundNotFound, id, transNumber)
+	}
+	r := &refunds[i]
+	if r.Status != RefundPending {
+		return nil, fmt.Errorf("refund %d is %s, only pending refunds can be resolved", id, r.Status)
+	}
+
+	r.UpdatedAt = time.Now()
+	if !completed {
+		r.Status = RefundFailed
+		if err := m.Refunds.UpdateRefund(ctx, r); err != nil {
+			return r, &StoreError{Op: "refund", Err: err}
+		}
+		return r, nil
+	}
+
+	p, err := m.Payments.GetPaymentByTransNumber(ctx, transNumber)
+	if err != nil {
+		return nil, err
+	}
+	r.TxnNumber = txnNumber
+	r.Error = ""
+	return r, m.complete(ctx, p, r)
+}
+
+// complete records a refund TokiPay made in the ledger and on the payment
+func (m *RefundManager) complete(ctx context.Context, p *Payment, r *Refund) error {
+	r.Status = RefundCompleted
+	if err := m.Refunds.UpdateRefund(ctx, r); err != nil {
+		return &StoreError{Op: "refund", Err: err}
+	}
+
+	p.RefundedAmount = roundAmount(p.RefundedAmount + r.Amount)
+	p.UpdatedAt = r.UpdatedAt
+	if err := m.Payments.SavePayment(ctx, p); err != nil {
+		return &StoreError{Op: "refund", Err: err}
+	}
+	err := m.Payments.AddEvent(ctx, &PaymentEvent{
+		PaymentKey: p.Key,
+		Type:       EventRefunded,
+		Amount:     r.Amount,
+		Reference:  r.TxnNumber,
+		Detail:     r.TopupTransnumber,
+		CreatedAt:  r.UpdatedAt,
+	})
+	if err != nil {
+		return &StoreError{Op: "refund", Err: err}
+	}
+	return nil
+}
+
+// lock serializes refunds of one transaction and returns the unlock func.
+// The lock is dropped once no refund of the transaction holds or waits for
+// it.
+func (m *RefundManager) lock(transNumber string) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*refundLock)
+	}
+	l, ok := m.locks[transNumber]
+	if !ok {
+		l = &refundLock{}
+		m.locks[transNumber] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, transNumber)
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,110 @@
+package tokipay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ResponseMeta describes how TokiPay answered a call: the response envelope
+// without its data, and the HTTP status and headers. TokiPay support asks
+// for these when a call is reported.
+type ResponseMeta struct {
+	Code       int         `json:"code"`
+	Status     string      `json:"status"`
+	Timestamp  Timestamp   `json:"timestamp"`
+	Error      string      `json:"error,omitempty"` // message of the envelope's error object
+	HTTPStatus int         `json:"httpStatus"`
+	Header     http.Header `json:"header,omitempty"`
+}
+
+// ResponseError is returned when TokiPay answers a call with an error
+// envelope
+type ResponseError struct {
+	Op         string // the call, such as "refund request"
+	Code       int    // code of the envelope
+	HTTPStatus int
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Op, e.Message)
+}
+
+// Rejected reports whether TokiPay declined the call without acting on it,
+// with a 4xx code. A 5xx code or an envelope without a code is not a
+// rejection: TokiPay may have acted on the call, so its outcome is
+// unknown, as it is for transport errors and unreadable responses.
+func (e *ResponseError) Rejected() bool {
+	return 400 <= e.Code && e.Code < 500
+}
+
+// TransportError is returned when a request got no response or its
+// response could not be read. TokiPay may have acted on the request.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Response is the typed data of a call together with its ResponseMeta
+type Response[T any] struct {
+	ResponseMeta
+	Data T `json:"data"`
+}
+
+// Do calls a TokiPay endpoint the client has no method for, such as one
+// added after this release. path is relative to the client's BaseURL and
+// may carry a query string; body, if not nil, is sent as JSON. The call is
+// authenticated and sent with the same headers as every other call, and the
+// data of the response envelope is decoded into T. As with the WithResponse
+// methods, the response is returned together with the error when TokiPay
+// rejects the call.
+//
+//	history, err := tokipay.Do[[]Transaction](ctx, client, "GET", "/third-party-service/v1/transactions?page=1", nil)
+func Do[T any](ctx context.Context, client *TokiPayClient, method, path string, body any) (*Response[T], error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if err := client.getAccessToken(ctx); err != nil {
+		return nil, err
+	}
+	return call[T](ctx, client, method+" "+path, method, path, body)
+}
+
+// call sends a request and decodes the response envelope. The response is
+// returned whenever TokiPay answered with an envelope, also together with
+// the error of a call TokiPay rejected; op names the call in that error.
+func call[T any](ctx context.Context, c *TokiPayClient, op, method, endpoint string, body any) (*Response[T], error) {
+	var env TokiPayResponse[T]
+	httpResp, err := c.makeRequest(ctx, method, endpoint, body, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response[T]{
+		ResponseMeta: ResponseMeta{
+			Code:       env.Code,
+			Status:     env.Status,
+			Timestamp:  env.Timestamp,
+			HTTPStatus: httpResp.StatusCode,
+			Header:     httpResp.Header,
+		},
+		Data: env.Data,
+	}
+	if env.Error != nil {
+		resp.Error = env.Error.Message
+	}
+
+	if env.Code != 200 {
+		return resp, &ResponseError{Op: op, Code: env.Code, HTTPStatus: httpResp.StatusCode, Message: env.ErrorMessage()}
+	}
+	return resp, nil
+}
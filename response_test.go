@@ -0,0 +1,102 @@
+package tokipay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestResponseMeta(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	qr := tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+	}
+	resp, err := client.CreateQRPaymentWithResponse(qr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != 200 || resp.HTTPStatus != http.StatusOK || resp.Status == "" || resp.Timestamp.IsZero() ||
+		resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("meta = %+v", resp.ResponseMeta)
+	}
+	if resp.Data.RequestID == "" || resp.Data.Timestamp != resp.Timestamp {
+		t.Errorf("data = %+v", resp.Data)
+	}
+
+	srv.FailNext(tokipaytest.EndpointStatus, 1, tokipaytest.InternalError())
+	status, err := client.CheckPaymentStatusWithResponse(resp.Data.RequestID)
+	if err == nil {
+		t.Fatal("rejected status check succeeded")
+	}
+	if status == nil {
+		t.Fatal("rejected status check returned no response")
+	}
+	if status.Code != http.StatusInternalServerError || status.HTTPStatus != http.StatusInternalServerError ||
+		status.Error != "internal server error" || status.Timestamp.IsZero() {
+		t.Errorf("meta of rejected call = %+v", status.ResponseMeta)
+	}
+
+	cancel, err := client.CancelPaymentWithResponse(resp.Data.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancel.HTTPStatus != http.StatusOK {
+		t.Errorf("cancel meta = %+v", cancel.ResponseMeta)
+	}
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	created, err := tokipay.Do[tokipay.QRPaymentResponse](ctx, client, "POST", tokipay.QRPaymentEndpoint, tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+		MerchantID: client.MerchantID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Data.RequestID == "" || created.HTTPStatus != http.StatusOK {
+		t.Fatalf("created = %+v", *created)
+	}
+
+	// a map decodes fields this version has no struct for
+	status, err := tokipay.Do[map[string]any](ctx, client, "GET", tokipay.StatusEndpoint+"?requestId="+created.Data.RequestID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Data["status"] != tokipay.StatusPending {
+		t.Errorf("status data = %v", status.Data)
+	}
+
+	srv.FailNext(tokipaytest.EndpointStatus, 1, tokipaytest.Fault{StatusCode: http.StatusBadRequest, Message: "requestId is invalid"})
+	rejected, err := tokipay.Do[map[string]any](ctx, client, "GET", "third-party-service/v1/payment-request/status?requestId=x", nil)
+	if err == nil || !strings.Contains(err.Error(), "requestId is invalid") {
+		t.Errorf("rejected call error = %v", err)
+	}
+	if rejected == nil || rejected.HTTPStatus != http.StatusBadRequest || rejected.Error != "requestId is invalid" {
+		t.Errorf("rejected call response = %+v", rejected)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := tokipay.Do[any](cancelled, client, "GET", tokipay.StatusEndpoint, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("call with cancelled context error = %v, want context.Canceled", err)
+	}
+}
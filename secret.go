@@ -0,0 +1,128 @@
+package tokipay
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// Redacted replaces the value of a non-empty Secret in printed and
+// marshalled output
+const Redacted = "[REDACTED]"
+
+// Secret holds a password or token. It prints, marshals and logs as
+// Redacted, so a struct holding it can be logged safely; the real value is
+// only available through Reveal. An empty Secret prints as an empty string,
+// so a missing value is still visible. Secrets unmarshal from plain JSON
+// strings and text.
+type Secret string
+
+// Reveal returns the secret value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) redacted() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// String implements fmt.Stringer
+func (s Secret) String() string {
+	return s.redacted()
+}
+
+// GoString implements fmt.GoStringer
+func (s Secret) GoString() string {
+	return fmt.Sprintf("tokipay.Secret(%q)", s.redacted())
+}
+
+// Format implements fmt.Formatter, so that no verb prints the value
+func (s Secret) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if f.Flag('#') {
+			fmt.Fprint(f, s.GoString())
+			return
+		}
+		fmt.Fprint(f, s.redacted())
+	case 'q':
+		fmt.Fprintf(f, "%q", s.redacted())
+	default:
+		fmt.Fprint(f, s.redacted())
+	}
+}
+
+// MarshalJSON implements json.Marshaler
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.redacted())
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.redacted()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (s *Secret) UnmarshalText(data []byte) error {
+	*s = Secret(data)
+	return nil
+}
+
+// LogValue implements slog.LogValuer
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.redacted())
+}
+
+// String describes the client without its credentials and token
+func (c *TokiPayClient) String() string {
+	if c == nil {
+		return "TokiPayClient(nil)"
+	}
+	return fmt.Sprintf("TokiPayClient(merchant %s at %s)", c.MerchantID, c.BaseURL)
+}
+
+// GoString implements fmt.GoStringer
+func (c *TokiPayClient) GoString() string {
+	return c.String()
+}
+
+// Format implements fmt.Formatter. Every verb prints String, so %+v and
+// %#v do not dump the client's fields.
+func (c *TokiPayClient) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, c.String())
+}
+
+// MarshalJSON implements json.Marshaler with the client's settings; the
+// password and access token are redacted
+func (c *TokiPayClient) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("null"), nil
+	}
+	c.mu.Lock()
+	token, expiry := c.AccessToken, c.TokenExpiry
+	c.mu.Unlock()
+
+	return json.Marshal(struct {
+		BaseURL     string    `json:"baseUrl"`
+		Username    string    `json:"username"`
+		Password    Secret    `json:"password"`
+		MerchantID  string    `json:"merchantId"`
+		AccessToken Secret    `json:"accessToken"`
+		TokenExpiry Timestamp `json:"tokenExpiry,omitzero"`
+	}{c.BaseURL, c.Username, c.Password, c.MerchantID, token, NewTimestamp(expiry)})
+}
+
+// LogValue implements slog.LogValuer
+func (c *TokiPayClient) LogValue() slog.Value {
+	if c == nil {
+		return slog.StringValue(c.String())
+	}
+	return slog.GroupValue(
+		slog.String("base_url", c.BaseURL),
+		slog.String("merchant_id", c.MerchantID),
+		slog.String("username", c.Username),
+	)
+}
@@ -0,0 +1,91 @@
+package tokipay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSecretRedaction(t *testing.T) {
+	const password = "hunter2"
+	creds := Credentials{Username: "shop", Password: password}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%s", Redacted},
+		{"%v", Redacted},
+		{"%q", `"` + Redacted + `"`},
+		{"%x", Redacted},
+		{"%#v", `tokipay.Secret("` + Redacted + `")`},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprintf(tt.format, creds.Password); got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+	for _, format := range []string{"%v", "%+v", "%#v"} {
+		if got := fmt.Sprintf(format, creds); strings.Contains(got, password) {
+			t.Errorf("Sprintf(%q) of Credentials = %s", format, got)
+		}
+	}
+	if got := fmt.Sprint(Secret("")); got != "" {
+		t.Errorf("empty secret prints as %q", got)
+	}
+	if creds.Password.Reveal() != password {
+		t.Errorf("Reveal() = %q", creds.Password.Reveal())
+	}
+
+	out, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"Username":"shop","Password":"`+Redacted+`"}` {
+		t.Errorf("Marshal = %s", out)
+	}
+	var decoded Credentials
+	if err := json.Unmarshal([]byte(`{"Username":"shop","Password":"hunter2"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != creds {
+		t.Errorf("Unmarshal = %+v", decoded)
+	}
+
+	var logs bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logs, nil)).Info("login", "password", creds.Password)
+	if strings.Contains(logs.String(), password) || !strings.Contains(logs.String(), Redacted) {
+		t.Errorf("log = %s", logs.String())
+	}
+}
+
+func TestClientRedaction(t *testing.T) {
+	client := New(TestBaseURL, "shop", "hunter2", "merchant-1").(*TokiPayClient)
+	client.AccessToken = "token-123"
+	client.tokenCredentials = Credentials{Username: "shop", Password: "hunter2"}
+
+	var outputs []string
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		outputs = append(outputs, fmt.Sprintf(format, client))
+	}
+	out, err := json.Marshal(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs = append(outputs, string(out))
+	var logs bytes.Buffer
+	slog.New(slog.NewTextHandler(&logs, nil)).Info("client", "client", client)
+	outputs = append(outputs, logs.String())
+
+	for _, s := range outputs {
+		if strings.Contains(s, "hunter2") || strings.Contains(s, "token-123") {
+			t.Errorf("output leaks a secret: %s", s)
+		}
+		if !strings.Contains(s, "merchant-1") {
+			t.Errorf("output lacks the merchant ID: %s", s)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package tokipay
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Settlement match results
+const (
+	SettlementMatched        = "MATCHED"
+	SettlementUnmatched      = "UNMATCHED"
+	SettlementFeeVariance    = "FEE_VARIANCE"
+	SettlementAmountMismatch = "AMOUNT_MISMATCH"
+)
+
+// SettlementLine is one transaction in a TokiPay settlement export
+type SettlementLine struct {
+	Line        int     `json:"line"`
+	TransNumber string  `json:"transNumber"`
+	OrderID     string  `json:"orderId,omitempty"`
+	Date        string  `json:"date,omitempty"`
+	Amount      float64 `json:"amount"`
+	Fee         float64 `json:"fee"`
+}
+
+// settlementColumns maps normalized header names to SettlementLine fields.
+// Headers are compared lower case with spaces and punctuation removed.
+var settlementColumns = map[string]string{
+	"transnumber":       "transNumber",
+	"transno":           "transNumber",
+	"transactionnumber": "transNumber",
+	"гүйлгээнийдугаар":  "transNumber",
+	"orderid":           "orderId",
+	"ordernumber":       "orderId",
+	"захиалгындугаар":   "orderId",
+	"date":              "date",
+	"transdate":         "date",
+	"transactiondate":   "date",
+	"createdat":         "date",
+	"огноо":             "date",
+	"amount":            "amount",
+	"transamount":       "amount",
+	"дүн":               "amount",
+	"гүйлгээнийдүн":     "amount",
+	"fee":               "fee",
+	"commission":        "fee",
+	"шимтгэл":           "fee",
+}
+
+// ParseSettlement reads a settlement export in CSV form, including CSV saved
+// from Excel. The delimiter (comma, semicolon or tab) is detected, title rows
+// above the header and rows without a transaction number, such as a totals
+// row, are skipped. The header must name the trans number, amount and fee columns.
+func ParseSettlement(r io.Reader) ([]SettlementLine, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	var lastErr error
+	for _, delim := range []rune{',', ';', '\t'} {
+		lines, err := parseSettlement(data, delim)
+		if err == nil {
+			return lines, nil
+		}
+		if !errors.Is(err, errNoSettlementHeader) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+var errNoSettlementHeader = errors.New("settlement has no header with trans number, amount and fee columns")
+
+func parseSettlement(data []byte, delim rune) ([]SettlementLine, error) {
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.Comma = delim
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	// a tab is white space too, so trimming would drop empty cells of a
+	// tab-delimited file; cleanCell trims every cell anyway
+	cr.TrimLeadingSpace = delim != '\t'
+
+	var columns map[string]int
+	var lines []SettlementLine
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse settlement: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		if columns == nil {
+			columns = settlementHeader(record)
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return cleanCell(record[i])
+			}
+			return ""
+		}
+
+		transNumber := normalizeTransNumber(field("transNumber"))
+		if !strings.ContainsAny(transNumber, "0123456789") {
+			continue
+		}
+
+		l := SettlementLine{
+			Line:        line,
+			TransNumber: transNumber,
+			OrderID:     field("orderId"),
+			Date:        field("date"),
+		}
+		if l.Amount, err = parseSettlementAmount(field("amount")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount: %w", line, err)
+		}
+		if l.Fee, err = parseSettlementAmount(field("fee")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid fee: %w", line, err)
+		}
+		lines = append(lines, l)
+	}
+
+	if columns == nil {
+		return nil, errNoSettlementHeader
+	}
+	return lines, nil
+}
+
+// settlementHeader returns the column index of each known field, or nil if
+// record is not a header row
+func settlementHeader(record []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range record {
+		key := strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '_', '-', '.', '#', '(', ')':
+				return -1
+			}
+			return r
+		}, strings.ToLower(cleanCell(name)))
+
+		if field, ok := settlementColumns[key]; ok {
+			if _, dup := columns[field]; !dup {
+				columns[field] = i
+			}
+		}
+	}
+
+	for _, required := range []string{"transNumber", "amount", "fee"} {
+		if _, ok := columns[required]; !ok {
+			return nil
+		}
+	}
+	return columns
+}
+
+// cleanCell trims a cell and removes the quoting Excel adds to keep
+// numbers as text: a leading apostrophe or the ="..." formula form
+func cleanCell(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "'")
+	if strings.HasPrefix(s, `="`) && strings.HasSuffix(s, `"`) {
+		s = s[2 : len(s)-1]
+	}
+	return strings.TrimSpace(s)
+}
+
+// normalizeTransNumber drops the fraction Excel appends to numeric cells
+func normalizeTransNumber(s string) string {
+	if i := strings.IndexByte(s, '.'); i > 0 && strings.Trim(s[i+1:], "0") == "" {
+		if _, err := strconv.ParseUint(s[:i], 10, 64); err == nil {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parseSettlementAmount parses amounts with currency signs and thousand
+// separators. The decimal separator is told from the separators in the
+// amount rather than from the file's delimiter: when both a comma and a
+// point appear the last one is decimal, a separator repeated alone groups
+// thousands, and a lone separator is decimal unless exactly three digits
+// follow it, as in "1,500", since amounts have at most two decimals.
+func parseSettlementAmount(s string) (float64, error) {
+	s = strings.NewReplacer("₮", "", "MNT", "", " ", "", "\u00a0", "", "'", "").Replace(s)
+	if s == "" || s == "-" {
+		return 0, nil
+	}
+
+	number := s
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 {
+		sep, other := s[i], byte(',')
+		if sep == ',' {
+			other = '.'
+		}
+		integer, fraction := s[:i], s[i+1:]
+		group := other
+		if strings.IndexByte(integer, other) < 0 && (strings.IndexByte(integer, sep) >= 0 || len(fraction) == 3) {
+			integer, fraction, group = s, "", sep
+		}
+
+		groups := strings.Split(integer, string(group))
+		for _, g := range groups[1:] {
+			if len(g) != 3 {
+				return 0, fmt.Errorf("%q is not a number", s)
+			}
+		}
+		number = strings.Join(groups, "")
+		if fraction != "" {
+			number += "." + fraction
+		}
+	}
+
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return v, nil
+}
+
+// SettlementOptions configure MatchSettlement
+type SettlementOptions struct {
+	// FeeTolerance is the largest fee difference still reported as matched
+	FeeTolerance float64
+
+	// FeeRate is the expected fee as a fraction of the amount, used for
+	// stored payments whose fee is not known. Zero expects no fee.
+	FeeRate float64
+}
+
+// SettlementMatch is the result of matching one settlement line
+type SettlementMatch struct {
+	Line           int     `json:"line"`
+	TransNumber    string  `json:"transNumber"`
+	OrderID        string  `json:"orderId"`
+	RequestID      string  `json:"requestId"`
+	Result         string  `json:"result"`
+	Amount         float64 `json:"amount"`
+	ExpectedAmount float64 `json:"expectedAmount"`
+	Fee            float64 `json:"fee"`
+	ExpectedFee    float64 `json:"expectedFee"`
+	FeeVariance    float64 `json:"feeVariance"`
+}
+
+// SettlementReport summarizes the matching of a settlement file
+type SettlementReport struct {
+	Lines            []SettlementMatch `json:"lines"`
+	Matched          int               `json:"matched"`
+	Unmatched        int               `json:"unmatched"`
+	FeeVariances     int               `json:"feeVariances"`
+	AmountMismatches int               `json:"amountMismatches"`
+	TotalAmount      float64           `json:"totalAmount"`
+	TotalFee         float64           `json:"totalFee"`
+	TotalFeeVariance float64           `json:"totalFeeVariance"`
+}
+
+// MatchSettlement matches settlement lines against stored payments by
+// transaction number and compares their amounts and fees
+func MatchSettlement(ctx context.Context, store PaymentStore, lines []SettlementLine, opts SettlementOptions) (*SettlementReport, error) {
+	report := &SettlementReport{Lines: make([]SettlementMatch, 0, len(lines))}
+
+	for _, l := range lines {
+		m := SettlementMatch{
+			Line:        l.Line,
+			TransNumber: l.TransNumber,
+			OrderID:     l.OrderID,
+			Amount:      l.Amount,
+			Fee:         l.Fee,
+		}
+		report.TotalAmount += l.Amount
+		report.TotalFee += l.Fee
+
+		p, err := store.GetPaymentByTransNumber(ctx, l.TransNumber)
+		switch {
+		case errors.Is(err, ErrPaymentNotFound):
+			m.Result = SettlementUnmatched
+			report.Unmatched++
+			report.Lines = append(report.Lines, m)
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed to match line %d: %w", l.Line, err)
+		}
+
+		m.OrderID = p.OrderID
+		m.RequestID = p.RequestID
+		m.ExpectedAmount = p.Amount
+		m.ExpectedFee = p.Fee
+		if m.ExpectedFee == 0 {
+			m.ExpectedFee = roundAmount(p.Amount * opts.FeeRate)
+		}
+		m.FeeVariance = roundAmount(l.Fee - m.ExpectedFee)
+
+		switch {
+		case math.Abs(l.Amount-p.Amount) >= 0.005:
+			m.Result = SettlementAmountMismatch
+			report.AmountMismatches++
+		case math.Abs(m.FeeVariance) > opts.FeeTolerance:
+			m.Result = SettlementFeeVariance
+			report.FeeVariances++
+		default:
+			m.Result = SettlementMatched
+			report.Matched++
+		}
+		report.TotalFeeVariance += m.FeeVariance
+		report.Lines = append(report.Lines, m)
+	}
+
+	report.TotalAmount = roundAmount(report.TotalAmount)
+	report.TotalFee = roundAmount(report.TotalFee)
+	report.TotalFeeVariance = roundAmount(report.TotalFeeVariance)
+	return report, nil
+}
+
+// Discrepancies returns the number of lines that did not match cleanly
+func (r *SettlementReport) Discrepancies() int {
+	return r.Unmatched + r.FeeVariances + r.AmountMismatches
+}
+
+// WriteCSV writes one row per settlement line with a header row
+func (r *SettlementReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "trans_number", "order_id", "request_id", "result",
+		"amount", "expected_amount", "fee", "expected_fee", "fee_variance"})
+
+	for _, m := range r.Lines {
+		cw.Write([]string{
+			strconv.Itoa(m.Line),
+			m.TransNumber,
+			m.OrderID,
+			m.RequestID,
+			m.Result,
+			formatAmount(m.Amount),
+			formatAmount(m.ExpectedAmount),
+			formatAmount(m.Fee),
+			formatAmount(m.ExpectedFee),
+			formatAmount(m.FeeVariance),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *SettlementReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// roundAmount rounds to the nearest möngö, halves away from zero. The
+// nudge keeps values like 1.005, stored as 1.00499..., rounding up.
+func roundAmount(v float64) float64 {
+	return math.Round(v*100+math.Copysign(1e-7, v)) / 100
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
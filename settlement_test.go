@@ -0,0 +1,103 @@
+package tokipay
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSettlement(t *testing.T) {
+	want := []SettlementLine{
+		{Line: 3, TransNumber: "3425279", OrderID: "ORDER_1", Amount: 1500.5, Fee: 15.01},
+		{Line: 4, TransNumber: "3425280", OrderID: "ORDER_2", Amount: 1234567, Fee: 0},
+	}
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"comma", "TokiPay settlement,,,\n" +
+			"Trans number,Order ID,Amount,Fee\n" +
+			"3425279,ORDER_1,\"1,500.50\",15.01\n" +
+			"3425280.0,ORDER_2,\"1,234,567\",-\n" +
+			"Total,,\"1,236,067.50\",15.01\n"},
+		{"semicolon", "\ufeffTokiPay settlement;;;\n" +
+			"Гүйлгээний дугаар;Захиалгын дугаар;Дүн;Шимтгэл\n" +
+			"=\"3425279\";ORDER_1;1.500,50 ₮;15,01\n" +
+			"'3425280;ORDER_2;1.234.567;\n" +
+			"Нийт;;1.236.067,50;15,01\n"},
+		{"tab", "TokiPay settlement\n" +
+			"trans_no\torder_number\ttrans_amount\tcommission\n" +
+			"3425279\tORDER_1\t1500,50\t15.01\n" +
+			"3425280\tORDER_2\t1 234 567 MNT\t0\n" +
+			"\t\t1236067,50\t15,01\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := ParseSettlement(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != len(want) {
+				t.Fatalf("got %d lines, want %d: %+v", len(lines), len(want), lines)
+			}
+			for i := range want {
+				if lines[i] != want[i] {
+					t.Errorf("line %d = %+v, want %+v", i, lines[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseSettlementErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"no header", "3425279,1000,10\n", "no header"},
+		{"missing fee column", "trans number,amount\n3425279,1000\n", "no header"},
+		{"invalid amount", "trans number,amount,fee\n3425279,many,10\n", "line 2: invalid amount"},
+		{"invalid fee", "trans number;amount;fee\n3425279;1000;1,5,0,0\n", "line 2: invalid fee"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSettlement(strings.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseSettlement error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSettlementAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"", 0},
+		{"-", 0},
+		{"1500", 1500},
+		{"1500.5", 1500.5},
+		{"1500,50", 1500.5},
+		{"1,500", 1500},
+		{"1.500", 1500},
+		{"1,500.50", 1500.5},
+		{"1.500,50", 1500.5},
+		{"1,234,567", 1234567},
+		{"1.234.567,89", 1234567.89},
+		{"-12,5", -12.5},
+		{"₮ 1 500,50", 1500.5},
+		{"1'500.50 MNT", 1500.5},
+	}
+	for _, tt := range tests {
+		got, err := parseSettlementAmount(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSettlementAmount(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"abc", "1,5,0", "1.000.5", "1.5.0,00"} {
+		if _, err := parseSettlementAmount(in); err == nil {
+			t.Errorf("parseSettlementAmount(%q) succeeded", in)
+		}
+	}
+}
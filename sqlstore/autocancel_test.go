@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestSQLiteAutoCanceller(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	client := tokipay.NewRecordingClient(srv.NewClient(), s)
+	var requestIDs []string
+	for _, orderID := range []string{"ORDER_1", "ORDER_2"} {
+		qr, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+			SuccessURL: "https://example.com/success",
+			FailureURL: "https://example.com/failure",
+			OrderID:    orderID,
+			Amount:     1000,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		requestIDs = append(requestIDs, qr.RequestID)
+	}
+	if _, err := client.CreateDeeplinkPayment(tokipay.DeeplinkPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_3",
+		Amount:     1000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// approved by the customer after it was stored as pending
+	if err := srv.Approve(requestIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []tokipay.AutoCancelEvent
+	onEvent := func(e tokipay.AutoCancelEvent) { events = append(events, e) }
+
+	a := tokipay.NewAutoCanceller(srv.NewClient(), s, time.Hour)
+	a.OnEvent = onEvent
+	if n, err := a.Sweep(ctx); err != nil || n != 0 {
+		t.Fatalf("sweep before TTL = %d, %v; want 0, nil", n, err)
+	}
+
+	// a new canceller, as after a restart, picks the requests up from the store
+	time.Sleep(5 * time.Millisecond)
+	a = tokipay.NewAutoCanceller(srv.NewClient(), s, time.Millisecond)
+	a.OnEvent = onEvent
+	n, err := a.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("cancelled %d, want 1", n)
+	}
+	if len(events) != 2 ||
+		events[0].RequestID != requestIDs[0] || events[0].Action != tokipay.AutoCancelSkipped || events[0].Status != tokipay.StatusApproved ||
+		events[1].RequestID != requestIDs[1] || events[1].Action != tokipay.AutoCancelCancelled {
+		t.Fatalf("events = %+v", events)
+	}
+
+	for i, want := range []string{tokipay.StatusApproved, tokipay.StatusCancelled} {
+		p, err := s.GetPayment(ctx, requestIDs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != want {
+			t.Errorf("payment %s status = %s, want %s", p.RequestID, p.Status, want)
+		}
+		if remote, _ := srv.Payment(requestIDs[i]); remote.Status != want {
+			t.Errorf("server payment %s status = %s, want %s", p.RequestID, remote.Status, want)
+		}
+	}
+
+	if n, err := a.Sweep(ctx); err != nil || n != 0 {
+		t.Errorf("second sweep = %d, %v; want 0, nil", n, err)
+	}
+}
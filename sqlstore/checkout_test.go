@@ -0,0 +1,97 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestSQLiteCheckoutDeeplink(t *testing.T) {
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	client := tokipay.NewRecordingClient(srv.NewClient(), s)
+	callbacks := httptest.NewServer(tokipay.CallbackHandler(func(callback tokipay.CallbackRequest, headers tokipay.CallbackHeaders) error {
+		return client.RecordCallback(context.Background(), callback, headers)
+	}))
+	defer callbacks.Close()
+
+	checkout := tokipay.NewCheckout(client)
+	session, err := checkout.Start(tokipay.CheckoutOrder{
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+		SuccessURL: callbacks.URL + "/success",
+		FailureURL: callbacks.URL + "/failure",
+	}, tokipay.MethodDeeplink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.CheckStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if session.Status != tokipay.StatusPending {
+		t.Errorf("new deeplink session is %s", session.Status)
+	}
+	if _, err := session.Refund(0); err == nil {
+		t.Error("refund of a pending deeplink session succeeded")
+	}
+
+	// the success callback updates the stored payment the session reads
+	remote, _ := srv.PaymentByOrder("ORDER_1")
+	if err := srv.Approve(remote.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if cb := srv.Callbacks(); len(cb) != 1 || cb[0].Err != nil || cb[0].StatusCode != 200 {
+		t.Fatalf("callbacks = %+v", cb)
+	}
+
+	// a session decoded from JSON is resumed with the checkout's store
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded tokipay.CheckoutSession
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	resumed := tokipay.NewCheckout(srv.NewClient())
+	resumed.Store = s
+	resumed.Resume(&decoded)
+	if _, err := decoded.CheckStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Status != tokipay.StatusApproved {
+		t.Errorf("deeplink session after the callback is %s", decoded.Status)
+	}
+
+	// callbacks carry no TransNumber, so the refund waits until one is
+	// stored, such as from a settlement file
+	if _, err := decoded.Refund(0); err == nil {
+		t.Error("refund without a TransNumber succeeded")
+	}
+	p, err := s.GetPayment(context.Background(), session.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, _ = srv.PaymentByOrder("ORDER_1")
+	p.TransNumber = remote.TransNumber
+	if err := s.SavePayment(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decoded.Refund(0); err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := srv.PaymentByOrder("ORDER_1"); remote.Refunded != 1000 {
+		t.Errorf("refunded %v, want 1000", remote.Refunded)
+	}
+
+	if err := decoded.Cancel(); !errors.Is(err, tokipay.ErrNoRequestID) {
+		t.Errorf("cancelling a deeplink session error = %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package sqlstore
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect holds the SQL differences between databases
+type Dialect struct {
+	Name string
+
+	// Placeholder returns the bind parameter for the nth argument, starting at 1
+	Placeholder func(n int) string
+
+	// Migrations are single statements applied in order; the index of a
+	// statement plus one is its schema version. Never edit or reorder
+	// released migrations, only append new ones.
+	Migrations []string
+
+	// LockRefunds, if set, is run with a trans number at the start of the
+	// transaction that adds a refund, to serialize the refunds of that
+	// transaction across connections. SQLite needs none as it runs one
+	// write at a time.
+	LockRefunds string
+}
+
+// SQLite works with database/sql drivers such as modernc.org/sqlite and
+// github.com/mattn/go-sqlite3
+var SQLite = Dialect{
+	Name:        "sqlite",
+	Placeholder: func(int) string { return "?" },
+	Migrations: []string{
+		`CREATE TABLE tokipay_payments (
+			payment_key     TEXT PRIMARY KEY,
+			request_id      TEXT NOT NULL DEFAULT '',
+			order_id        TEXT NOT NULL,
+			transaction_id  TEXT NOT NULL DEFAULT '',
+			trans_number    TEXT NOT NULL DEFAULT '',
+			merchant_id     TEXT NOT NULL DEFAULT '',
+			method          TEXT NOT NULL,
+			amount          REAL NOT NULL,
+			fee             REAL NOT NULL DEFAULT 0,
+			refunded_amount REAL NOT NULL DEFAULT 0,
+			status          TEXT NOT NULL,
+			callback_status TEXT NOT NULL DEFAULT '',
+			vat_type        TEXT NOT NULL DEFAULT '',
+			vat_id          TEXT NOT NULL DEFAULT '',
+			vat_registered  INTEGER NOT NULL DEFAULT 0,
+			created_at      INTEGER NOT NULL,
+			updated_at      INTEGER NOT NULL
+		)`,
+		`CREATE INDEX tokipay_payments_order_id ON tokipay_payments (order_id)`,
+		`CREATE INDEX tokipay_payments_trans_number ON tokipay_payments (trans_number)`,
+		`CREATE INDEX tokipay_payments_status ON tokipay_payments (status, created_at)`,
+		`CREATE TABLE tokipay_payment_events (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			payment_key TEXT NOT NULL,
+			type        TEXT NOT NULL,
+			status      TEXT NOT NULL DEFAULT '',
+			amount      REAL NOT NULL DEFAULT 0,
+			reference   TEXT NOT NULL DEFAULT '',
+			detail      TEXT NOT NULL DEFAULT '',
+			created_at  INTEGER NOT NULL
+		)`,
+		`CREATE INDEX tokipay_payment_events_payment_key ON tokipay_payment_events (payment_key, id)`,
+		`CREATE TABLE tokipay_refunds (
+			id                INTEGER PRIMARY KEY AUTOINCREMENT,
+			trans_number      TEXT NOT NULL,
+			payment_key       TEXT NOT NULL DEFAULT '',
+			amount            REAL NOT NULL,
+			reason            TEXT NOT NULL DEFAULT '',
+			operator_id       TEXT NOT NULL DEFAULT '',
+			status            TEXT NOT NULL,
+			txn_number        TEXT NOT NULL DEFAULT '',
+			topup_transnumber TEXT NOT NULL DEFAULT '',
+			error             TEXT NOT NULL DEFAULT '',
+			created_at        INTEGER NOT NULL,
+			updated_at        INTEGER NOT NULL
+		)`,
+		`CREATE INDEX tokipay_refunds_trans_number ON tokipay_refunds (trans_number, id)`,
+		`CREATE TABLE tokipay_vat_registrations (
+			payment_key    TEXT PRIMARY KEY,
+			order_id       TEXT NOT NULL,
+			vat_type       TEXT NOT NULL DEFAULT '',
+			vat_id         TEXT NOT NULL DEFAULT '',
+			transaction_id TEXT NOT NULL DEFAULT '',
+			ddtd           TEXT NOT NULL DEFAULT '',
+			total_amount   TEXT NOT NULL DEFAULT '',
+			vat_amount     TEXT NOT NULL DEFAULT '',
+			created_date   TEXT NOT NULL DEFAULT '',
+			merchant_name  TEXT NOT NULL DEFAULT '',
+			merchant_tin   TEXT NOT NULL DEFAULT '',
+			status         TEXT NOT NULL,
+			attempts       INTEGER NOT NULL DEFAULT 0,
+			message        TEXT NOT NULL DEFAULT '',
+			error          TEXT NOT NULL DEFAULT '',
+			created_at     INTEGER NOT NULL,
+			updated_at     INTEGER NOT NULL
+		)`,
+		`CREATE INDEX tokipay_vat_registrations_status ON tokipay_vat_registrations (status, created_at)`,
+	},
+}
+
+// Postgres works with database/sql drivers such as github.com/jackc/pgx/v5/stdlib.
+// The tests run the SQLite dialect only; the Postgres migrations and queries
+// are not exercised against a server.
+var Postgres = Dialect{
+	Name:        "postgres",
+	Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	LockRefunds: `SELECT pg_advisory_xact_lock(hashtext($1))`,
+	Migrations: []string{
+		`CREATE TABLE tokipay_payments (
+			payment_key     TEXT PRIMARY KEY,
+			request_id      TEXT NOT NULL DEFAULT '',
+			order_id        TEXT NOT NULL,
+			transaction_id  TEXT NOT NULL DEFAULT '',
+			trans_number    TEXT NOT NULL DEFAULT '',
+			merchant_id     TEXT NOT NULL DEFAULT '',
+			method          TEXT NOT NULL,
+			amount          DOUBLE PRECISION NOT NULL,
+			fee             DOUBLE PRECISION NOT NULL DEFAULT 0,
+			refunded_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+			status          TEXT NOT NULL,
+			callback_status TEXT NOT NULL DEFAULT '',
+			vat_type        TEXT NOT NULL DEFAULT '',
+			vat_id          TEXT NOT NULL DEFAULT '',
+			vat_registered  BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at      BIGINT NOT NULL,
+			updated_at      BIGINT NOT NULL
+		)`,
+		`CREATE INDEX tokipay_payments_order_id ON tokipay_payments (order_id)`,
+		`CREATE INDEX tokipay_payments_trans_number ON tokipay_payments (trans_number)`,
+		`CREATE INDEX tokipay_payments_status ON tokipay_payments (status, created_at)`,
+		`CREATE TABLE tokipay_payment_events (
+			id          BIGSERIAL PRIMARY KEY,
+			payment_key TEXT NOT NULL,
+			type        TEXT NOT NULL,
+			status      TEXT NOT NULL DEFAULT '',
+			amount      DOUBLE PRECISION NOT NULL DEFAULT 0,
+			reference   TEXT NOT NULL DEFAULT '',
+			detail      TEXT NOT NULL DEFAULT '',
+			created_at  BIGINT NOT NULL
+		)`,
+		`CREATE INDEX tokipay_payment_events_payment_key ON tokipay_payment_events (payment_key, id)`,
+		`CREATE TABLE tokipay_refunds (
+			id                BIGSERIAL PRIMARY KEY,
+			trans_number      TEXT NOT NULL,
+			payment_key       TEXT NOT NULL DEFAULT '',
+			amount            DOUBLE PRECISION NOT NULL,
+			reason            TEXT NOT NULL DEFAULT '',
+			operator_id       TEXT NOT NULL DEFAULT '',
+			status            TEXT NOT NULL,
+			txn_number        TEXT NOT NULL DEFAULT '',
+			topup_transnumber TEXT NOT NULL DEFAULT '',
+			error             TEXT NOT NULL DEFAULT '',
+			created_at        BIGINT NOT NULL,
+			updated_at        BIGINT NOT NULL
+		)`,
+		`CREATE INDEX tokipay_refunds_trans_number ON tokipay_refunds (trans_number, id)`,
+		`CREATE TABLE tokipay_vat_registrations (
+			payment_key    TEXT PRIMARY KEY,
+			order_id       TEXT NOT NULL,
+			vat_type       TEXT NOT NULL DEFAULT '',
+			vat_id         TEXT NOT NULL DEFAULT '',
+			transaction_id TEXT NOT NULL DEFAULT '',
+			ddtd           TEXT NOT NULL DEFAULT '',
+			total_amount   TEXT NOT NULL DEFAULT '',
+			vat_amount     TEXT NOT NULL DEFAULT '',
+			created_date   TEXT NOT NULL DEFAULT '',
+			merchant_name  TEXT NOT NULL DEFAULT '',
+			merchant_tin   TEXT NOT NULL DEFAULT '',
+			status         TEXT NOT NULL,
+			attempts       INTEGER NOT NULL DEFAULT 0,
+			message        TEXT NOT NULL DEFAULT '',
+			error          TEXT NOT NULL DEFAULT '',
+			created_at     BIGINT NOT NULL,
+			updated_at     BIGINT NOT NULL
+		)`,
+		`CREATE INDEX tokipay_vat_registrations_status ON tokipay_vat_registrations (status, created_at)`,
+	},
+}
+
+// rebind rewrites ? placeholders into the dialect's form
+func (d Dialect) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
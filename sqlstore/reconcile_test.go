@@ -0,0 +1,127 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestSQLiteReconcile(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	client := tokipay.NewRecordingClient(srv.NewClient(), s)
+	create := func(client tokipay.TokiPay, orderID string) string {
+		t.Helper()
+		qr, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+			SuccessURL: "https://example.com/success",
+			FailureURL: "https://example.com/failure",
+			OrderID:    orderID,
+			Amount:     1000,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return qr.RequestID
+	}
+
+	// approved by TokiPay while stored as pending, without a callback
+	changed := create(client, "ORDER_CHANGED")
+	if err := srv.Approve(changed); err != nil {
+		t.Fatal(err)
+	}
+
+	// approved with a callback for a different amount
+	mismatched := create(client, "ORDER_MISMATCHED")
+	if err := srv.Approve(mismatched); err != nil {
+		t.Fatal(err)
+	}
+	err := client.RecordCallback(ctx, tokipay.CallbackRequest{
+		OrderID:   "ORDER_MISMATCHED",
+		RequestID: mismatched,
+		Status:    tokipay.StatusSuccess,
+		Amount:    900,
+	}, tokipay.CallbackHeaders{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pending on both sides
+	create(client, "ORDER_PENDING")
+
+	// stored, but unknown to TokiPay
+	err = s.SavePayment(ctx, &tokipay.Payment{
+		RequestID: "rq-missing",
+		OrderID:   "ORDER_MISSING_REMOTE",
+		Amount:    1000,
+		Method:    tokipay.MethodQR,
+		Status:    tokipay.StatusPending,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// created on TokiPay, but never stored
+	unstored := create(srv.NewClient(), "ORDER_MISSING_LOCAL")
+
+	opts := tokipay.ReconcileOptions{
+		DryRun:     true,
+		Statuses:   []string{tokipay.StatusPending, tokipay.StatusApproved},
+		RequestIDs: []string{unstored, changed, "rq-nowhere"},
+	}
+	want := map[string][]string{
+		changed:      {tokipay.DiscrepancyStatusChanged, tokipay.DiscrepancyMissedCallback},
+		mismatched:   {tokipay.DiscrepancyAmountMismatch},
+		"rq-missing": {tokipay.DiscrepancyMissingRemote},
+		unstored:     {tokipay.DiscrepancyMissingLocal},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		opts.DryRun = dryRun
+		report, err := tokipay.Reconcile(ctx, srv.NewClient(), s, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(map[string][]string)
+		for _, d := range report.Discrepancies {
+			got[d.RequestID] = append(got[d.RequestID], d.Kind)
+		}
+		for requestID, kinds := range want {
+			if !slices.Equal(got[requestID], kinds) {
+				t.Errorf("dry run %v: discrepancies of %s = %v, want %v", dryRun, requestID, got[requestID], kinds)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("dry run %v: discrepancies = %+v", dryRun, report.Discrepancies)
+		}
+		// the stored payments and the unstored request ID; an ID known to
+		// neither side is checked but not reported
+		if report.Checked != 6 {
+			t.Errorf("dry run %v: checked %d payments, want 6", dryRun, report.Checked)
+		}
+
+		wantUpdated, wantStatus := 0, tokipay.StatusPending
+		if !dryRun {
+			// the mismatched payment gets its TransNumber
+			wantUpdated, wantStatus = 2, tokipay.StatusApproved
+		}
+		if report.Updated != wantUpdated {
+			t.Errorf("dry run %v: updated %d payments, want %d", dryRun, report.Updated, wantUpdated)
+		}
+		if p, err := s.GetPayment(ctx, changed); err != nil || p.Status != wantStatus {
+			t.Errorf("dry run %v: changed payment = %+v, %v; want %s", dryRun, p, err, wantStatus)
+		}
+		if _, err := s.GetPayment(ctx, unstored); !errors.Is(err, tokipay.ErrPaymentNotFound) {
+			t.Errorf("dry run %v: unstored payment error = %v, want it left unstored", dryRun, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+var _ tokipay.RefundStore = (*Store)(nil)
+
+// AddRefund inserts a refund into the ledger and sets its ID. The refund
+// is only inserted if it and the transaction's completed and pending
+// refunds do not exceed captured; the check is part of the insert.
+func (s *Store) AddRefund(ctx context.Context, r *tokipay.Refund, captured float64) error {
+	if r.TransNumber == "" {
+		return errors.New("refund has no trans number")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	if r.UpdatedAt.IsZero() {
+		r.UpdatedAt = r.CreatedAt
+	}
+
+	query := s.dialect.rebind(`INSERT INTO tokipay_refunds
+		(trans_number, payment_key, amount, reason, operator_id, status,
+		txn_number, topup_transnumber, error, created_at, updated_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE (` + refundedQuery + `) + ? < ? + 0.005
+		RETURNING id`)
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if s.dialect.LockRefunds != "" {
+			if _, err := tx.ExecContext(ctx, s.dialect.LockRefunds, r.TransNumber); err != nil {
+				return err
+			}
+		}
+
+		row := tx.QueryRowContext(ctx, query,
+			r.TransNumber, r.PaymentKey, r.Amount, r.Reason, r.OperatorID, r.Status,
+			r.TxnNumber, r.TopupTransnumber, r.Error, r.CreatedAt.UnixMilli(), r.UpdatedAt.UnixMilli(),
+			r.TransNumber, tokipay.RefundPending, tokipay.RefundCompleted, r.Amount, captured)
+		err := row.Scan(&r.ID)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var refunded float64
+		if err := tx.QueryRowContext(ctx, s.dialect.rebind(refundedQuery),
+			r.TransNumber, tokipay.RefundPending, tokipay.RefundCompleted).Scan(&refunded); err != nil {
+			return err
+		}
+		return &tokipay.OverRefundError{
+			TransNumber: r.TransNumber,
+			Amount:      r.Amount,
+			Remaining:   math.Round((captured-refunded)*100) / 100,
+		}
+	})
+	var over *tokipay.OverRefundError
+	if errors.As(err, &over) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add refund: %w", err)
+	}
+	return nil
+}
+
+// refundedQuery sums the completed and pending refunds of a transaction
+const refundedQuery = `SELECT COALESCE(SUM(amount), 0) FROM tokipay_refunds
+	WHERE trans_number = ? AND status IN (?, ?)`
+
+// UpdateRefund saves the status, TokiPay references and error of a refund
+func (s *Store) UpdateRefund(ctx context.Context, r *tokipay.Refund) error {
+	if r.UpdatedAt.IsZero() {
+		r.UpdatedAt = time.Now()
+	}
+
+	query := s.dialect.rebind(`UPDATE tokipay_refunds SET
+		status = ?, txn_number = ?, topup_transnumber = ?, error = ?, updated_at = ?
+		WHERE id = ?`)
+
+	res, err := s.db.ExecContext(ctx, query,
+		r.Status, r.TxnNumber, r.TopupTransnumber, r.Error, r.UpdatedAt.UnixMilli(), r.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("failed to update refund: no refund with ID %d", r.ID)
+	}
+	return nil
+}
+
+// ListRefunds returns the refunds of a transaction, oldest first
+func (s *Store) ListRefunds(ctx context.Context, transNumber string) ([]tokipay.Refund, error) {
+	query := s.dialect.rebind(`SELECT id, trans_number, payment_key, amount, reason, operator_id, status,
+		txn_number, topup_transnumber, error, created_at, updated_at
+		FROM tokipay_refunds WHERE trans_number = ? ORDER BY id`)
+
+	rows, err := s.db.QueryContext(ctx, query, transNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refunds: %w", err)
+	}
+	defer rows.Close()
+
+	var refunds []tokipay.Refund
+	for rows.Next() {
+		var r tokipay.Refund
+		var createdAt, updatedAt int64
+		err := rows.Scan(&r.ID, &r.TransNumber, &r.PaymentKey, &r.Amount, &r.Reason, &r.OperatorID, &r.Status,
+			&r.TxnNumber, &r.TopupTransnumber, &r.Error, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan refund: %w", err)
+		}
+		r.CreatedAt = time.UnixMilli(createdAt)
+		r.UpdatedAt = time.UnixMilli(updatedAt)
+		refunds = append(refunds, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list refunds: %w", err)
+	}
+	return refunds, nil
+}
@@ -0,0 +1,226 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestSQLiteRefundManager(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	qr, err := tokipay.NewRecordingClient(srv.NewClient(), s).CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := tokipay.NewRefundManager(srv.NewClient(), s, s)
+	if _, err := m.Refund(ctx, tokipay.RefundParams{TransNumber: "unknown", Amount: 100}); !errors.Is(err, tokipay.ErrPaymentNotFound) {
+		t.Fatalf("refund of unknown transaction error = %v, want ErrPaymentNotFound", err)
+	}
+
+	if err := srv.Approve(qr.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	status, err := tokipay.NewRecordingClient(srv.NewClient(), s).CheckPaymentStatus(qr.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund := func(amount float64) (*tokipay.Refund, error) {
+		return m.Refund(ctx, tokipay.RefundParams{TransNumber: status.TransNumber, Amount: amount, Reason: "damaged", OperatorID: "op-7"})
+	}
+
+	first, err := refund(400)
+	if err != nil {
+		t.Fatalf("first refund: %v", err)
+	}
+	if first.Status != tokipay.RefundCompleted || first.TxnNumber == "" || first.TopupTransnumber == "" {
+		t.Errorf("first refund = %+v", *first)
+	}
+	if _, err := refund(250.5); err != nil {
+		t.Fatalf("second refund: %v", err)
+	}
+
+	var over *tokipay.OverRefundError
+	if _, err := refund(400); !errors.As(err, &over) || over.Remaining != 349.5 {
+		t.Fatalf("over-refund error = %v, want OverRefundError with 349.5 remaining", err)
+	}
+
+	last, err := refund(0)
+	if err != nil {
+		t.Fatalf("refund of the remainder: %v", err)
+	}
+	if last.Amount != 349.5 {
+		t.Errorf("remainder refunded %v, want 349.5", last.Amount)
+	}
+
+	balance, err := m.Balance(ctx, status.TransNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Refunded != 1000 || balance.Remaining != 0 {
+		t.Errorf("balance = %+v", *balance)
+	}
+	if _, err := refund(0); !errors.As(err, &over) {
+		t.Errorf("refund of a fully refunded transaction error = %v, want OverRefundError", err)
+	}
+
+	refunds, err := s.ListRefunds(ctx, status.TransNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 3 {
+		t.Fatalf("got %d refunds, want 3", len(refunds))
+	}
+	if got := refunds[0]; got.ID != first.ID || got.PaymentKey != qr.RequestID || got.Reason != "damaged" || got.OperatorID != "op-7" || got.TxnNumber != first.TxnNumber {
+		t.Errorf("stored refund = %+v, want %+v", got, *first)
+	}
+
+	p, err := s.GetPayment(ctx, qr.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RefundedAmount != 1000 {
+		t.Errorf("RefundedAmount = %v, want 1000", p.RefundedAmount)
+	}
+}
+
+func TestSQLiteRefundManagerOutcomes(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	recording := tokipay.NewRecordingClient(srv.NewClient(), s)
+	qr, err := recording.CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Approve(qr.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	status, err := recording.CheckPaymentStatus(qr.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := tokipay.NewRefundManager(srv.NewClient(), s, s)
+	refund := func(amount float64) (*tokipay.Refund, error) {
+		return m.Refund(ctx, tokipay.RefundParams{TransNumber: status.TransNumber, Amount: amount})
+	}
+	balance := func() tokipay.RefundBalance {
+		t.Helper()
+		b, err := m.Balance(ctx, status.TransNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *b
+	}
+
+	// a declined refund fails and frees its amount
+	srv.FailNext(tokipaytest.EndpointRefund, 1, tokipaytest.Fault{StatusCode: http.StatusBadRequest, Message: "amount is invalid"})
+	declined, err := refund(300)
+	if err == nil || declined.Status != tokipay.RefundFailed {
+		t.Fatalf("declined refund = %+v, %v", declined, err)
+	}
+	if b := balance(); b.Pending != 0 || b.Remaining != 1000 {
+		t.Errorf("balance after a declined refund = %+v", b)
+	}
+
+	// unknown outcomes stay pending and keep their amount reserved
+	for _, fault := range []tokipaytest.Fault{
+		{Malformed: true},
+		tokipaytest.InternalError(),
+		{Body: `{"message":"no code"}`},
+	} {
+		srv.FailNext(tokipaytest.EndpointRefund, 1, fault)
+		unknown, err := refund(100)
+		if err == nil || unknown.Status != tokipay.RefundPending || unknown.Error == "" {
+			t.Fatalf("refund with fault %+v = %+v, %v", fault, unknown, err)
+		}
+	}
+	if b := balance(); b.Pending != 300 || b.Remaining != 700 {
+		t.Errorf("balance with unknown outcomes = %+v", b)
+	}
+	if _, err := refund(800); err == nil {
+		t.Error("refund over the pending amount succeeded")
+	}
+
+	refunds, err := s.ListRefunds(ctx, status.TransNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	made, lost := refunds[1], refunds[2]
+	resolved, err := m.Resolve(ctx, status.TransNumber, made.ID, true, "TXN-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Status != tokipay.RefundCompleted || resolved.TxnNumber != "TXN-1" {
+		t.Errorf("resolved refund = %+v", *resolved)
+	}
+	if _, err := m.Resolve(ctx, status.TransNumber, lost.ID, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Resolve(ctx, status.TransNumber, lost.ID, true, ""); err == nil {
+		t.Error("resolving a failed refund succeeded")
+	}
+	if _, err := m.Resolve(ctx, status.TransNumber, 9999, true, ""); !errors.Is(err, tokipay.ErrRefundNotFound) {
+		t.Errorf("resolving an unknown refund error = %v", err)
+	}
+
+	if b := balance(); b.Refunded != 100 || b.Pending != 100 || b.Remaining != 800 {
+		t.Errorf("balance after resolving = %+v", b)
+	}
+	p, err := s.GetPayment(ctx, qr.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RefundedAmount != 100 {
+		t.Errorf("RefundedAmount = %v, want 100", p.RefundedAmount)
+	}
+}
+
+func TestSQLiteAddRefundLimit(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+
+	// another process added a pending refund since the balance was read
+	add := func(amount float64) error {
+		return s.AddRefund(ctx, &tokipay.Refund{TransNumber: "3425279", PaymentKey: "rq-1", Amount: amount, Status: tokipay.RefundPending}, 1000)
+	}
+	if err := add(600); err != nil {
+		t.Fatal(err)
+	}
+	var over *tokipay.OverRefundError
+	if err := add(500); !errors.As(err, &over) || over.Remaining != 400 {
+		t.Fatalf("over-refund error = %v, want OverRefundError with 400 remaining", err)
+	}
+	if err := add(400); err != nil {
+		t.Fatal(err)
+	}
+
+	refunds, err := s.ListRefunds(ctx, "3425279")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 {
+		t.Errorf("got %d refunds, want 2", len(refunds))
+	}
+}
@@ -0,0 +1,90 @@
+package sqlstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+func TestSQLiteMatchSettlement(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+
+	now := time.Now()
+	for i, p := range []tokipay.Payment{
+		{TransNumber: "1001", Amount: 1000, Fee: 10},
+		{TransNumber: "1002", Amount: 2000, Fee: 20},
+		{TransNumber: "1003", Amount: 1500.5},
+		{TransNumber: "1004", Amount: 3000, Fee: 30},
+		{TransNumber: "1005", Amount: 500},
+	} {
+		p.OrderID = "ORDER_" + p.TransNumber
+		p.RequestID = "rq-" + p.TransNumber
+		p.Method = tokipay.MethodQR
+		p.Status = tokipay.StatusApproved
+		p.CreatedAt = now.Add(time.Duration(i) * time.Second)
+		if err := s.SavePayment(ctx, &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1001 matches, 1002 is within the fee tolerance, 1003 is matched
+	// against the fee rate, 1004 pays a different fee, 1005 a different
+	// amount, 9999 is not a stored payment and the totals row is skipped
+	lines, err := tokipay.ParseSettlement(strings.NewReader("" +
+		"trans number;amount;fee\n" +
+		"1001;1.000,00;10\n" +
+		"1002;2.000;20,04\n" +
+		"1003;1500,50;15,01\n" +
+		"1004;3000;45\n" +
+		"1005;550;0\n" +
+		"9999;100;1\n" +
+		";8.150,50;91,05\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := tokipay.MatchSettlement(ctx, s, lines, tokipay.SettlementOptions{FeeTolerance: 0.05, FeeRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"1001": tokipay.SettlementMatched,
+		"1002": tokipay.SettlementMatched,
+		"1003": tokipay.SettlementMatched,
+		"1004": tokipay.SettlementFeeVariance,
+		"1005": tokipay.SettlementAmountMismatch,
+		"9999": tokipay.SettlementUnmatched,
+	}
+	if len(report.Lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(report.Lines), len(want))
+	}
+	for _, m := range report.Lines {
+		if m.Result != want[m.TransNumber] {
+			t.Errorf("line %d (%s) = %s, want %s", m.Line, m.TransNumber, m.Result, want[m.TransNumber])
+		}
+	}
+
+	fee := report.Lines[2]
+	if fee.ExpectedFee != 15.01 || fee.RequestID != "rq-1003" || fee.OrderID != "ORDER_1003" {
+		t.Errorf("line matched by fee rate = %+v", fee)
+	}
+	if got := report.Lines[3].FeeVariance; got != 15 {
+		t.Errorf("fee variance = %v, want 15", got)
+	}
+	if got := report.Lines[5]; got.RequestID != "" || got.ExpectedAmount != 0 {
+		t.Errorf("unmatched line = %+v", got)
+	}
+
+	if report.Matched != 3 || report.FeeVariances != 1 || report.AmountMismatches != 1 || report.Unmatched != 1 || report.Discrepancies() != 3 {
+		t.Errorf("report counts = %d matched, %d fee, %d amount, %d unmatched",
+			report.Matched, report.FeeVariances, report.AmountMismatches, report.Unmatched)
+	}
+	if report.TotalAmount != 8150.5 || report.TotalFee != 91.05 || report.TotalFeeVariance != 10.04 {
+		t.Errorf("report totals = %v amount, %v fee, %v variance", report.TotalAmount, report.TotalFee, report.TotalFeeVariance)
+	}
+}
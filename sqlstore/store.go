@@ -0,0 +1,275 @@
+// Package sqlstore implements tokipay.PaymentStore on database/sql.
+//
+// The store does not import a driver; open the *sql.DB with the driver of
+// your choice and pass the matching Dialect. Timestamps are stored as Unix
+// milliseconds so every dialect round-trips them the same way.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+const paymentColumns = `payment_key, request_id, order_id, transaction_id, trans_number, merchant_id, method,
+	amount, fee, refunded_amount, status, callback_status, vat_type, vat_id, vat_registered,
+	created_at, updated_at`
+
+// Store is a PaymentStore backed by a SQL database
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+var _ tokipay.PaymentStore = (*Store)(nil)
+
+// New creates a store on db. Call Migrate before first use.
+func New(db *sql.DB, dialect Dialect) *Store {
+	return &Store{db: db, dialect: dialect}
+}
+
+// Migrate creates or upgrades the schema to the latest version
+func (s *Store) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS tokipay_schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current int
+	row := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM tokipay_schema_migrations`)
+	if err := row.Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := current; i < len(s.dialect.Migrations); i++ {
+		version := i + 1
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, s.dialect.Migrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO tokipay_schema_migrations (version) VALUES (?)`), version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// SavePayment inserts the payment or updates the one with the same Key. A
+// payment without a Key is keyed by its request ID or, if it has none, its
+// transaction ID.
+func (s *Store) SavePayment(ctx context.Context, p *tokipay.Payment) error {
+	if p.Key == "" {
+		p.Key = p.RequestID
+	}
+	if p.Key == "" {
+		p.Key = p.TransactionID
+	}
+	if p.Key == "" {
+		return errors.New("payment has neither a request ID nor a transaction ID")
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = p.CreatedAt
+	}
+
+	query := s.dialect.rebind(`INSERT INTO tokipay_payments (` + paymentColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (payment_key) DO UPDATE SET
+			request_id = excluded.request_id,
+			order_id = excluded.order_id,
+			transaction_id = excluded.transaction_id,
+			trans_number = excluded.trans_number,
+			merchant_id = excluded.merchant_id,
+			method = excluded.method,
+			amount = excluded.amount,
+			fee = excluded.fee,
+			refunded_amount = excluded.refunded_amount,
+			status = excluded.status,
+			callback_status = excluded.callback_status,
+			vat_type = excluded.vat_type,
+			vat_id = excluded.vat_id,
+			vat_registered = excluded.vat_registered,
+			updated_at = excluded.updated_at`)
+
+	_, err := s.db.ExecContext(ctx, query,
+		p.Key, p.RequestID, p.OrderID, p.TransactionID, p.TransNumber, p.MerchantID, p.Method,
+		p.Amount, p.Fee, p.RefundedAmount, p.Status, p.CallbackStatus, p.VATType, p.VATID, p.VATRegistered,
+		p.CreatedAt.UnixMilli(), p.UpdatedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save payment: %w", err)
+	}
+	return nil
+}
+
+// GetPayment returns the payment with the given Key
+func (s *Store) GetPayment(ctx context.Context, key string) (*tokipay.Payment, error) {
+	return s.getPayment(ctx, `payment_key = ?`, key)
+}
+
+// GetPaymentByOrder returns the latest payment created for the order
+func (s *Store) GetPaymentByOrder(ctx context.Context, orderID string) (*tokipay.Payment, error) {
+	return s.getPayment(ctx, `order_id = ?`, orderID)
+}
+
+// GetPaymentByTransNumber returns the payment settled with the transaction number
+func (s *Store) GetPaymentByTransNumber(ctx context.Context, transNumber string) (*tokipay.Payment, error) {
+	if transNumber == "" {
+		return nil, tokipay.ErrPaymentNotFound
+	}
+	return s.getPayment(ctx, `trans_number = ?`, transNumber)
+}
+
+func (s *Store) getPayment(ctx context.Context, where string, arg any) (*tokipay.Payment, error) {
+	query := s.dialect.rebind(`SELECT ` + paymentColumns + ` FROM tokipay_payments
+		WHERE ` + where + ` ORDER BY created_at DESC LIMIT 1`)
+
+	p, err := scanPayment(s.db.QueryRowContext(ctx, query, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, tokipay.ErrPaymentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	return p, nil
+}
+
+// ListPayments returns payments matching the filter, oldest first
+func (s *Store) ListPayments(ctx context.Context, filter tokipay.PaymentFilter) ([]tokipay.Payment, error) {
+	var where []string
+	var args []any
+	if len(filter.Statuses) > 0 {
+		where = append(where, `status IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(filter.Statuses)), ", ")+`)`)
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if filter.TransactionID != "" {
+		where = append(where, `transaction_id = ?`)
+		args = append(args, filter.TransactionID)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where = append(where, `created_at < ?`)
+		args = append(args, filter.CreatedBefore.UnixMilli())
+	}
+	if filter.VATPending {
+		where = append(where, `vat_type <> '' AND NOT vat_registered`)
+	}
+	if filter.After != nil {
+		createdAt := filter.After.CreatedAt.UnixMilli()
+		where = append(where, `(created_at > ? OR (created_at = ? AND payment_key > ?))`)
+		args = append(args, createdAt, createdAt, filter.After.Key)
+	}
+
+	query := `SELECT ` + paymentColumns + ` FROM tokipay_payments`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY created_at, payment_key`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []tokipay.Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		payments = append(payments, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", err)
+	}
+	return payments, nil
+}
+
+// AddEvent appends an event to a payment's history and sets its ID
+func (s *Store) AddEvent(ctx context.Context, e *tokipay.PaymentEvent) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	query := s.dialect.rebind(`INSERT INTO tokipay_payment_events
+		(payment_key, type, status, amount, reference, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`)
+
+	row := s.db.QueryRowContext(ctx, query,
+		e.PaymentKey, e.Type, e.Status, e.Amount, e.Reference, e.Detail, e.CreatedAt.UnixMilli())
+	if err := row.Scan(&e.ID); err != nil {
+		return fmt.Errorf("failed to add payment event: %w", err)
+	}
+	return nil
+}
+
+// ListEvents returns the history of the payment with the given Key, oldest first
+func (s *Store) ListEvents(ctx context.Context, key string) ([]tokipay.PaymentEvent, error) {
+	query := s.dialect.rebind(`SELECT id, payment_key, type, status, amount, reference, detail, created_at
+		FROM tokipay_payment_events WHERE payment_key = ? ORDER BY id`)
+
+	rows, err := s.db.QueryContext(ctx, query, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payment events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []tokipay.PaymentEvent
+	for rows.Next() {
+		var e tokipay.PaymentEvent
+		var createdAt int64
+		if err := rows.Scan(&e.ID, &e.PaymentKey, &e.Type, &e.Status, &e.Amount, &e.Reference, &e.Detail, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan payment event: %w", err)
+		}
+		e.CreatedAt = time.UnixMilli(createdAt)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list payment events: %w", err)
+	}
+	return events, nil
+}
+
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPayment(row scanner) (*tokipay.Payment, error) {
+	var p tokipay.Payment
+	var createdAt, updatedAt int64
+	err := row.Scan(&p.Key, &p.RequestID, &p.OrderID, &p.TransactionID, &p.TransNumber, &p.MerchantID, &p.Method,
+		&p.Amount, &p.Fee, &p.RefundedAmount, &p.Status, &p.CallbackStatus, &p.VATType, &p.VATID, &p.VATRegistered,
+		&createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.CreatedAt = time.UnixMilli(createdAt)
+	p.UpdatedAt = time.UnixMilli(updatedAt)
+	return &p, nil
+}
@@ -0,0 +1,311 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func newSQLiteStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	s := New(db, SQLite)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+func TestSQLiteMigrateIsIdempotent(t *testing.T) {
+	s := newSQLiteStore(t)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM tokipay_schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(SQLite.Migrations) {
+		t.Errorf("schema version = %d, want %d", version, len(SQLite.Migrations))
+	}
+}
+
+func TestSQLiteSaveAndGetPayment(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+
+	created := time.UnixMilli(1732060800000)
+	p := &tokipay.Payment{
+		OrderID:       "ORDER_1",
+		RequestID:     "rq-1",
+		TransactionID: "tx-1",
+		Method:        tokipay.MethodQR,
+		Amount:        1000,
+		Status:        tokipay.StatusPending,
+		CreatedAt:     created,
+	}
+	if err := s.SavePayment(ctx, p); err != nil {
+		t.Fatalf("SavePayment: %v", err)
+	}
+
+	p.Status = tokipay.StatusApproved
+	p.TransNumber = "3425279"
+	p.VATRegistered = true
+	p.UpdatedAt = created.Add(time.Minute)
+	if err := s.SavePayment(ctx, p); err != nil {
+		t.Fatalf("SavePayment update: %v", err)
+	}
+
+	lookups := map[string]func() (*tokipay.Payment, error){
+		"GetPayment":              func() (*tokipay.Payment, error) { return s.GetPayment(ctx, "rq-1") },
+		"GetPaymentByOrder":       func() (*tokipay.Payment, error) { return s.GetPaymentByOrder(ctx, "ORDER_1") },
+		"GetPaymentByTransNumber": func() (*tokipay.Payment, error) { return s.GetPaymentByTransNumber(ctx, "3425279") },
+	}
+	for name, lookup := range lookups {
+		got, err := lookup()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if *got != *p {
+			t.Errorf("%s = %+v, want %+v", name, *got, *p)
+		}
+	}
+
+	if _, err := s.GetPayment(ctx, "missing"); !errors.Is(err, tokipay.ErrPaymentNotFound) {
+		t.Errorf("GetPayment(missing) error = %v, want ErrPaymentNotFound", err)
+	}
+}
+
+func TestSQLiteListPayments(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+
+	base := time.UnixMilli(1732060800000)
+	for i, status := range []string{tokipay.StatusPending, tokipay.StatusApproved, tokipay.StatusPending, tokipay.StatusExpired} {
+		p := &tokipay.Payment{
+			OrderID:       "ORDER",
+			RequestID:     string(rune('a' + i)),
+			TransactionID: "tx-" + string(rune('a'+i)),
+			Method:        tokipay.MethodMobile,
+			Amount:        500,
+			Status:        status,
+			CreatedAt:     base.Add(time.Duration(i) * time.Minute),
+		}
+		// only b awaits VAT registration
+		switch p.RequestID {
+		case "b":
+			p.VATType, p.VATID = tokipay.VATTypeOrganization, "6106161"
+		case "c":
+			p.VATType, p.VATID, p.VATRegistered = tokipay.VATTypeOrganization, "6106161", true
+		}
+		if err := s.SavePayment(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter tokipay.PaymentFilter
+		want   []string
+	}{
+		{"all", tokipay.PaymentFilter{}, []string{"a", "b", "c", "d"}},
+		{"statuses", tokipay.PaymentFilter{Statuses: []string{tokipay.StatusPending, tokipay.StatusExpired}}, []string{"a", "c", "d"}},
+		{"created before", tokipay.PaymentFilter{CreatedBefore: base.Add(2 * time.Minute)}, []string{"a", "b"}},
+		{"transaction", tokipay.PaymentFilter{TransactionID: "tx-c"}, []string{"c"}},
+		{"limit", tokipay.PaymentFilter{Statuses: []string{tokipay.StatusPending}, Limit: 1}, []string{"a"}},
+		{"VAT pending", tokipay.PaymentFilter{VATPending: true}, []string{"b"}},
+		{"after", tokipay.PaymentFilter{After: &tokipay.Payment{Key: "b", CreatedAt: base.Add(time.Minute)}, Limit: 1}, []string{"c"}},
+		{"after same time", tokipay.PaymentFilter{After: &tokipay.Payment{Key: "a0", CreatedAt: base}}, []string{"b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payments, err := s.ListPayments(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range payments {
+				got = append(got, p.RequestID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteRecordingClient(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	client := tokipay.NewRecordingClient(srv.NewClient(), s)
+
+	qr, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ApproveWithVAT(qr.RequestID, tokipay.VATDetails{VATType: tokipay.VATTypeOrganization, VATID: "6106161"}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := client.CheckPaymentStatus(qr.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RecordCallback(ctx, tokipay.CallbackRequest{RequestID: qr.RequestID, Status: tokipay.StatusSuccess, Amount: 1000}, tokipay.CallbackHeaders{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RefundPayment(tokipay.RefundRequest{TransNumber: status.TransNumber, Amount: "400"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RegisterVAT(tokipay.VATRegistrationRequest{TransactionID: status.TransNumber, DDTD: "19910000004"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.GetPaymentByOrder(ctx, "ORDER_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != tokipay.StatusApproved || p.TransNumber != status.TransNumber || p.RefundedAmount != 400 ||
+		p.CallbackStatus != tokipay.StatusSuccess || p.VATID != "6106161" || !p.VATRegistered {
+		t.Errorf("unexpected payment %+v", *p)
+	}
+
+	events, err := s.ListEvents(ctx, qr.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []string{tokipay.EventCreated, tokipay.EventStatusChecked, tokipay.EventCallback, tokipay.EventRefunded, tokipay.EventVATRegistered}
+	if len(events) != len(wantTypes) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(wantTypes), events)
+	}
+	for i, e := range events {
+		if e.Type != wantTypes[i] {
+			t.Errorf("event %d type = %s, want %s", i, e.Type, wantTypes[i])
+		}
+	}
+	if events[3].Amount != 400 || events[3].Reference == "" {
+		t.Errorf("refund event = %+v", events[3])
+	}
+}
+
+func TestSQLiteRecordingClientDeeplink(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	client := tokipay.NewRecordingClient(srv.NewClient(), s)
+	deeplink, err := client.CreateDeeplinkPayment(tokipay.DeeplinkPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_1",
+		Amount:     1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stored without a request ID and found by its transaction ID
+	p, err := s.GetPayment(ctx, deeplink.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RequestID != "" || p.TransactionID != deeplink.TransactionID || p.Key != deeplink.TransactionID {
+		t.Errorf("stored deeplink payment = %+v", *p)
+	}
+
+	// the callback carries TokiPay's request ID, which the payment takes
+	// while keeping its key
+	remote, _ := srv.PaymentByOrder("ORDER_1")
+	callback := tokipay.CallbackRequest{OrderID: "ORDER_1", RequestID: remote.RequestID, Status: tokipay.StatusSuccess, Amount: 1000}
+	if err := client.RecordCallback(ctx, callback, tokipay.CallbackHeaders{}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = s.GetPayment(ctx, deeplink.TransactionID); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != tokipay.StatusApproved || p.RequestID != remote.RequestID || p.Key != deeplink.TransactionID {
+		t.Errorf("deeplink payment after its callback = %+v", *p)
+	}
+	events, err := s.ListEvents(ctx, deeplink.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Type != tokipay.EventCallback || events[1].PaymentKey != deeplink.TransactionID {
+		t.Errorf("events = %+v", events)
+	}
+
+	// saving it again updates the same row
+	if _, err := client.CheckPaymentStatus(remote.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	payments, err := s.ListPayments(ctx, tokipay.PaymentFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || payments[0].Key != deeplink.TransactionID {
+		t.Errorf("payments after a status check = %+v", payments)
+	}
+
+	// with its request ID the payment can be reconciled
+	report, err := tokipay.Reconcile(ctx, srv.NewClient(), s, tokipay.ReconcileOptions{Statuses: []string{tokipay.StatusApproved}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 {
+		t.Errorf("reconciliation of a deeplink payment = %+v", *report)
+	}
+
+	// a callback for an order whose payment has a request ID is not matched by order
+	if _, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    "ORDER_2",
+		Amount:     1000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	callback.OrderID = "ORDER_2"
+	if err := client.RecordCallback(ctx, callback, tokipay.CallbackHeaders{}); !errors.Is(err, tokipay.ErrPaymentNotFound) {
+		t.Errorf("callback with an unknown request ID error = %v", err)
+	}
+}
+
+func TestPostgresDialect(t *testing.T) {
+	// the Postgres schema is not run by these tests; check it matches SQLite's
+	if len(Postgres.Migrations) != len(SQLite.Migrations) {
+		t.Errorf("Postgres has %d migrations, SQLite %d", len(Postgres.Migrations), len(SQLite.Migrations))
+	}
+	got := Postgres.rebind(`SELECT a FROM t WHERE b = ? AND c IN (?, ?)`)
+	if want := `SELECT a FROM t WHERE b = $1 AND c IN ($2, $3)`; got != want {
+		t.Errorf("rebind = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+const vatRegistrationColumns = `payment_key, order_id, vat_type, vat_id, transaction_id, ddtd,
+	total_amount, vat_amount, created_date, merchant_name, merchant_tin,
+	status, attempts, message, error, created_at, updated_at`
+
+var _ tokipay.VATRegistrationStore = (*Store)(nil)
+
+// SaveVATRegistration inserts the registration or updates the one with the same PaymentKey
+func (s *Store) SaveVATRegistration(ctx context.Context, r *tokipay.VATRegistration) error {
+	if r.PaymentKey == "" {
+		return errors.New("VAT registration has no payment key")
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	if r.UpdatedAt.IsZero() {
+		r.UpdatedAt = r.CreatedAt
+	}
+
+	query := s.dialect.rebind(`INSERT INTO tokipay_vat_registrations (` + vatRegistrationColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (payment_key) DO UPDATE SET
+			order_id = excluded.order_id,
+			vat_type = excluded.vat_type,
+			vat_id = excluded.vat_id,
+			transaction_id = excluded.transaction_id,
+			ddtd = excluded.ddtd,
+			total_amount = excluded.total_amount,
+			vat_amount = excluded.vat_amount,
+			created_date = excluded.created_date,
+			merchant_name = excluded.merchant_name,
+			merchant_tin = excluded.merchant_tin,
+			status = excluded.status,
+			attempts = excluded.attempts,
+			message = excluded.message,
+			error = excluded.error,
+			updated_at = excluded.updated_at`)
+
+	req := r.Request
+	_, err := s.db.ExecContext(ctx, query,
+		r.PaymentKey, r.OrderID, r.VATType, r.VATID, req.TransactionID, req.DDTD,
+		req.TotalAmount, req.VATAmount, req.CreatedDate.String(), req.MerchantName, req.MerchantTIN,
+		r.Status, r.Attempts, r.Message, r.Error, r.CreatedAt.UnixMilli(), r.UpdatedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save VAT registration: %w", err)
+	}
+	return nil
+}
+
+// GetVATRegistration returns the registration of the payment with the given Key
+func (s *Store) GetVATRegistration(ctx context.Context, key string) (*tokipay.VATRegistration, error) {
+	query := s.dialect.rebind(`SELECT ` + vatRegistrationColumns + ` FROM tokipay_vat_registrations
+		WHERE payment_key = ?`)
+
+	r, err := scanVATRegistration(s.db.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, tokipay.ErrVATRegistrationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VAT registration: %w", err)
+	}
+	return r, nil
+}
+
+// ListVATRegistrations returns registrations with the status, or all
+// registrations if status is empty, oldest first
+func (s *Store) ListVATRegistrations(ctx context.Context, status string) ([]tokipay.VATRegistration, error) {
+	query := `SELECT ` + vatRegistrationColumns + ` FROM tokipay_vat_registrations`
+	var args []any
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at, payment_key`
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VAT registrations: %w", err)
+	}
+	defer rows.Close()
+
+	var registrations []tokipay.VATRegistration
+	for rows.Next() {
+		r, err := scanVATRegistration(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan VAT registration: %w", err)
+		}
+		registrations = append(registrations, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list VAT registrations: %w", err)
+	}
+	return registrations, nil
+}
+
+func scanVATRegistration(row scanner) (*tokipay.VATRegistration, error) {
+	var r tokipay.VATRegistration
+	var createdDate string
+	var createdAt, updatedAt int64
+	req := &r.Request
+	err := row.Scan(&r.PaymentKey, &r.OrderID, &r.VATType, &r.VATID, &req.TransactionID, &req.DDTD,
+		&req.TotalAmount, &req.VATAmount, &createdDate, &req.MerchantName, &req.MerchantTIN,
+		&r.Status, &r.Attempts, &r.Message, &r.Error, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if req.CreatedDate, err = tokipay.ParseDate(createdDate); err != nil {
+		return nil, err
+	}
+	r.CreatedAt = time.UnixMilli(createdAt)
+	r.UpdatedAt = time.UnixMilli(updatedAt)
+	return &r, nil
+}
@@ -0,0 +1,206 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestSQLiteVATWorkflow(t *testing.T) {
+	ctx := context.Background()
+	s := newSQLiteStore(t)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	client := tokipay.NewRecordingClient(srv.NewClient(), s)
+	vat := tokipay.VATDetails{VATType: tokipay.VATTypeOrganization, VATID: "6106161"}
+	create := func(orderID string) string {
+		t.Helper()
+		qr, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+			SuccessURL: "https://example.com/success",
+			FailureURL: "https://example.com/failure",
+			OrderID:    orderID,
+			Amount:     1110,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return qr.RequestID
+	}
+
+	// approved and status checked
+	checked := create("ORDER_1")
+	if err := srv.ApproveWithVAT(checked, vat); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CheckPaymentStatus(checked); err != nil {
+		t.Fatal(err)
+	}
+
+	// approved by callback only, without a trans number
+	called := create("ORDER_2")
+	if err := srv.ApproveWithVAT(called, vat); err != nil {
+		t.Fatal(err)
+	}
+	err := client.RecordCallback(ctx, tokipay.CallbackRequest{RequestID: called, Status: tokipay.StatusSuccess, Amount: 1110},
+		tokipay.CallbackHeaders{VATID: vat.VATID, VATType: vat.VATType})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// approved for a citizen
+	citizen := create("ORDER_3")
+	if err := srv.Approve(citizen); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CheckPaymentStatus(citizen); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := create("ORDER_4")
+	waiting := create("ORDER_5")
+	for _, requestID := range []string{invalid, waiting} {
+		if err := srv.ApproveWithVAT(requestID, vat); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.CheckPaymentStatus(requestID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ddtd := map[string]string{"ORDER_4": "1991-0000004"}
+	receipt := func(ctx context.Context, p *tokipay.Payment) (tokipay.VATReceipt, error) {
+		if p.OrderID == "ORDER_5" {
+			return tokipay.VATReceipt{}, tokipay.ErrReceiptNotReady
+		}
+		number, ok := ddtd[p.OrderID]
+		if !ok {
+			number = "19910000004"
+		}
+		return tokipay.VATReceipt{DDTD: number, MerchantName: "Test Merchant", MerchantTIN: "1234567"}, nil
+	}
+
+	// the first two registration requests cannot connect, and the response
+	// to the fourth is lost after TokiPay registered it
+	vatClient := srv.NewClient()
+	vatRequests := 0
+	vatClient.HTTPClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != tokipay.VATEndpoint {
+			return http.DefaultTransport.RoundTrip(req)
+		}
+		switch vatRequests++; vatRequests {
+		case 1, 2:
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		case 4:
+			if resp, err := http.DefaultTransport.RoundTrip(req); err == nil {
+				resp.Body.Close()
+			}
+			return nil, errors.New("connection reset by peer")
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	w := tokipay.NewVATWorkflow(vatClient, s, s, receipt)
+	w.CityTax = true
+	w.RetryDelay = time.Millisecond
+	w.BatchSize = 2 // the four payments with VAT pending span two pages
+	var events []tokipay.VATWorkflowEvent
+	w.OnEvent = func(e tokipay.VATWorkflowEvent) { events = append(events, e) }
+
+	// the first registration succeeds on its third attempt; the second may
+	// have been made, so it is not sent again
+	registered, err := w.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered != 1 {
+		t.Errorf("registered %d payments, want 1", registered)
+	}
+
+	want := map[string]struct {
+		status   string
+		attempts int
+	}{
+		"ORDER_1": {tokipay.VATRegistrationRegistered, 3},
+		"ORDER_2": {tokipay.VATRegistrationFailed, 1},
+		"ORDER_4": {tokipay.VATRegistrationFailed, 1},
+		"ORDER_5": {tokipay.VATRegistrationWaiting, 0},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for _, e := range events {
+		if w := want[e.OrderID]; e.Status != w.status || e.Attempts != w.attempts {
+			t.Errorf("%s event = %s after %d attempts, want %s after %d", e.OrderID, e.Status, e.Attempts, w.status, w.attempts)
+		}
+	}
+
+	r, err := s.GetVATRegistration(ctx, checked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.GetPayment(ctx, checked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Request.TransactionID != p.TransNumber || r.Request.TotalAmount != "1110" || r.Request.VATAmount != "100" ||
+		r.Request.CreatedDate != tokipay.NewDate(p.CreatedAt) || r.Message == "" || !p.VATRegistered {
+		t.Errorf("registration = %+v, payment = %+v", *r, *p)
+	}
+	if p, _ := s.GetPayment(ctx, called); p.TransNumber == "" || p.VATRegistered {
+		t.Errorf("payment approved by callback = %+v", *p)
+	}
+	if _, err := s.GetVATRegistration(ctx, waiting); !errors.Is(err, tokipay.ErrVATRegistrationNotFound) {
+		t.Errorf("registration of a payment without receipt error = %v, want ErrVATRegistrationNotFound", err)
+	}
+
+	// failed registrations are left for resubmission
+	events = nil
+	if registered, err := w.Sweep(ctx); err != nil || registered != 0 {
+		t.Fatalf("second sweep registered %d: %v", registered, err)
+	}
+	if len(events) != 1 || events[0].OrderID != "ORDER_5" {
+		t.Errorf("second sweep events = %+v, want ORDER_5 waiting", events)
+	}
+
+	failed, err := w.Failed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 || failed[0].PaymentKey != called || failed[1].PaymentKey != invalid || failed[1].Error == "" {
+		t.Fatalf("failed registrations = %+v", failed)
+	}
+
+	delete(ddtd, "ORDER_4")
+	r, err = w.Resubmit(ctx, invalid)
+	if err != nil {
+		t.Fatalf("Resubmit: %v", err)
+	}
+	if r.Status != tokipay.VATRegistrationRegistered || r.Attempts != 2 || r.Error != "" {
+		t.Errorf("resubmitted registration = %+v", *r)
+	}
+	if _, err := w.Resubmit(ctx, invalid); err == nil {
+		t.Error("resubmitting a registered payment succeeded")
+	}
+	if failed, _ := w.Failed(ctx); len(failed) != 1 {
+		t.Errorf("failed registrations after resubmission = %+v", failed)
+	}
+
+	for _, requestID := range []string{checked, called, invalid} {
+		if sp, _ := srv.Payment(requestID); len(sp.VAT) != 1 {
+			t.Errorf("payment %s registered %d times with TokiPay", requestID, len(sp.VAT))
+		}
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
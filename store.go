@@ -0,0 +1,112 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrPaymentNotFound is returned by a PaymentStore when no payment matches
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// Payment methods recorded on stored payments
+const (
+	MethodQR       = "QR"
+	MethodMobile   = "MOBILE"
+	MethodDeeplink = "DEEPLINK"
+)
+
+// Payment event types recorded in a PaymentStore
+const (
+	EventCreated       = "CREATED"
+	EventStatusChecked = "STATUS_CHECKED"
+	EventCallback      = "CALLBACK"
+	EventCancelled     = "CANCELLED"
+	EventRefunded      = "REFUNDED"
+	EventVATRegistered = "VAT_REGISTERED"
+)
+
+// Payment is the local record of a payment request
+type Payment struct {
+	// Key identifies the payment in a PaymentStore and never changes. The
+	// store sets it when the payment is first saved: the request ID or, for
+	// a deeplink payment, which has none, the transaction ID.
+	Key string `json:"key"`
+
+	OrderID        string    `json:"orderId"`
+	RequestID      string    `json:"requestId"`
+	TransactionID  string    `json:"transactionId,omitempty"`
+	TransNumber    string    `json:"transNumber,omitempty"`
+	MerchantID     string    `json:"merchantId,omitempty"`
+	Method         string    `json:"method"`
+	Amount         float64   `json:"amount"`
+	Fee            float64   `json:"fee,omitempty"`
+	RefundedAmount float64   `json:"refundedAmount,omitempty"`
+	Status         string    `json:"status"`
+	CallbackStatus string    `json:"callbackStatus,omitempty"` // SUCCESS or FAILURE once a callback arrived
+	VATType        string    `json:"vatType,omitempty"`
+	VATID          string    `json:"vatId,omitempty"`
+	VATRegistered  bool      `json:"vatRegistered,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// PaymentEvent is an entry in the history of a payment
+type PaymentEvent struct {
+	ID         int64     `json:"id,omitempty"`
+	PaymentKey string    `json:"paymentKey"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status,omitempty"`
+	Amount     float64   `json:"amount,omitempty"`
+	Reference  string    `json:"reference,omitempty"` // refund TxnNumber, DDTD, ...
+	Detail     string    `json:"detail,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// PaymentFilter selects payments in ListPayments. Zero fields match everything.
+type PaymentFilter struct {
+	Statuses      []string
+	TransactionID string
+	CreatedBefore time.Time
+
+	// VATPending selects payments with a VAT type whose VAT is not
+	// registered yet
+	VATPending bool
+
+	// After continues a listing after this payment, such as the last one of
+	// the previous page. Payments are ordered by CreatedAt, then Key.
+	After *Payment
+
+	Limit int
+}
+
+// PaymentStore persists payments and their history
+type PaymentStore interface {
+	// SavePayment inserts the payment or updates the one with the same Key.
+	// A payment saved without a Key is given one.
+	SavePayment(ctx context.Context, p *Payment) error
+
+	// GetPayment returns the payment with the given Key
+	GetPayment(ctx context.Context, key string) (*Payment, error)
+
+	// GetPaymentByOrder returns the latest payment created for the order
+	GetPaymentByOrder(ctx context.Context, orderID string) (*Payment, error)
+
+	// GetPaymentByTransNumber returns the payment settled with the transaction number
+	GetPaymentByTransNumber(ctx context.Context, transNumber string) (*Payment, error)
+
+	// ListPayments returns payments matching the filter, oldest first
+	ListPayments(ctx context.Context, filter PaymentFilter) ([]Payment, error)
+
+	// AddEvent appends an event to a payment's history
+	AddEvent(ctx context.Context, e *PaymentEvent) error
+
+	// ListEvents returns the history of the payment with the given Key,
+	// oldest first
+	ListEvents(ctx context.Context, key string) ([]PaymentEvent, error)
+}
+
+// IsFinalStatus reports whether a payment status can no longer change
+func IsFinalStatus(status string) bool {
+	return status == StatusApproved || status == StatusExpired || status == StatusCancelled
+}
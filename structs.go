@@ -1,14 +1,25 @@
 package tokipay
 
+import "fmt"
+
 // Standard TokiPay API Response Structure
 type TokiPayResponse[T any] struct {
 	Code      int       `json:"code"`
 	Status    string    `json:"status"`
-	Timestamp int64     `json:"timestamp"`
+	Timestamp Timestamp `json:"timestamp"`
 	Data      T         `json:"data"`
 	Error     *APIError `json:"error"`
 }
 
+// ErrorMessage returns the API error message, falling back to the response
+// code when TokiPay omits the error object
+func (r *TokiPayResponse[T]) ErrorMessage() string {
+	if r.Error != nil && r.Error.Message != "" {
+		return r.Error.Message
+	}
+	return fmt.Sprintf("unexpected response code %d", r.Code)
+}
+
 type APIError struct {
 	Message string `json:"message"`
 }
@@ -31,6 +42,9 @@ type QRPaymentRequest struct {
 type QRPaymentResponse struct {
 	RequestID     string `json:"requestId"`
 	TransactionID string `json:"transactionId"`
+
+	// Timestamp is the timestamp of the response envelope
+	Timestamp Timestamp `json:"-"`
 }
 
 // Mobile Payment Request/Response
@@ -52,6 +66,9 @@ type MobilePaymentRequest struct {
 
 type MobilePaymentResponse struct {
 	RequestID string `json:"requestId"`
+
+	// Timestamp is the timestamp of the response envelope
+	Timestamp Timestamp `json:"-"`
 }
 
 // Deeplink Payment Request/Response
@@ -68,6 +85,9 @@ type DeeplinkPaymentRequest struct {
 type DeeplinkPaymentResponse struct {
 	Deeplink      string `json:"deeplink"`
 	TransactionID string `json:"transactionId"`
+
+	// Timestamp is the timestamp of the response envelope
+	Timestamp Timestamp `json:"-"`
 }
 
 // Payment Status Response
@@ -76,6 +96,9 @@ type PaymentStatusResponse struct {
 	TransNumber string      `json:"transNumber,omitempty"`
 	Fee         float64     `json:"fee,omitempty"`
 	VATDetails  *VATDetails `json:"vatDetails,omitempty"`
+
+	// Timestamp is the timestamp of the response envelope
+	Timestamp Timestamp `json:"-"`
 }
 
 type VATDetails struct {
@@ -95,6 +118,9 @@ type RefundResponse struct {
 	Response         string `json:"response"`
 	TxnNumber        string `json:"txnNumber"`
 	TopupTransnumber string `json:"topupTransnumber"`
+
+	// Timestamp is the timestamp of the response envelope
+	Timestamp Timestamp `json:"-"`
 }
 
 // VAT Registration Request/Response
@@ -103,7 +129,7 @@ type VATRegistrationRequest struct {
 	DDTD          string `json:"DDTD" binding:"required"`
 	TotalAmount   string `json:"totalAmount,omitempty"`
 	VATAmount     string `json:"vatAmount,omitempty"`
-	CreatedDate   string `json:"createdDate,omitempty"`
+	CreatedDate   Date   `json:"createdDate,omitzero"`
 	MerchantName  string `json:"merchantName,omitempty"`
 	MerchantTIN   string `json:"merchantTin,omitempty"`
 }
@@ -111,6 +137,9 @@ type VATRegistrationRequest struct {
 type VATRegistrationResponse struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
+
+	// Timestamp is the timestamp of the response envelope
+	Timestamp Timestamp `json:"-"`
 }
 
 // Callback Request from TokiPay
@@ -124,8 +153,12 @@ type CallbackRequest struct {
 
 // Callback Headers for organization transactions
 type CallbackHeaders struct {
-	VATID   string `header:"VAT_ID"`
-	VATType string `header:"VAT_TYPE"`
+	VATID   string `header:"VAT_ID" json:"VAT_ID,omitempty"`
+	VATType string `header:"VAT_TYPE" json:"VAT_TYPE,omitempty"`
+
+	// VATError is set by ParseCallback when the VAT headers fail Validate.
+	// The callback itself is still valid.
+	VATError error `header:"-" json:"-"`
 }
 
 // Generic Response Types
@@ -0,0 +1,140 @@
+package tokipay
+
+import (
+	"errors"
+	"time"
+
+	"github.com/techpartners-asia/tokipay-third-party-service-go/internal/tokitime"
+)
+
+// Mongolian tax rates
+const (
+	VATRate            = 0.10
+	DefaultCityTaxRate = 0.01
+)
+
+// VATDateLayout is the layout of a Date, such as VATRegistrationRequest.CreatedDate
+const VATDateLayout = tokitime.DateLayout
+
+// TaxBreakdown splits an amount into its net price and taxes.
+// Gross is always Net + VAT + CityTax.
+type TaxBreakdown struct {
+	Net     float64 `json:"net"`
+	VAT     float64 `json:"vat"`
+	CityTax float64 `json:"cityTax"`
+	Gross   float64 `json:"gross"`
+}
+
+func (b *TaxBreakdown) add(o TaxBreakdown) {
+	b.Net = roundAmount(b.Net + o.Net)
+	b.VAT = roundAmount(b.VAT + o.VAT)
+	b.CityTax = roundAmount(b.CityTax + o.CityTax)
+	b.Gross = roundAmount(b.Gross + o.Gross)
+}
+
+// TaxCalculator derives VAT and city tax. Both taxes are charged on the
+// net price and rounded to the möngö, halves away from zero.
+type TaxCalculator struct {
+	VATRate     float64
+	CityTaxRate float64
+}
+
+// DefaultTaxCalculator applies 10% VAT and 1% city tax
+var DefaultTaxCalculator = TaxCalculator{VATRate: VATRate, CityTaxRate: DefaultCityTaxRate}
+
+// FromGross splits a gross amount that includes the selected taxes
+func (c TaxCalculator) FromGross(gross float64, vat, cityTax bool) TaxBreakdown {
+	vatRate, cityRate := c.rates(vat, cityTax)
+	net := gross / (1 + vatRate + cityRate)
+
+	b := TaxBreakdown{
+		VAT:     roundAmount(net * vatRate),
+		CityTax: roundAmount(net * cityRate),
+		Gross:   roundAmount(gross),
+	}
+	// derive net from the rounded parts so the breakdown adds up exactly
+	b.Net = roundAmount(b.Gross - b.VAT - b.CityTax)
+	return b
+}
+
+// FromNet adds the selected taxes to a net amount
+func (c TaxCalculator) FromNet(net float64, vat, cityTax bool) TaxBreakdown {
+	vatRate, cityRate := c.rates(vat, cityTax)
+
+	b := TaxBreakdown{
+		Net:     roundAmount(net),
+		VAT:     roundAmount(net * vatRate),
+		CityTax: roundAmount(net * cityRate),
+	}
+	b.Gross = roundAmount(b.Net + b.VAT + b.CityTax)
+	return b
+}
+
+// Items sums the breakdowns of line items, whose unit prices are gross.
+// Each line is rounded on its own, as on the e-barimt receipt.
+func (c TaxCalculator) Items(items LineItems) TaxBreakdown {
+	var total TaxBreakdown
+	for _, i := range items {
+		total.add(c.FromGross(i.Total(), i.VAT, i.CityTax))
+	}
+	return total
+}
+
+func (c TaxCalculator) rates(vat, cityTax bool) (vatRate, cityRate float64) {
+	if vat {
+		vatRate = c.VATRate
+	}
+	if cityTax {
+		cityRate = c.CityTaxRate
+	}
+	return vatRate, cityRate
+}
+
+// VATReceipt holds the e-barimt receipt details of a VAT registration
+type VATReceipt struct {
+	// TransactionID of the payment, the TransNumber TokiPay settled it with
+	TransactionID string
+	DDTD          string
+	Date          time.Time
+	MerchantName  string
+	MerchantTIN   string
+}
+
+// Registration builds a VATRegistrationRequest for the receipt with the
+// breakdown's gross and VAT amounts
+func (c TaxCalculator) Registration(receipt VATReceipt, b TaxBreakdown) VATRegistrationRequest {
+	return VATRegistrationRequest{
+		TransactionID: receipt.TransactionID,
+		DDTD:          receipt.DDTD,
+		TotalAmount:   formatAmount(b.Gross),
+		VATAmount:     formatAmount(b.VAT),
+		CreatedDate:   NewDate(receipt.Date),
+		MerchantName:  receipt.MerchantName,
+		MerchantTIN:   receipt.MerchantTIN,
+	}
+}
+
+// RegistrationFromItems builds a VATRegistrationRequest from line items.
+// The lines must sum to amount, the gross amount that was paid.
+func (c TaxCalculator) RegistrationFromItems(receipt VATReceipt, items LineItems, amount float64) (VATRegistrationRequest, error) {
+	if err := items.Validate(amount); err != nil {
+		return VATRegistrationRequest{}, err
+	}
+	return c.Registration(receipt, c.Items(items)), nil
+}
+
+// RegistrationFromPayment builds a VATRegistrationRequest for a stored
+// payment whose whole amount is subject to VAT. The receipt's transaction ID
+// and date default to the payment's TransNumber and creation time.
+func (c TaxCalculator) RegistrationFromPayment(receipt VATReceipt, p *Payment, cityTax bool) (VATRegistrationRequest, error) {
+	if receipt.TransactionID == "" {
+		receipt.TransactionID = p.TransNumber
+	}
+	if receipt.TransactionID == "" {
+		return VATRegistrationRequest{}, errors.New("payment has no trans number")
+	}
+	if receipt.Date.IsZero() {
+		receipt.Date = p.CreatedAt
+	}
+	return c.Registration(receipt, c.FromGross(p.Amount, true, cityTax)), nil
+}
@@ -0,0 +1,151 @@
+package tokipay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTaxCalculatorFromGross(t *testing.T) {
+	tests := []struct {
+		name    string
+		gross   float64
+		vat     bool
+		cityTax bool
+		want    TaxBreakdown
+	}{
+		{"VAT and city tax", 1000, true, true, TaxBreakdown{Net: 900.90, VAT: 90.09, CityTax: 9.01, Gross: 1000}},
+		{"VAT only", 1000, true, false, TaxBreakdown{Net: 909.09, VAT: 90.91, Gross: 1000}},
+		{"city tax only", 1000, false, true, TaxBreakdown{Net: 990.10, CityTax: 9.90, Gross: 1000}},
+		{"exempt", 1000, false, false, TaxBreakdown{Net: 1000, Gross: 1000}},
+		{"zero", 0, true, true, TaxBreakdown{}},
+		{"smallest amount", 0.01, true, true, TaxBreakdown{Net: 0.01, Gross: 0.01}},
+		{"one tugrik", 1, true, true, TaxBreakdown{Net: 0.90, VAT: 0.09, CityTax: 0.01, Gross: 1}},
+		{"exact net", 111, true, true, TaxBreakdown{Net: 100, VAT: 10, CityTax: 1, Gross: 111}},
+		{"fractional gross", 1234.56, true, true, TaxBreakdown{Net: 1112.22, VAT: 111.22, CityTax: 11.12, Gross: 1234.56}},
+		{"negative for credit notes", -1000, true, true, TaxBreakdown{Net: -900.90, VAT: -90.09, CityTax: -9.01, Gross: -1000}},
+		{"large amount", 123456789, true, true, TaxBreakdown{Net: 111222332.44, VAT: 11122233.24, CityTax: 1112223.32, Gross: 123456789}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultTaxCalculator.FromGross(tt.gross, tt.vat, tt.cityTax)
+			if got != tt.want {
+				t.Errorf("FromGross(%v) = %+v, want %+v", tt.gross, got, tt.want)
+			}
+			if sum := roundAmount(got.Net + got.VAT + got.CityTax); sum != got.Gross {
+				t.Errorf("parts sum to %v, gross is %v", sum, got.Gross)
+			}
+		})
+	}
+}
+
+func TestTaxCalculatorFromNet(t *testing.T) {
+	tests := []struct {
+		name    string
+		calc    TaxCalculator
+		net     float64
+		vat     bool
+		cityTax bool
+		want    TaxBreakdown
+	}{
+		{"VAT and city tax", DefaultTaxCalculator, 100, true, true, TaxBreakdown{Net: 100, VAT: 10, CityTax: 1, Gross: 111}},
+		{"VAT only", DefaultTaxCalculator, 100, true, false, TaxBreakdown{Net: 100, VAT: 10, Gross: 110}},
+		{"half möngö rounds up", DefaultTaxCalculator, 0.05, true, false, TaxBreakdown{Net: 0.05, VAT: 0.01, Gross: 0.06}},
+		{"binary half rounds up", DefaultTaxCalculator, 10.05, true, false, TaxBreakdown{Net: 10.05, VAT: 1.01, Gross: 11.06}},
+		{"net is rounded first", DefaultTaxCalculator, 900.899, true, true, TaxBreakdown{Net: 900.90, VAT: 90.09, CityTax: 9.01, Gross: 1000}},
+		{"2% city tax", TaxCalculator{VATRate: VATRate, CityTaxRate: 0.02}, 100, true, true, TaxBreakdown{Net: 100, VAT: 10, CityTax: 2, Gross: 112}},
+		{"zero rates", TaxCalculator{}, 100, true, true, TaxBreakdown{Net: 100, Gross: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.calc.FromNet(tt.net, tt.vat, tt.cityTax); got != tt.want {
+				t.Errorf("FromNet(%v) = %+v, want %+v", tt.net, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaxCalculatorRegistration(t *testing.T) {
+	receipt := VATReceipt{
+		TransactionID: "3425279",
+		DDTD:          "19910000004",
+		Date:          time.Date(2024, 11, 20, 15, 4, 5, 0, time.UTC),
+		MerchantName:  "Test Merchant",
+		MerchantTIN:   "1234567",
+	}
+
+	tests := []struct {
+		name      string
+		build     func() (VATRegistrationRequest, error)
+		wantTotal string
+		wantVAT   string
+		wantErr   bool
+	}{
+		{
+			name: "items rounded per line",
+			build: func() (VATRegistrationRequest, error) {
+				return DefaultTaxCalculator.RegistrationFromItems(receipt, LineItems{
+					{Name: "Coffee", Quantity: 2, UnitPrice: 1500, VAT: true, CityTax: true},
+					{Name: "Cookie", Quantity: 1, UnitPrice: 500, VAT: true},
+					{Name: "Bag", Quantity: 1, UnitPrice: 100},
+				}, 3600)
+			},
+			wantTotal: "3600",
+			wantVAT:   "315.72",
+		},
+		{
+			name: "invalid items",
+			build: func() (VATRegistrationRequest, error) {
+				return DefaultTaxCalculator.RegistrationFromItems(receipt, LineItems{{Name: "Coffee", UnitPrice: 1500}}, 1500)
+			},
+			wantErr: true,
+		},
+		{
+			name: "items not matching the amount",
+			build: func() (VATRegistrationRequest, error) {
+				return DefaultTaxCalculator.RegistrationFromItems(receipt, LineItems{{Name: "Coffee", Quantity: 1, UnitPrice: 1500}}, 1000)
+			},
+			wantErr: true,
+		},
+		{
+			name: "payment",
+			build: func() (VATRegistrationRequest, error) {
+				return DefaultTaxCalculator.RegistrationFromPayment(receipt, &Payment{Amount: 1000}, true)
+			},
+			wantTotal: "1000",
+			wantVAT:   "90.09",
+		},
+		{
+			name: "payment without trans number",
+			build: func() (VATRegistrationRequest, error) {
+				return DefaultTaxCalculator.RegistrationFromPayment(VATReceipt{DDTD: "19910000004"}, &Payment{Amount: 1000}, true)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := tt.build()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want error", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := VATRegistrationRequest{
+				TransactionID: "3425279",
+				DDTD:          "19910000004",
+				TotalAmount:   tt.wantTotal,
+				VATAmount:     tt.wantVAT,
+				CreatedDate:   Date{Time: time.Date(2024, 11, 20, 0, 0, 0, 0, Ulaanbaatar)},
+				MerchantName:  "Test Merchant",
+				MerchantTIN:   "1234567",
+			}
+			if req != want {
+				t.Errorf("got %+v, want %+v", req, want)
+			}
+		})
+	}
+}
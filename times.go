@@ -0,0 +1,51 @@
+package tokipay
+
+import (
+	"time"
+
+	"github.com/techpartners-asia/tokipay-third-party-service-go/internal/tokitime"
+)
+
+// Ulaanbaatar is the time zone of TokiPay's dates. It falls back to a fixed
+// UTC+8 zone, which Mongolia has kept without daylight saving time since
+// 2017, when the system has no time zone database.
+var Ulaanbaatar = tokitime.Ulaanbaatar
+
+// Timestamp is an instant sent as Unix milliseconds, such as the timestamp
+// of every TokiPay response. Values below 1e11 are read as Unix seconds.
+type Timestamp = tokitime.Timestamp
+
+// NewTimestamp returns t as a Timestamp in Ulaanbaatar time
+func NewTimestamp(t time.Time) Timestamp {
+	return tokitime.NewTimestamp(t)
+}
+
+// Date is a calendar day in Ulaanbaatar sent as MM/DD/YYYY, such as the
+// createdDate of a VAT registration
+type Date = tokitime.Date
+
+// NewDate returns the day t falls on in Ulaanbaatar
+func NewDate(t time.Time) Date {
+	return tokitime.NewDate(t)
+}
+
+// ParseDate parses a MM/DD/YYYY date. An empty string is the zero Date.
+func ParseDate(s string) (Date, error) {
+	d, err := tokitime.ParseDate(s)
+	if err != nil {
+		return Date{}, &ValidationError{Field: "date", Value: s, Reason: "must be a date as MM/DD/YYYY"}
+	}
+	return d, nil
+}
+
+// DateTimeLayout is the layout of DateTime, in Ulaanbaatar time
+const DateTimeLayout = tokitime.DateTimeLayout
+
+// DateTime is an instant sent as local Ulaanbaatar time, such as the paid
+// and refund dates of a transaction. RFC 3339 values are accepted too.
+type DateTime = tokitime.DateTime
+
+// NewDateTime returns t as a DateTime in Ulaanbaatar time
+func NewDateTime(t time.Time) DateTime {
+	return tokitime.NewDateTime(t)
+}
@@ -0,0 +1,135 @@
+package tokipay
+
+import (
+	"encoding"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimestampJSON(t *testing.T) {
+	want := time.Date(2024, 11, 20, 15, 4, 5, 123e6, time.UTC)
+	tests := []struct {
+		name string
+		json string
+		want time.Time
+	}{
+		{"milliseconds", "1732115045123", want},
+		{"seconds", "1732115045", want.Truncate(time.Second)},
+		{"string", `"1732115045123"`, want},
+		{"fraction", "1732115045123.0", want},
+		{"zero", "0", time.Time{}},
+		{"null", "null", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ts Timestamp
+			if err := json.Unmarshal([]byte(tt.json), &ts); err != nil {
+				t.Fatal(err)
+			}
+			if !ts.Time.Equal(tt.want) {
+				t.Errorf("got %v, want %v", ts.Time, tt.want)
+			}
+			if !ts.IsZero() && ts.Time.Location() != Ulaanbaatar {
+				t.Errorf("location = %v, want Ulaanbaatar", ts.Time.Location())
+			}
+		})
+	}
+
+	out, err := json.Marshal(TokiPayResponse[struct{}]{Timestamp: NewTimestamp(want)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"code":0,"status":"","timestamp":1732115045123,"data":{},"error":null}` {
+		t.Errorf("Marshal = %s", out)
+	}
+	var ts Timestamp
+	if err := json.Unmarshal([]byte(`"yesterday"`), &ts); err == nil {
+		t.Error("Unmarshal of a word succeeded")
+	}
+
+	// time.Time's RFC 3339 text encoding must not be promoted
+	if _, ok := any(NewTimestamp(want)).(encoding.TextMarshaler); ok {
+		t.Error("Timestamp implements encoding.TextMarshaler")
+	}
+	if got := NewTimestamp(want).String(); got != "2024-11-20T23:04:05.123+08:00" {
+		t.Errorf("String = %s", got)
+	}
+}
+
+func TestDate(t *testing.T) {
+	tests := []struct {
+		name string
+		time time.Time
+		want string
+	}{
+		{"afternoon UTC", time.Date(2024, 11, 20, 15, 4, 5, 0, time.UTC), "11/20/2024"},
+		{"evening UTC is the next day", time.Date(2024, 11, 20, 16, 0, 0, 0, time.UTC), "11/21/2024"},
+		{"Ulaanbaatar midnight", time.Date(2024, 11, 21, 0, 0, 0, 0, Ulaanbaatar), "11/21/2024"},
+		{"zero", time.Time{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDate(tt.time)
+			if d.String() != tt.want {
+				t.Errorf("NewDate(%v) = %q, want %q", tt.time, d.String(), tt.want)
+			}
+			parsed, err := ParseDate(tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed != d {
+				t.Errorf("ParseDate(%q) = %v, want %v", tt.want, parsed.Time, d.Time)
+			}
+		})
+	}
+
+	for _, s := range []string{"2024-11-20", "20/11/2024", "11/31/2024"} {
+		if _, err := ParseDate(s); err == nil {
+			t.Errorf("ParseDate(%q) succeeded", s)
+		}
+	}
+}
+
+func TestVATRegistrationRequestJSON(t *testing.T) {
+	req := VATRegistrationRequest{TransactionID: "3425279", DDTD: "19910000004"}
+	out, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"transactionId":"3425279","DDTD":"19910000004"}` {
+		t.Errorf("Marshal without date = %s", out)
+	}
+
+	req.CreatedDate = NewDate(time.Date(2024, 11, 20, 12, 0, 0, 0, Ulaanbaatar))
+	if out, err = json.Marshal(req); err != nil {
+		t.Fatal(err)
+	}
+	var decoded VATRegistrationRequest
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != req {
+		t.Errorf("round trip of %s = %+v, want %+v", out, decoded, req)
+	}
+	if err := json.Unmarshal([]byte(`{"createdDate":"2024-11-20"}`), &decoded); err == nil {
+		t.Error("Unmarshal of an ISO date succeeded")
+	}
+}
+
+func TestDateTimeJSON(t *testing.T) {
+	want := time.Date(2024, 11, 20, 7, 4, 5, 0, time.UTC)
+	for _, s := range []string{`"2024-11-20 15:04:05"`, `"2024-11-20T07:04:05Z"`, `"2024-11-20T15:04:05+08:00"`} {
+		var dt DateTime
+		if err := json.Unmarshal([]byte(s), &dt); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", s, err)
+		}
+		if !dt.Time.Equal(want) {
+			t.Errorf("Unmarshal(%s) = %v, want %v", s, dt.Time, want)
+		}
+		out, _ := json.Marshal(dt)
+		if string(out) != `"2024-11-20 15:04:05"` {
+			t.Errorf("Marshal = %s", out)
+		}
+	}
+}
@@ -2,24 +2,36 @@ package tokipay
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
-// TokiPayClient represents the TokiPay third-party service client
+// TokiPayClient represents the TokiPay third-party service client. Its
+// password and access token are Secrets, and the client itself prints and
+// marshals without them.
 type TokiPayClient struct {
 	BaseURL     string
 	Username    string
-	Password    string
+	Password    Secret
 	MerchantID  string
 	APIKey      string
-	AccessToken string
+	AccessToken Secret
 	TokenExpiry time.Time
 	HTTPClient  *http.Client
+
+	// Credentials, if set, supplies the username and password in place of
+	// the Username and Password fields. The cached access token is dropped
+	// whenever the provided credentials change.
+	Credentials CredentialsProvider
+
+	mu               sync.Mutex
+	tokenCredentials Credentials // credentials AccessToken was issued for
 }
 
 // TokiPay interface defines all available methods
@@ -46,7 +58,7 @@ func New(baseURL, username, password, merchantID string) TokiPay {
 	return &TokiPayClient{
 		BaseURL:    baseURL,
 		Username:   username,
-		Password:   password,
+		Password:   Secret(password),
 		MerchantID: merchantID,
 		APIKey:     ThirdPartyAPIKey,
 		HTTPClient: &http.Client{
@@ -55,17 +67,42 @@ func New(baseURL, username, password, merchantID string) TokiPay {
 	}
 }
 
+// NewWithCredentials creates a TokiPay client whose username and password
+// come from a CredentialsProvider
+func NewWithCredentials(baseURL string, creds CredentialsProvider, merchantID string) TokiPay {
+	client := New(baseURL, "", "", merchantID).(*TokiPayClient)
+	client.Credentials = creds
+	return client
+}
+
 // GetAccessToken retrieves and stores the access token
 func (c *TokiPayClient) GetAccessToken() error {
-	// Check if token is still valid
-	if c.AccessToken != "" && time.Now().Before(c.TokenExpiry) {
+	return c.getAccessToken(context.Background())
+}
+
+func (c *TokiPayClient) getAccessToken(ctx context.Context) error {
+	creds := Credentials{Username: c.Username, Password: c.Password}
+	if c.Credentials != nil {
+		var err error
+		if creds, err = c.Credentials.Credentials(ctx); err != nil {
+			return fmt.Errorf("failed to get credentials: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Check if token is still valid and was issued for the current
+	// credentials; a token set from outside the client is kept
+	rotated := c.tokenCredentials != (Credentials{}) && c.tokenCredentials != creds
+	if c.AccessToken != "" && time.Now().Before(c.TokenExpiry) && !rotated {
 		return nil
 	}
 
 	// Create basic auth header
-	auth := base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+	auth := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password.Reveal()))
 
-	req, err := http.NewRequest("GET", c.BaseURL+TokenEndpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+TokenEndpoint, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -75,52 +112,74 @@ func (c *TokiPayClient) GetAccessToken() error {
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		return &TransportError{fmt.Errorf("failed to execute request: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return &TransportError{fmt.Errorf("failed to read response: %w", err)}
 	}
 
 	var tokenResp TokiPayResponse[TokenResponse]
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return fmt.Errorf("failed to unmarshal response: %w", err)
+		return &TransportError{fmt.Errorf("failed to unmarshal response: %w", err)}
 	}
 
 	if tokenResp.Code != 200 {
-		return fmt.Errorf("token request failed: %s", tokenResp.Error.Message)
+		return &ResponseError{Op: "token request", Code: tokenResp.Code, HTTPStatus: resp.StatusCode, Message: tokenResp.ErrorMessage()}
 	}
 
-	c.AccessToken = tokenResp.Data.AccessToken
+	c.AccessToken = Secret(tokenResp.Data.AccessToken)
 	c.TokenExpiry = time.Now().Add(time.Duration(TokenExpiryDuration) * time.Second)
+	c.tokenCredentials = creds
 
 	return nil
 }
 
+// merchantID is the merchant ID payments created by the client are recorded with
+func (c *TokiPayClient) merchantID() string {
+	return c.MerchantID
+}
+
 // CreateQRPayment creates a QR payment request
 func (c *TokiPayClient) CreateQRPayment(req QRPaymentRequest) (*QRPaymentResponse, error) {
+	resp, err := c.CreateQRPaymentWithResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// CreateQRPaymentWithResponse creates a QR payment request and returns the
+// response metadata with the result. The response is also returned when
+// TokiPay rejects the request.
+func (c *TokiPayClient) CreateQRPaymentWithResponse(req QRPaymentRequest) (*Response[QRPaymentResponse], error) {
 	if err := c.GetAccessToken(); err != nil {
 		return nil, err
 	}
 
 	req.MerchantID = c.MerchantID
 
-	var resp TokiPayResponse[QRPaymentResponse]
-	if err := c.makeRequest("POST", QRPaymentEndpoint, req, &resp); err != nil {
-		return nil, err
+	resp, err := call[QRPaymentResponse](context.Background(), c, "QR payment request", "POST", QRPaymentEndpoint, req)
+	if resp != nil {
+		resp.Data.Timestamp = resp.Timestamp
 	}
+	return resp, err
+}
 
-	if resp.Code != 200 {
-		return nil, fmt.Errorf("QR payment request failed: %s", resp.Error.Message)
+// CreateMobilePayment creates a mobile payment request
+func (c *TokiPayClient) CreateMobilePayment(req MobilePaymentRequest) (*MobilePaymentResponse, error) {
+	resp, err := c.CreateMobilePaymentWithResponse(req)
+	if err != nil {
+		return nil, err
 	}
-
 	return &resp.Data, nil
 }
 
-// CreateMobilePayment creates a mobile payment request
-func (c *TokiPayClient) CreateMobilePayment(req MobilePaymentRequest) (*MobilePaymentResponse, error) {
+// CreateMobilePaymentWithResponse creates a mobile payment request and
+// returns the response metadata with the result
+func (c *TokiPayClient) CreateMobilePaymentWithResponse(req MobilePaymentRequest) (*Response[MobilePaymentResponse], error) {
 	if err := c.GetAccessToken(); err != nil {
 		return nil, err
 	}
@@ -133,20 +192,25 @@ func (c *TokiPayClient) CreateMobilePayment(req MobilePaymentRequest) (*MobilePa
 		req.Type = TypeThirdPartyPay
 	}
 
-	var resp TokiPayResponse[MobilePaymentResponse]
-	if err := c.makeRequest("POST", MobilePaymentEndpoint, req, &resp); err != nil {
-		return nil, err
+	resp, err := call[MobilePaymentResponse](context.Background(), c, "mobile payment request", "POST", MobilePaymentEndpoint, req)
+	if resp != nil {
+		resp.Data.Timestamp = resp.Timestamp
 	}
+	return resp, err
+}
 
-	if resp.Code != 200 {
-		return nil, fmt.Errorf("mobile payment request failed: %s", resp.Error.Message)
+// CreateDeeplinkPayment creates a deeplink payment request
+func (c *TokiPayClient) CreateDeeplinkPayment(req DeeplinkPaymentRequest) (*DeeplinkPaymentResponse, error) {
+	resp, err := c.CreateDeeplinkPaymentWithResponse(req)
+	if err != nil {
+		return nil, err
 	}
-
 	return &resp.Data, nil
 }
 
-// CreateDeeplinkPayment creates a deeplink payment request
-func (c *TokiPayClient) CreateDeeplinkPayment(req DeeplinkPaymentRequest) (*DeeplinkPaymentResponse, error) {
+// CreateDeeplinkPaymentWithResponse creates a deeplink payment request and
+// returns the response metadata with the result
+func (c *TokiPayClient) CreateDeeplinkPaymentWithResponse(req DeeplinkPaymentRequest) (*Response[DeeplinkPaymentResponse], error) {
 	if err := c.GetAccessToken(); err != nil {
 		return nil, err
 	}
@@ -154,131 +218,147 @@ func (c *TokiPayClient) CreateDeeplinkPayment(req DeeplinkPaymentRequest) (*Deep
 	req.MerchantID = c.MerchantID
 	req.Type = TypeThirdPartyPay
 
-	var resp TokiPayResponse[DeeplinkPaymentResponse]
-	if err := c.makeRequest("POST", DeeplinkEndpoint, req, &resp); err != nil {
-		return nil, err
+	resp, err := call[DeeplinkPaymentResponse](context.Background(), c, "deeplink payment request", "POST", DeeplinkEndpoint, req)
+	if resp != nil {
+		resp.Data.Timestamp = resp.Timestamp
 	}
+	return resp, err
+}
 
-	if resp.Code != 200 {
-		return nil, fmt.Errorf("deeplink payment request failed: %s", resp.Error.Message)
+// CheckPaymentStatus checks the status of a payment
+func (c *TokiPayClient) CheckPaymentStatus(requestID string) (*PaymentStatusResponse, error) {
+	resp, err := c.CheckPaymentStatusWithResponse(requestID)
+	if err != nil {
+		return nil, err
 	}
-
 	return &resp.Data, nil
 }
 
-// CheckPaymentStatus checks the status of a payment
-func (c *TokiPayClient) CheckPaymentStatus(requestID string) (*PaymentStatusResponse, error) {
+// CheckPaymentStatusWithResponse checks the status of a payment and returns
+// the response metadata with the result
+func (c *TokiPayClient) CheckPaymentStatusWithResponse(requestID string) (*Response[PaymentStatusResponse], error) {
 	if err := c.GetAccessToken(); err != nil {
 		return nil, err
 	}
 
 	endpoint := fmt.Sprintf("%s?requestId=%s", StatusEndpoint, requestID)
 
-	var resp TokiPayResponse[PaymentStatusResponse]
-	if err := c.makeRequest("GET", endpoint, nil, &resp); err != nil {
-		return nil, err
-	}
-
-	if resp.Code != 200 {
-		return nil, fmt.Errorf("payment status check failed: %s", resp.Error.Message)
+	resp, err := call[PaymentStatusResponse](context.Background(), c, "payment status check", "GET", endpoint, nil)
+	if resp != nil {
+		resp.Data.Timestamp = resp.Timestamp
 	}
-
-	return &resp.Data, nil
+	return resp, err
 }
 
 // CancelPayment cancels a payment request
 func (c *TokiPayClient) CancelPayment(requestID string) error {
+	_, err := c.CancelPaymentWithResponse(requestID)
+	return err
+}
+
+// CancelPaymentWithResponse cancels a payment request and returns the
+// response metadata
+func (c *TokiPayClient) CancelPaymentWithResponse(requestID string) (*Response[any], error) {
 	if err := c.GetAccessToken(); err != nil {
-		return err
+		return nil, err
 	}
 
 	endpoint := fmt.Sprintf("%s/%s", CancelEndpoint, requestID)
 
-	var resp TokiPayResponse[interface{}]
-	if err := c.makeRequest("PATCH", endpoint, nil, &resp); err != nil {
-		return err
-	}
-
-	if resp.Code != 200 {
-		return fmt.Errorf("payment cancellation failed: %s", resp.Error.Message)
-	}
-
-	return nil
+	return call[any](context.Background(), c, "payment cancellation", "PATCH", endpoint, nil)
 }
 
 // RefundPayment processes a refund
 func (c *TokiPayClient) RefundPayment(req RefundRequest) (*RefundResponse, error) {
-	if err := c.GetAccessToken(); err != nil {
+	resp, err := c.RefundPaymentWithResponse(req)
+	if err != nil {
 		return nil, err
 	}
+	return &resp.Data, nil
+}
 
-	req.MerchantID = c.MerchantID
-
-	var resp TokiPayResponse[RefundResponse]
-	if err := c.makeRequest("POST", RefundEndpoint, req, &resp); err != nil {
+// RefundPaymentWithResponse processes a refund and returns the response
+// metadata with the result
+func (c *TokiPayClient) RefundPaymentWithResponse(req RefundRequest) (*Response[RefundResponse], error) {
+	if err := c.GetAccessToken(); err != nil {
 		return nil, err
 	}
 
-	if resp.Code != 200 {
-		return nil, fmt.Errorf("refund request failed: %s", resp.Error.Message)
-	}
+	req.MerchantID = c.MerchantID
 
-	return &resp.Data, nil
+	resp, err := call[RefundResponse](context.Background(), c, "refund request", "POST", RefundEndpoint, req)
+	if resp != nil {
+		resp.Data.Timestamp = resp.Timestamp
+	}
+	return resp, err
 }
 
 // RegisterVAT registers organization VAT details
 func (c *TokiPayClient) RegisterVAT(req VATRegistrationRequest) (*VATRegistrationResponse, error) {
-	if err := c.GetAccessToken(); err != nil {
+	resp, err := c.RegisterVATWithResponse(req)
+	if err != nil {
 		return nil, err
 	}
+	return &resp.Data, nil
+}
 
-	var resp TokiPayResponse[VATRegistrationResponse]
-	if err := c.makeRequest("POST", VATEndpoint, req, &resp); err != nil {
+// RegisterVATWithResponse registers organization VAT details and returns
+// the response metadata with the result
+func (c *TokiPayClient) RegisterVATWithResponse(req VATRegistrationRequest) (*Response[VATRegistrationResponse], error) {
+	if err := req.Validate(); err != nil {
 		return nil, err
 	}
 
-	if resp.Code != 200 {
-		return nil, fmt.Errorf("VAT registration failed: %s", resp.Error.Message)
+	if err := c.GetAccessToken(); err != nil {
+		return nil, err
 	}
 
-	return &resp.Data, nil
+	resp, err := call[VATRegistrationResponse](context.Background(), c, "VAT registration", "POST", VATEndpoint, req)
+	if resp != nil {
+		resp.Data.Timestamp = resp.Timestamp
+	}
+	return resp, err
 }
 
-// makeRequest is a helper method to make HTTP requests
-func (c *TokiPayClient) makeRequest(method, endpoint string, body interface{}, result interface{}) error {
+// makeRequest is a helper method to make HTTP requests. The HTTP response
+// is returned with its body already read into result.
+func (c *TokiPayClient) makeRequest(ctx context.Context, method, endpoint string, body interface{}, result interface{}) (*http.Response, error) {
 	var reqBody io.Reader
 
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("failed to marshal request body: %w", err)
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, c.BaseURL+endpoint, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+endpoint, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("api-key", c.APIKey)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	c.mu.Lock()
+	token := c.AccessToken
+	c.mu.Unlock()
+	req.Header.Set("Authorization", "Bearer "+token.Reveal())
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		return nil, &TransportError{fmt.Errorf("failed to execute request: %w", err)}
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return nil, &TransportError{fmt.Errorf("failed to read response: %w", err)}
 	}
 
 	if err := json.Unmarshal(respBody, result); err != nil {
-		return fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, &TransportError{fmt.Errorf("failed to unmarshal response: %w", err)}
 	}
 
-	return nil
+	return resp, nil
 }
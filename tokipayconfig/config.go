@@ -0,0 +1,381 @@
+// Package tokipayconfig loads TokiPay client settings from a YAML, JSON or
+// TOML file and TOKIPAY_* environment variables, so that every service
+// configures its client the same way.
+//
+// Settings are resolved in this order, later sources taking precedence:
+//
+//  1. the defaults of Default
+//  2. the config file, if any
+//  3. the TOKIPAY_* environment variables read by Config.LoadEnv
+//
+// A setting that is empty or absent in a source leaves the previous value
+// in place. The result is validated before a client is built from it.
+package tokipayconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// Environments selecting the base URL
+const (
+	EnvironmentProduction = "prod"
+	EnvironmentTest       = "test"
+)
+
+// Defaults applied by Default
+const (
+	DefaultEnvironment = EnvironmentTest
+	DefaultTimeout     = 30 * time.Second
+)
+
+// EnvConfigFile names the config file Load reads when it is given no path
+const EnvConfigFile = "TOKIPAY_CONFIG_FILE"
+
+// Config holds the settings of a TokiPay client
+type Config struct {
+	// Environment selects the base URL, prod or test. It is ignored when
+	// BaseURL is set.
+	Environment string `json:"environment" yaml:"environment" toml:"environment"`
+	BaseURL     string `json:"baseUrl" yaml:"baseUrl" toml:"baseUrl"`
+
+	// Credentials are either Username and Password, or the files holding
+	// them, such as the keys of a mounted Kubernetes secret. Files are read
+	// again when they change.
+	Username     string         `json:"username" yaml:"username" toml:"username"`
+	Password     tokipay.Secret `json:"password" yaml:"password" toml:"password"`
+	UsernameFile string         `json:"usernameFile" yaml:"usernameFile" toml:"usernameFile"`
+	PasswordFile string         `json:"passwordFile" yaml:"passwordFile" toml:"passwordFile"`
+
+	MerchantID string `json:"merchantId" yaml:"merchantId" toml:"merchantId"`
+
+	// Timeout of a whole call, retries included
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+
+	Retry     Retry     `json:"retry" yaml:"retry" toml:"retry"`
+	RateLimit RateLimit `json:"rateLimit" yaml:"rateLimit" toml:"rateLimit"`
+	Callback  Callback  `json:"callback" yaml:"callback" toml:"callback"`
+}
+
+// Retry configures a tokipay.RetryTransport
+type Retry struct {
+	// Attempts is the maximum number of times a request is sent; zero or one
+	// disables retries
+	Attempts int      `json:"attempts" yaml:"attempts" toml:"attempts"`
+	Delay    Duration `json:"delay" yaml:"delay" toml:"delay"`
+	MaxDelay Duration `json:"maxDelay" yaml:"maxDelay" toml:"maxDelay"`
+}
+
+// RateLimit configures a tokipay.RateLimitTransport
+type RateLimit struct {
+	// Rate in requests per second; zero disables the limit
+	Rate  float64 `json:"rate" yaml:"rate" toml:"rate"`
+	Burst int     `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// Callback holds the URLs TokiPay redirects to and calls back after a
+// payment, used for orders without their own
+type Callback struct {
+	SuccessURL string `json:"successUrl" yaml:"successUrl" toml:"successUrl"`
+	FailureURL string `json:"failureUrl" yaml:"failureUrl" toml:"failureUrl"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" or "1m30s"
+type Duration time.Duration
+
+// String implements fmt.Stringer
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(data []byte) error {
+	v, err := time.ParseDuration(string(data))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns the settings before any file or environment variable is
+// applied
+func Default() Config {
+	return Config{
+		Environment: DefaultEnvironment,
+		Timeout:     Duration(DefaultTimeout),
+		Retry: Retry{
+			Delay:    Duration(tokipay.DefaultRetryDelay),
+			MaxDelay: Duration(tokipay.DefaultRetryMaxDelay),
+		},
+	}
+}
+
+// Load resolves the settings from the defaults, the config file at path and
+// the environment, and validates them. An empty path reads the file named
+// by TOKIPAY_CONFIG_FILE, or no file if that is unset too. The file format
+// follows the extension: .yaml or .yml, .json, or .toml.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		path = os.Getenv(EnvConfigFile)
+	}
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadFile applies the settings of a config file. Unknown keys are errors,
+// so that a misspelt setting is not silently ignored.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+		if errors.Is(err, io.EOF) {
+			err = nil // empty file
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), c)
+		if undecoded := meta.Undecoded(); err == nil && len(undecoded) > 0 {
+			err = fmt.Errorf("unknown setting %s", undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s: unknown format %q, want .yaml, .yml, .json or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadEnv applies the environment variables that are set and not empty:
+//
+//	TOKIPAY_ENV              environment
+//	TOKIPAY_BASE_URL         baseUrl
+//	TOKIPAY_USERNAME         username
+//	TOKIPAY_PASSWORD         password
+//	TOKIPAY_USERNAME_FILE    usernameFile
+//	TOKIPAY_PASSWORD_FILE    passwordFile
+//	TOKIPAY_MERCHANT_ID      merchantId
+//	TOKIPAY_TIMEOUT          timeout
+//	TOKIPAY_RETRY_ATTEMPTS   retry.attempts
+//	TOKIPAY_RETRY_DELAY      retry.delay
+//	TOKIPAY_RETRY_MAX_DELAY  retry.maxDelay
+//	TOKIPAY_RATE_LIMIT       rateLimit.rate
+//	TOKIPAY_RATE_BURST       rateLimit.burst
+//	TOKIPAY_SUCCESS_URL      callback.successUrl
+//	TOKIPAY_FAILURE_URL      callback.failureUrl
+//
+// Credentials set in the environment replace those of the file as a whole:
+// TOKIPAY_USERNAME or TOKIPAY_PASSWORD clear usernameFile and passwordFile,
+// and TOKIPAY_USERNAME_FILE or TOKIPAY_PASSWORD_FILE clear username and
+// password.
+func (c *Config) LoadEnv() error {
+	var errs []error
+	str := func(key string, v *string) {
+		if s := os.Getenv(key); s != "" {
+			*v = s
+		}
+	}
+	text := func(key string, v interface{ UnmarshalText([]byte) error }) {
+		if s := os.Getenv(key); s != "" {
+			if err := v.UnmarshalText([]byte(s)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+	integer := func(key string, v *int) {
+		if s := os.Getenv(key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", key, s))
+			}
+			*v = n
+		}
+	}
+
+	inline := os.Getenv("TOKIPAY_USERNAME") != "" || os.Getenv("TOKIPAY_PASSWORD") != ""
+	files := os.Getenv("TOKIPAY_USERNAME_FILE") != "" || os.Getenv("TOKIPAY_PASSWORD_FILE") != ""
+	if inline && !files {
+		c.UsernameFile, c.PasswordFile = "", ""
+	}
+	if files && !inline {
+		c.Username, c.Password = "", ""
+	}
+
+	str("TOKIPAY_ENV", &c.Environment)
+	str("TOKIPAY_BASE_URL", &c.BaseURL)
+	str("TOKIPAY_USERNAME", &c.Username)
+	text("TOKIPAY_PASSWORD", &c.Password)
+	str("TOKIPAY_USERNAME_FILE", &c.UsernameFile)
+	str("TOKIPAY_PASSWORD_FILE", &c.PasswordFile)
+	str("TOKIPAY_MERCHANT_ID", &c.MerchantID)
+	text("TOKIPAY_TIMEOUT", &c.Timeout)
+	integer("TOKIPAY_RETRY_ATTEMPTS", &c.Retry.Attempts)
+	text("TOKIPAY_RETRY_DELAY", &c.Retry.Delay)
+	text("TOKIPAY_RETRY_MAX_DELAY", &c.Retry.MaxDelay)
+	if s := os.Getenv("TOKIPAY_RATE_LIMIT"); s != "" {
+		rate, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TOKIPAY_RATE_LIMIT: %q is not a number", s))
+		}
+		c.RateLimit.Rate = rate
+	}
+	integer("TOKIPAY_RATE_BURST", &c.RateLimit.Burst)
+	str("TOKIPAY_SUCCESS_URL", &c.Callback.SuccessURL)
+	str("TOKIPAY_FAILURE_URL", &c.Callback.FailureURL)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid or missing setting
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.BaseURL == "" {
+		if c.Environment != EnvironmentProduction && c.Environment != EnvironmentTest {
+			invalid("environment %q is not %s or %s", c.Environment, EnvironmentProduction, EnvironmentTest)
+		}
+	} else if err := checkURL(c.BaseURL); err != nil {
+		invalid("baseUrl: %v", err)
+	}
+
+	inline := c.Username != "" || c.Password != ""
+	files := c.UsernameFile != "" || c.PasswordFile != ""
+	switch {
+	case inline && files:
+		invalid("set either username and password or usernameFile and passwordFile, not both")
+	case files && (c.UsernameFile == "" || c.PasswordFile == ""):
+		invalid("usernameFile and passwordFile must be set together")
+	case !files && (c.Username == "" || c.Password == ""):
+		invalid("username and password are required")
+	}
+	if c.MerchantID == "" {
+		invalid("merchantId is required")
+	}
+
+	if c.Timeout < 0 {
+		invalid("timeout is negative")
+	}
+	if c.Retry.Attempts < 0 || c.Retry.Delay < 0 || c.Retry.MaxDelay < 0 {
+		invalid("retry settings are negative")
+	}
+	if c.RateLimit.Rate < 0 || c.RateLimit.Burst < 0 {
+		invalid("rateLimit settings are negative")
+	}
+	for name, u := range map[string]string{"callback.successUrl": c.Callback.SuccessURL, "callback.failureUrl": c.Callback.FailureURL} {
+		if u == "" {
+			continue
+		}
+		if err := checkURL(u); err != nil {
+			invalid("%s: %v", name, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http or https URL", s)
+	}
+	return nil
+}
+
+// BaseURLOrDefault returns BaseURL, or the base URL of the environment
+func (c *Config) BaseURLOrDefault() string {
+	if c.BaseURL != "" {
+		return c.BaseURL
+	}
+	if c.Environment == EnvironmentProduction {
+		return tokipay.ProductionBaseURL
+	}
+	return tokipay.TestBaseURL
+}
+
+// Client validates the settings and builds a client from them. Retries and
+// the rate limit are set up as the Transport of the client's HTTPClient.
+func (c *Config) Client() (*tokipay.TokiPayClient, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var client *tokipay.TokiPayClient
+	if c.UsernameFile != "" {
+		creds := tokipay.NewFileCredentials(c.UsernameFile, c.PasswordFile)
+		client = tokipay.NewWithCredentials(c.BaseURLOrDefault(), creds, c.MerchantID).(*tokipay.TokiPayClient)
+	} else {
+		client = tokipay.New(c.BaseURLOrDefault(), c.Username, c.Password.Reveal(), c.MerchantID).(*tokipay.TokiPayClient)
+	}
+
+	transport := http.DefaultTransport
+	if c.RateLimit.Rate > 0 {
+		transport = &tokipay.RateLimitTransport{Base: transport, Rate: c.RateLimit.Rate, Burst: c.RateLimit.Burst}
+	}
+	if c.Retry.Attempts > 1 {
+		transport = &tokipay.RetryTransport{
+			Base:     transport,
+			Attempts: c.Retry.Attempts,
+			Delay:    time.Duration(c.Retry.Delay),
+			MaxDelay: time.Duration(c.Retry.MaxDelay),
+		}
+	}
+	client.HTTPClient = &http.Client{Timeout: time.Duration(c.Timeout), Transport: transport}
+	return client, nil
+}
+
+// Checkout returns a checkout on client that uses the callback URLs for
+// orders without their own
+func (c *Config) Checkout(client tokipay.TokiPay) *tokipay.Checkout {
+	checkout := tokipay.NewCheckout(client)
+	checkout.SuccessURL = c.Callback.SuccessURL
+	checkout.FailureURL = c.Callback.FailureURL
+	return checkout
+}
@@ -0,0 +1,201 @@
+package tokipayconfig
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+const yamlConfig = `
+environment: prod
+username: shop
+password: hunter2
+merchantId: merchant-1
+timeout: 10s
+retry:
+  attempts: 3
+  delay: 100ms
+rateLimit:
+  rate: 5
+  burst: 2
+callback:
+  successUrl: https://shop.mn/tokipay/success
+  failureUrl: https://shop.mn/tokipay/failure
+`
+
+const jsonConfig = `{
+  "environment": "prod",
+  "username": "shop",
+  "password": "hunter2",
+  "merchantId": "merchant-1",
+  "timeout": "10s",
+  "retry": {"attempts": 3, "delay": "100ms"},
+  "rateLimit": {"rate": 5, "burst": 2},
+  "callback": {"successUrl": "https://shop.mn/tokipay/success", "failureUrl": "https://shop.mn/tokipay/failure"}
+}`
+
+const tomlConfig = `
+environment = "prod"
+username = "shop"
+password = "hunter2"
+merchantId = "merchant-1"
+timeout = "10s"
+
+[retry]
+attempts = 3
+delay = "100ms"
+
+[rateLimit]
+rate = 5
+burst = 2
+
+[callback]
+successUrl = "https://shop.mn/tokipay/success"
+failureUrl = "https://shop.mn/tokipay/failure"
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFormats(t *testing.T) {
+	want := Config{
+		Environment: EnvironmentProduction,
+		Username:    "shop",
+		Password:    "hunter2",
+		MerchantID:  "merchant-1",
+		Timeout:     Duration(10 * time.Second),
+		Retry:       Retry{Attempts: 3, Delay: Duration(100 * time.Millisecond), MaxDelay: Duration(tokipay.DefaultRetryMaxDelay)},
+		RateLimit:   RateLimit{Rate: 5, Burst: 2},
+		Callback:    Callback{SuccessURL: "https://shop.mn/tokipay/success", FailureURL: "https://shop.mn/tokipay/failure"},
+	}
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"config.yaml", yamlConfig},
+		{"config.yml", yamlConfig},
+		{"config.json", jsonConfig},
+		{"config.toml", tomlConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *cfg != want {
+				t.Errorf("Load = %+v, want %+v", *cfg, want)
+			}
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, "config.yaml", yamlConfig)
+	t.Setenv(EnvConfigFile, path)
+	t.Setenv("TOKIPAY_BASE_URL", "https://sandbox.shop.mn")
+	t.Setenv("TOKIPAY_RETRY_ATTEMPTS", "5")
+	t.Setenv("TOKIPAY_TIMEOUT", "")
+	t.Setenv("TOKIPAY_USERNAME_FILE", "/var/run/secrets/tokipay/username")
+	t.Setenv("TOKIPAY_PASSWORD_FILE", "/var/run/secrets/tokipay/password")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BaseURLOrDefault() != "https://sandbox.shop.mn" || cfg.Retry.Attempts != 5 {
+		t.Errorf("environment did not override the file: %+v", *cfg)
+	}
+	if cfg.Timeout != Duration(10*time.Second) || cfg.Retry.MaxDelay != Duration(tokipay.DefaultRetryMaxDelay) {
+		t.Errorf("file or default replaced by an empty variable: %+v", *cfg)
+	}
+	if cfg.Username != "" || cfg.Password != "" || cfg.PasswordFile == "" {
+		t.Errorf("credential files from the environment kept the file's credentials: %+v", *cfg)
+	}
+
+	t.Setenv("TOKIPAY_RATE_LIMIT", "fast")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "TOKIPAY_RATE_LIMIT") {
+		t.Errorf("Load with an invalid rate error = %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unknown key", "config.yaml", "merchantID: m\n", "merchantID"},
+		{"unknown TOML key", "config.toml", "merchant_id = \"m\"\n", "merchant_id"},
+		{"unknown format", "config.ini", "", "unknown format"},
+		{"bad duration", "config.json", `{"timeout": "soon"}`, "soon"},
+		{"missing settings", "config.json", `{}`, "merchantId is required"},
+		{"environment", "config.yaml", "environment: staging\nusername: u\npassword: p\nmerchantId: m\n", "staging"},
+		{"both credentials", "config.yaml", "username: u\npassword: p\npasswordFile: /p\nusernameFile: /u\nmerchantId: m\n", "not both"},
+		{"relative URL", "config.yaml", "baseUrl: ms-api.toki.mn\nusername: u\npassword: p\nmerchantId: m\n", "baseUrl"},
+		{"callback URL", "config.yaml", "username: u\npassword: p\nmerchantId: m\ncallback:\n  successUrl: /done\n", "callback.successUrl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	cfg := Default()
+	cfg.BaseURL = srv.URL
+	cfg.Username, cfg.Password, cfg.MerchantID = srv.Username, tokipay.Secret(srv.Password), srv.MerchantID
+	cfg.Retry = Retry{Attempts: 2, Delay: Duration(time.Millisecond)}
+	cfg.RateLimit = RateLimit{Rate: 20, Burst: 1}
+	cfg.Callback = Callback{SuccessURL: "https://shop.mn/tokipay/success", FailureURL: "https://shop.mn/tokipay/failure"}
+
+	client, err := cfg.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.HTTPClient.Timeout != DefaultTimeout {
+		t.Errorf("timeout = %v", client.HTTPClient.Timeout)
+	}
+
+	// the server rejects payments without callback URLs
+	start := time.Now()
+	session, err := cfg.Checkout(client).Start(tokipay.CheckoutOrder{OrderID: "ORDER_1", Amount: 1000}, tokipay.MethodQR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a status check answered with 503 is retried
+	srv.FailNext(tokipaytest.EndpointStatus, 1, tokipaytest.Fault{StatusCode: http.StatusServiceUnavailable})
+	if _, err := client.CheckPaymentStatus(session.RequestID); err != nil {
+		t.Errorf("status check with one unavailable answer: %v", err)
+	}
+	// token, QR payment and two status checks at 20 per second
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("four requests took %v, want the rate limit to space them", elapsed)
+	}
+
+	cfg.MerchantID = ""
+	if _, err := cfg.Client(); err == nil {
+		t.Error("Client without a merchant ID succeeded")
+	}
+}
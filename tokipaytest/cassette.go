@@ -0,0 +1,389 @@
+package tokipaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Recorder modes
+type Mode int
+
+const (
+	// ModeReplay serves responses from the cassette and fails on requests
+	// that were not recorded
+	ModeReplay Mode = iota
+
+	// ModeRecord forwards requests to the real API and records them
+	ModeRecord
+
+	// ModeAuto replays when the cassette file exists and records otherwise
+	ModeAuto
+)
+
+// Scrubbed is the placeholder written in place of scrubbed values
+const Scrubbed = "[SCRUBBED]"
+
+// DefaultScrubKeys are the JSON keys whose values are scrubbed from
+// recorded request and response bodies
+var DefaultScrubKeys = []string{"accessToken", "password", "username", "phoneNo", "authorization"}
+
+// ErrUnusedInteractions is returned by Verify when recorded interactions
+// were never replayed
+var ErrUnusedInteractions = errors.New("tokipaytest: cassette has unused interactions")
+
+// droppedHeaders are never written to a cassette, either because they hold
+// secrets or because they change between recordings
+var droppedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Content-Length", "Date"}
+
+// Cassette is a recorded set of HTTP interactions
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request/response pair
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the scrubbed form of a request
+type RecordedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Query  string          `json:"query,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+
+	// RawBody holds a body that is not JSON, base64 encoded in the file
+	RawBody []byte `json:"rawBody,omitempty"`
+}
+
+// RecordedResponse is the scrubbed form of a response
+type RecordedResponse struct {
+	StatusCode int             `json:"statusCode"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+
+	// RawBody holds a body that is not JSON, such as the HTML page of a
+	// gateway error, base64 encoded in the file
+	RawBody []byte `json:"rawBody,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that records interactions to a cassette
+// file or replays them from one. Requests are matched by method, path,
+// query and body.
+type Recorder struct {
+	// Transport performs real requests in ModeRecord. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// ScrubKeys lists the JSON keys scrubbed from bodies. Defaults to
+	// DefaultScrubKeys.
+	ScrubKeys []string
+
+	path     string
+	mode     Mode
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewRecorder creates a recorder for the cassette at path. In replay mode
+// the cassette is loaded immediately.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	if mode == ModeAuto {
+		mode = ModeRecord
+		if _, err := os.Stat(path); err == nil {
+			mode = ModeReplay
+		}
+	}
+
+	r := &Recorder{path: path, mode: mode}
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cassette: %w", err)
+		}
+		for i, in := range r.cassette.Interactions {
+			r.cassette.Interactions[i].Request.Body = compact(in.Request.Body)
+			r.cassette.Interactions[i].Response.Body = compact(in.Response.Body)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+// Mode returns the mode the recorder is running in
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// RoundTrip records or replays a single request
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := r.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+	return r.record(req, recorded)
+}
+
+// Save writes the recorded interactions to the cassette file. It is a
+// no-op in replay mode.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// Unused returns the recorded interactions that were never replayed
+func (r *Recorder) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, used := range r.used {
+		if !used {
+			unused = append(unused, r.cassette.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// Verify returns ErrUnusedInteractions when replay finished without using
+// every recorded interaction
+func (r *Recorder) Verify() error {
+	if unused := r.Unused(); len(unused) > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrUnusedInteractions, len(unused), len(r.cassette.Interactions))
+	}
+	return nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !in.Request.matches(recorded) {
+			continue
+		}
+		r.used[i] = true
+
+		body := []byte(in.Response.Body)
+		if in.Response.RawBody != nil {
+			body = in.Response.RawBody
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	body := []byte(recorded.Body)
+	if recorded.RawBody != nil {
+		body = recorded.RawBody
+	}
+	return nil, fmt.Errorf("tokipaytest: no recorded interaction in %s matches %s %s?%s body=%s",
+		r.path, recorded.Method, recorded.Path, recorded.Query, body)
+}
+
+func (r *Recorder) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	for _, name := range droppedHeaders {
+		header.Del(name)
+	}
+
+	recordedResp := RecordedResponse{StatusCode: resp.StatusCode, Header: header}
+	recordedResp.Body, recordedResp.RawBody = r.scrub(body)
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  recorded,
+		Response: recordedResp,
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// recordRequest builds the scrubbed form of req, restoring its body
+func (r *Recorder) recordRequest(req *http.Request) (RecordedRequest, error) {
+	recorded := RecordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query().Encode(),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return recorded, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		recorded.Body, recorded.RawBody = r.scrub(body)
+	}
+	return recorded, nil
+}
+
+// scrub replaces the values of scrubbed keys anywhere in a JSON body and
+// returns it compacted, keeping its key order and number literals. A body
+// that is not JSON is returned unchanged as raw.
+func (r *Recorder) scrub(body []byte) (scrubbed json.RawMessage, raw []byte) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if !json.Valid(body) {
+		return nil, bytes.Clone(body)
+	}
+
+	keys := r.ScrubKeys
+	if keys == nil {
+		keys = DefaultScrubKeys
+	}
+	var buf bytes.Buffer
+	if err := scrubValue(&buf, body, keys); err != nil {
+		return nil, bytes.Clone(body)
+	}
+	return buf.Bytes(), nil
+}
+
+// scrubValue writes the JSON value raw to buf with the values of keys
+// replaced by Scrubbed
+func scrubValue(buf *bytes.Buffer, raw json.RawMessage, keys []string) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		buf.WriteByte('{')
+		for i := 0; dec.More(); i++ {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			var child json.RawMessage
+			if err := dec.Decode(&child); err != nil {
+				return err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			if containsKey(keys, key) {
+				child, _ = json.Marshal(Scrubbed)
+				buf.Write(child)
+				continue
+			}
+			if err := scrubValue(buf, child, keys); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case json.Delim('['):
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			var child json.RawMessage
+			if err := dec.Decode(&child); err != nil {
+				return err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := scrubValue(buf, child, keys); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		return json.Compact(buf, raw)
+	}
+	return nil
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// compact strips the indentation added when a cassette is saved
+func compact(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return raw
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
+
+func (rr RecordedRequest) matches(other RecordedRequest) bool {
+	return rr.Method == other.Method &&
+		rr.Path == other.Path &&
+		rr.Query == other.Query &&
+		bytes.Equal(rr.Body, other.Body) &&
+		bytes.Equal(rr.RawBody, other.RawBody)
+}
@@ -0,0 +1,117 @@
+package tokipaytest_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+	const (
+		phoneNo = "99119911"
+		gateway = "<html><body>502 Bad Gateway</body></html>\n"
+	)
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+
+	// run makes a mobile payment, checks its status once through a gateway
+	// error and once approved, and refunds part of it. The client fetches
+	// its token first, with basic auth. While recording, the server approves
+	// the payment and fails the first status check.
+	run := func(t *testing.T, rec *tokipaytest.Recorder, recording bool) string {
+		t.Helper()
+		client := srv.NewClient()
+		client.HTTPClient = &http.Client{Transport: rec}
+
+		payment, err := client.CreateMobilePayment(tokipay.MobilePaymentRequest{
+			SuccessURL: "https://example.com/success",
+			FailureURL: "https://example.com/failure",
+			OrderID:    "ORDER_1",
+			Amount:     1000,
+			PhoneNo:    phoneNo,
+		})
+		if err != nil {
+			t.Fatalf("mobile payment: %v", err)
+		}
+		if recording {
+			if err := srv.Approve(payment.RequestID); err != nil {
+				t.Fatal(err)
+			}
+			srv.FailNext(tokipaytest.EndpointStatus, 1, tokipaytest.Fault{StatusCode: http.StatusBadGateway, Body: gateway})
+		}
+
+		_, gatewayErr := client.CheckPaymentStatus(payment.RequestID)
+		if gatewayErr == nil {
+			t.Fatal("status check through a gateway error succeeded")
+		}
+		status, err := client.CheckPaymentStatus(payment.RequestID)
+		if err != nil {
+			t.Fatalf("status check: %v", err)
+		}
+		refund, err := client.RefundPayment(tokipay.RefundRequest{TransNumber: status.TransNumber, Amount: "400"})
+		if err != nil {
+			t.Fatalf("refund: %v", err)
+		}
+		return fmt.Sprintf("request %s, gateway error %q, status %s, transaction %s, refund %s",
+			payment.RequestID, gatewayErr, status.Status, status.TransNumber, refund.TxnNumber)
+	}
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	rec, err := tokipaytest.NewRecorder(path, tokipaytest.ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() != tokipaytest.ModeRecord {
+		t.Fatalf("mode without a cassette = %v, want ModeRecord", rec.Mode())
+	}
+	rec.Transport = srv.Client().Transport
+	recorded := run(t, rec, true)
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basicAuth := base64.StdEncoding.EncodeToString([]byte(srv.Username + ":" + srv.Password))
+	for _, secret := range []string{basicAuth, srv.Password, phoneNo, "Authorization"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q", secret)
+		}
+	}
+	for _, scrubbed := range []string{`"accessToken": "[SCRUBBED]"`, `"phoneNo": "[SCRUBBED]"`} {
+		if !strings.Contains(string(data), scrubbed) {
+			t.Errorf("cassette does not contain %s", scrubbed)
+		}
+	}
+
+	rec, err = tokipaytest.NewRecorder(path, tokipaytest.ModeAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() != tokipaytest.ModeReplay {
+		t.Fatalf("mode with a cassette = %v, want ModeReplay", rec.Mode())
+	}
+	srv.Close() // replay must not reach the server
+	if replayed := run(t, rec, false); replayed != recorded {
+		t.Errorf("replayed %s\nrecorded %s", replayed, recorded)
+	}
+	if err := rec.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// every interaction is used once
+	client := srv.NewClient()
+	client.HTTPClient = &http.Client{Transport: rec}
+	if err := client.GetAccessToken(); err == nil {
+		t.Error("replaying a used interaction succeeded")
+	}
+}
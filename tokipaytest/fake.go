@@ -0,0 +1,329 @@
+package tokipaytest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// Call is a method call recorded by FakeTokiPay. Arg holds the request
+// struct or request ID passed to the method, and is nil for GetAccessToken.
+// Err is the error the call returned, whether set with SetError, returned
+// by a Func field or a failed validation.
+type Call struct {
+	Method string
+	Arg    any
+	Err    error
+}
+
+// FakeTokiPay is an in-memory TokiPay implementation for unit tests. It
+// records every call and returns canned responses, which can be replaced
+// per method through the Func fields or failed through SetError. The zero
+// value is ready to use.
+type FakeTokiPay struct {
+	GetAccessTokenFunc        func() error
+	CreateQRPaymentFunc       func(req tokipay.QRPaymentRequest) (*tokipay.QRPaymentResponse, error)
+	CreateMobilePaymentFunc   func(req tokipay.MobilePaymentRequest) (*tokipay.MobilePaymentResponse, error)
+	CreateDeeplinkPaymentFunc func(req tokipay.DeeplinkPaymentRequest) (*tokipay.DeeplinkPaymentResponse, error)
+	CheckPaymentStatusFunc    func(requestID string) (*tokipay.PaymentStatusResponse, error)
+	CancelPaymentFunc         func(requestID string) error
+	RefundPaymentFunc         func(req tokipay.RefundRequest) (*tokipay.RefundResponse, error)
+	RegisterVATFunc           func(req tokipay.VATRegistrationRequest) (*tokipay.VATRegistrationResponse, error)
+
+	mu       sync.Mutex
+	seq      int
+	calls    []*Call
+	errors   map[string]error
+	statuses map[string][]string
+	current  map[string]*tokipay.PaymentStatusResponse
+}
+
+var _ tokipay.TokiPay = (*FakeTokiPay)(nil)
+
+// SetError makes every call to the named method fail with err. A nil err
+// clears the failure.
+func (f *FakeTokiPay) SetError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	if f.errors == nil {
+		f.errors = make(map[string]error)
+	}
+	f.errors[method] = err
+}
+
+// ProgressStatus scripts the statuses returned by successive
+// CheckPaymentStatus calls for a request. The last status is repeated once
+// the sequence is exhausted.
+func (f *FakeTokiPay) ProgressStatus(requestID string, statuses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.statuses == nil {
+		f.statuses = make(map[string][]string)
+	}
+	f.statuses[requestID] = statuses
+}
+
+// Calls returns the recorded calls to the named method, or every call when
+// method is empty
+func (f *FakeTokiPay) Calls(method string) []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []Call
+	for _, c := range f.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, *c)
+		}
+	}
+	return calls
+}
+
+// Reset clears recorded calls, errors and scripted statuses
+func (f *FakeTokiPay) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = nil
+	f.errors = make(map[string]error)
+	f.statuses = make(map[string][]string)
+	f.current = make(map[string]*tokipay.PaymentStatusResponse)
+}
+
+// GetAccessToken records the call and succeeds unless configured otherwise
+func (f *FakeTokiPay) GetAccessToken() (err error) {
+	defer f.record("GetAccessToken", nil)(&err)
+	if err := f.failure("GetAccessToken"); err != nil {
+		return err
+	}
+	if f.GetAccessTokenFunc != nil {
+		return f.GetAccessTokenFunc()
+	}
+	return nil
+}
+
+// CreateQRPayment records the call and returns generated IDs
+func (f *FakeTokiPay) CreateQRPayment(req tokipay.QRPaymentRequest) (_ *tokipay.QRPaymentResponse, err error) {
+	defer f.record("CreateQRPayment", req)(&err)
+	if err := f.failure("CreateQRPayment"); err != nil {
+		return nil, err
+	}
+	if f.CreateQRPaymentFunc != nil {
+		return f.CreateQRPaymentFunc(req)
+	}
+
+	n := f.next()
+	return &tokipay.QRPaymentResponse{RequestID: requestIDFor(n), TransactionID: fmt.Sprintf("tx-%06d", n)}, nil
+}
+
+// CreateMobilePayment records the call and returns a generated request ID
+func (f *FakeTokiPay) CreateMobilePayment(req tokipay.MobilePaymentRequest) (_ *tokipay.MobilePaymentResponse, err error) {
+	defer f.record("CreateMobilePayment", req)(&err)
+	if err := f.failure("CreateMobilePayment"); err != nil {
+		return nil, err
+	}
+	if f.CreateMobilePaymentFunc != nil {
+		return f.CreateMobilePaymentFunc(req)
+	}
+
+	return &tokipay.MobilePaymentResponse{RequestID: requestIDFor(f.next())}, nil
+}
+
+// CreateDeeplinkPayment records the call and returns a generated deeplink
+func (f *FakeTokiPay) CreateDeeplinkPayment(req tokipay.DeeplinkPaymentRequest) (_ *tokipay.DeeplinkPaymentResponse, err error) {
+	defer f.record("CreateDeeplinkPayment", req)(&err)
+	if err := f.failure("CreateDeeplinkPayment"); err != nil {
+		return nil, err
+	}
+	if f.CreateDeeplinkPaymentFunc != nil {
+		return f.CreateDeeplinkPaymentFunc(req)
+	}
+
+	n := f.next()
+	return &tokipay.DeeplinkPaymentResponse{
+		Deeplink:      "tokipay://payment?requestId=" + requestIDFor(n),
+		TransactionID: fmt.Sprintf("tx-%06d", n),
+	}, nil
+}
+
+// CheckPaymentStatus records the call and returns the next scripted status,
+// or PENDING when none is scripted
+func (f *FakeTokiPay) CheckPaymentStatus(requestID string) (_ *tokipay.PaymentStatusResponse, err error) {
+	defer f.record("CheckPaymentStatus", requestID)(&err)
+	if err := f.failure("CheckPaymentStatus"); err != nil {
+		return nil, err
+	}
+	if f.CheckPaymentStatusFunc != nil {
+		return f.CheckPaymentStatusFunc(requestID)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := tokipay.StatusPending
+	if statuses := f.statuses[requestID]; len(statuses) > 0 {
+		status = statuses[0]
+		if len(statuses) > 1 {
+			f.statuses[requestID] = statuses[1:]
+		}
+	}
+
+	if f.current == nil {
+		f.current = make(map[string]*tokipay.PaymentStatusResponse)
+	}
+	resp, ok := f.current[requestID]
+	if !ok {
+		resp = &tokipay.PaymentStatusResponse{}
+		f.current[requestID] = resp
+	}
+	resp.Status = status
+	if status == tokipay.StatusApproved && resp.TransNumber == "" {
+		f.seq++
+		resp.TransNumber = strconv.Itoa(3425278 + f.seq)
+	}
+
+	result := *resp
+	return &result, nil
+}
+
+// CancelPayment records the call and succeeds unless configured otherwise
+func (f *FakeTokiPay) CancelPayment(requestID string) (err error) {
+	defer f.record("CancelPayment", requestID)(&err)
+	if err := f.failure("CancelPayment"); err != nil {
+		return err
+	}
+	if f.CancelPaymentFunc != nil {
+		return f.CancelPaymentFunc(requestID)
+	}
+	return nil
+}
+
+// RefundPayment records the call and returns generated refund numbers
+func (f *FakeTokiPay) RefundPayment(req tokipay.RefundRequest) (_ *tokipay.RefundResponse, err error) {
+	defer f.record("RefundPayment", req)(&err)
+	if err := f.failure("RefundPayment"); err != nil {
+		return nil, err
+	}
+	if f.RefundPaymentFunc != nil {
+		return f.RefundPaymentFunc(req)
+	}
+
+	n := f.next()
+	return &tokipay.RefundResponse{
+		TransNumber:      req.TransNumber,
+		Response:         tokipay.StatusSuccess,
+		TxnNumber:        strconv.Itoa(5000000 + n),
+		TopupTransnumber: strconv.Itoa(7000000 + n),
+	}, nil
+}
+
+// RegisterVAT records the call and succeeds unless configured otherwise
+func (f *FakeTokiPay) RegisterVAT(req tokipay.VATRegistrationRequest) (_ *tokipay.VATRegistrationResponse, err error) {
+	defer f.record("RegisterVAT", req)(&err)
+	if err := f.failure("RegisterVAT"); err != nil {
+		return nil, err
+	}
+	// like the real client, invalid requests never reach TokiPay
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if f.RegisterVATFunc != nil {
+		return f.RegisterVATFunc(req)
+	}
+	return &tokipay.VATRegistrationResponse{Status: tokipay.StatusSuccess, Message: "VAT registered"}, nil
+}
+
+// ExpectCalls fails the test unless the named method was called n times
+func (f *FakeTokiPay) ExpectCalls(t testing.TB, method string, n int) {
+	t.Helper()
+	if got := len(f.Calls(method)); got != n {
+		t.Errorf("expected %d calls to %s, got %d", n, method, got)
+	}
+}
+
+// ExpectRefund fails the test unless a refund of amount was requested for
+// the transaction. Amounts are compared as numbers, so "500" matches
+// "500.00". An empty amount expects a full refund.
+func (f *FakeTokiPay) ExpectRefund(t testing.TB, transNumber, amount string) {
+	t.Helper()
+	for _, c := range f.Calls("RefundPayment") {
+		req := c.Arg.(tokipay.RefundRequest)
+		if req.TransNumber == transNumber && sameAmount(req.Amount, amount) {
+			return
+		}
+	}
+	t.Errorf("expected refund of %q for trans %s, got %v", amount, transNumber, f.Calls("RefundPayment"))
+}
+
+// ExpectCancel fails the test unless the payment request was cancelled
+func (f *FakeTokiPay) ExpectCancel(t testing.TB, requestID string) {
+	t.Helper()
+	for _, c := range f.Calls("CancelPayment") {
+		if c.Arg == requestID {
+			return
+		}
+	}
+	t.Errorf("expected cancellation of request %s", requestID)
+}
+
+// ExpectVAT fails the test unless VAT was registered for the transaction
+func (f *FakeTokiPay) ExpectVAT(t testing.TB, transactionID string) {
+	t.Helper()
+	for _, c := range f.Calls("RegisterVAT") {
+		if c.Arg.(tokipay.VATRegistrationRequest).TransactionID == transactionID {
+			return
+		}
+	}
+	t.Errorf("expected VAT registration for transaction %s", transactionID)
+}
+
+// record stores the call. Deferred with the address of the method's error
+// result, the returned func stores the error the call returned.
+func (f *FakeTokiPay) record(method string, arg any) func(err *error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := &Call{Method: method, Arg: arg}
+	f.calls = append(f.calls, call)
+	return func(err *error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		call.Err = *err
+	}
+}
+
+// failure returns the error configured for the method with SetError
+func (f *FakeTokiPay) failure(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.errors[method]
+}
+
+// sameAmount reports whether two refund amounts are equal to the cent. An
+// amount that is not a number only equals itself.
+func sameAmount(a, b string) bool {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX != nil || errY != nil {
+		return a == b
+	}
+	return math.Abs(x-y) < 0.005
+}
+
+func (f *FakeTokiPay) next() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	return f.seq
+}
@@ -0,0 +1,90 @@
+package tokipaytest_test
+
+import (
+	"errors"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+func TestFakeProgressStatus(t *testing.T) {
+	fake := &tokipaytest.FakeTokiPay{}
+	fake.ProgressStatus("REQ_1", tokipay.StatusPending, tokipay.StatusPending, tokipay.StatusApproved)
+
+	var transNumber string
+	for i, want := range []string{tokipay.StatusPending, tokipay.StatusPending, tokipay.StatusApproved, tokipay.StatusApproved} {
+		resp, err := fake.CheckPaymentStatus("REQ_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Errorf("call %d status = %s, want %s", i+1, resp.Status, want)
+		}
+		if want != tokipay.StatusApproved {
+			continue
+		}
+		// the approved payment keeps its TransNumber across calls
+		if resp.TransNumber == "" || (transNumber != "" && resp.TransNumber != transNumber) {
+			t.Errorf("call %d TransNumber = %q, want a stable one", i+1, resp.TransNumber)
+		}
+		transNumber = resp.TransNumber
+	}
+
+	if resp, _ := fake.CheckPaymentStatus("REQ_2"); resp.Status != tokipay.StatusPending {
+		t.Errorf("unscripted request status = %s", resp.Status)
+	}
+	fake.ExpectCalls(t, "CheckPaymentStatus", 5)
+
+	fake.Reset()
+	if resp, _ := fake.CheckPaymentStatus("REQ_1"); resp.Status != tokipay.StatusPending {
+		t.Errorf("status after Reset = %s", resp.Status)
+	}
+	fake.ExpectCalls(t, "CheckPaymentStatus", 1)
+}
+
+func TestFakeSetError(t *testing.T) {
+	fake := &tokipaytest.FakeTokiPay{}
+	failure := errors.New("refund failed")
+	fake.SetError("RefundPayment", failure)
+
+	if _, err := fake.RefundPayment(tokipay.RefundRequest{TransNumber: "3425279"}); !errors.Is(err, failure) {
+		t.Errorf("refund error = %v, want %v", err, failure)
+	}
+	fake.SetError("RefundPayment", nil)
+	if _, err := fake.RefundPayment(tokipay.RefundRequest{TransNumber: "3425279", Amount: "500"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed calls are recorded too
+	calls := fake.Calls("RefundPayment")
+	if len(calls) != 2 || calls[0].Err != failure || calls[1].Err != nil {
+		t.Errorf("calls = %+v", calls)
+	}
+	// amounts are compared as numbers
+	fake.ExpectRefund(t, "3425279", "500.00")
+
+	// so are errors returned by a Func
+	declined := errors.New("declined")
+	fake.RefundPaymentFunc = func(tokipay.RefundRequest) (*tokipay.RefundResponse, error) {
+		return nil, declined
+	}
+	fake.RefundPayment(tokipay.RefundRequest{TransNumber: "3425279"})
+	if calls := fake.Calls("RefundPayment"); calls[2].Err != declined {
+		t.Errorf("error of a failed Func call = %v, want %v", calls[2].Err, declined)
+	}
+}
+
+func TestFakeRegisterVATInvalid(t *testing.T) {
+	fake := &tokipaytest.FakeTokiPay{}
+
+	var verr *tokipay.ValidationError
+	if _, err := fake.RegisterVAT(tokipay.VATRegistrationRequest{TransactionID: "tx-1", DDTD: "short"}); !errors.As(err, &verr) {
+		t.Errorf("invalid registration error = %v, want a *ValidationError", err)
+	}
+	// the invalid call is counted, with its error
+	fake.ExpectCalls(t, "RegisterVAT", 1)
+	if calls := fake.Calls("RegisterVAT"); !errors.As(calls[0].Err, &verr) {
+		t.Errorf("recorded error = %v, want a *ValidationError", calls[0].Err)
+	}
+}
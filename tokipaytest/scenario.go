@@ -0,0 +1,235 @@
+package tokipaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// Endpoint names used to target scenarios
+const (
+	EndpointToken    = "token"
+	EndpointQR       = "merchant-qr"
+	EndpointMobile   = "phone-number"
+	EndpointDeeplink = "deeplink"
+	EndpointStatus   = "status"
+	EndpointCancel   = "cancel"
+	EndpointRefund   = "refund"
+	EndpointVAT      = "vat"
+
+	// EndpointCallback targets callbacks sent by the server rather than
+	// requests it receives. Only Delay and Duplicates apply.
+	EndpointCallback = "callback"
+)
+
+// Fault describes how the server misbehaves for a matched request. A fault
+// with only Delay set delays the request and then handles it normally.
+type Fault struct {
+	// Delay is waited before the request is handled or the fault is written
+	Delay time.Duration
+
+	// StatusCode writes an error envelope with this HTTP status and code
+	StatusCode int
+
+	// Message overrides the error envelope message
+	Message string
+
+	// NilError writes an envelope with StatusCode (or 500) as its code and
+	// no error object
+	NilError bool
+
+	// Malformed writes a response body that is not valid JSON
+	Malformed bool
+
+	// ExpiredToken rejects the request as if the access token expired and
+	// revokes the presented token
+	ExpiredToken bool
+
+	// Body writes this raw body with StatusCode (or 200)
+	Body string
+
+	// Duplicates sends each matched callback this many extra times
+	Duplicates int
+}
+
+// Scenario applies a fault to matching requests
+type Scenario struct {
+	// Endpoint is one of the Endpoint constants. Empty matches every endpoint.
+	Endpoint string
+
+	// OrderID restricts the scenario to requests for this order
+	OrderID string
+
+	// Times is the number of matching requests the scenario applies to.
+	// Zero applies it to every matching request.
+	Times int
+
+	Fault Fault
+}
+
+// InternalError returns a fault that fails with an HTTP 500 envelope
+func InternalError() Fault {
+	return Fault{StatusCode: http.StatusInternalServerError, Message: "internal server error"}
+}
+
+// Delay returns a fault that delays the request by d
+func Delay(d time.Duration) Fault {
+	return Fault{Delay: d}
+}
+
+// Script registers a scenario. Scenarios are matched in registration order
+// and the first match wins.
+func (s *Server) Script(sc Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scenarios = append(s.scenarios, &scriptedScenario{Scenario: sc, remaining: sc.Times})
+}
+
+// FailNext fails the next n requests to the endpoint with fault
+func (s *Server) FailNext(endpoint string, n int, fault Fault) {
+	s.Script(Scenario{Endpoint: endpoint, Times: n, Fault: fault})
+}
+
+// ClearScenarios removes every registered scenario
+func (s *Server) ClearScenarios() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scenarios = nil
+}
+
+type scriptedScenario struct {
+	Scenario
+	remaining int
+}
+
+// match consumes and returns the first scenario matching the endpoint and
+// order, or nil when none applies
+func (s *Server) match(endpoint, orderID string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, sc := range s.scenarios {
+		if sc.Endpoint != "" && sc.Endpoint != endpoint {
+			continue
+		}
+		if sc.OrderID != "" && sc.OrderID != orderID {
+			continue
+		}
+		if sc.Times > 0 {
+			sc.remaining--
+			if sc.remaining <= 0 {
+				s.scenarios = append(s.scenarios[:i:i], s.scenarios[i+1:]...)
+			}
+		}
+		fault := sc.Fault
+		return &fault
+	}
+	return nil
+}
+
+// scripted applies matching scenarios before handing the request to next
+func (s *Server) scripted(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fault := s.match(endpoint, s.orderFor(endpoint, r))
+		if fault == nil {
+			next(w, r)
+			return
+		}
+
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		switch {
+		case fault.ExpiredToken:
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				s.mu.Lock()
+				delete(s.tokens, token)
+				s.mu.Unlock()
+			}
+			writeError(w, http.StatusUnauthorized, messageOr(fault.Message, "access token expired"))
+		case fault.Malformed:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusOr(fault.StatusCode, http.StatusOK))
+			io.WriteString(w, `{"code":200,"status":"success","data":{`)
+		case fault.Body != "":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusOr(fault.StatusCode, http.StatusOK))
+			io.WriteString(w, fault.Body)
+		case fault.NilError:
+			code := statusOr(fault.StatusCode, http.StatusInternalServerError)
+			writeEnvelope(w, code, tokipay.TokiPayResponse[any]{
+				Code:      code,
+				Status:    "error",
+				Timestamp: tokipay.NewTimestamp(time.Now()),
+			})
+		case fault.StatusCode != 0:
+			writeError(w, fault.StatusCode, messageOr(fault.Message, http.StatusText(fault.StatusCode)))
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// orderFor resolves the order a request refers to so per-order scenarios
+// can match. The request body is restored for the handler.
+func (s *Server) orderFor(endpoint string, r *http.Request) string {
+	var body struct {
+		OrderID       string `json:"orderId"`
+		TransNumber   string `json:"transNumber"`
+		TransactionID string `json:"transactionId"`
+	}
+	if r.Body != nil {
+		raw, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+		json.Unmarshal(raw, &body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p *Payment
+	switch endpoint {
+	case EndpointQR, EndpointMobile, EndpointDeeplink:
+		return body.OrderID
+	case EndpointStatus:
+		p = s.payments[r.URL.Query().Get("requestId")]
+	case EndpointCancel:
+		p = s.payments[r.PathValue("requestID")]
+	case EndpointRefund:
+		p = s.findByTransNumber(body.TransNumber)
+	case EndpointVAT:
+		if p = s.findByTransNumber(body.TransactionID); p == nil {
+			p = s.findByTransactionID(body.TransactionID)
+		}
+	}
+	if p == nil {
+		return ""
+	}
+	return p.OrderID
+}
+
+func statusOr(code, fallback int) int {
+	if code == 0 {
+		return fallback
+	}
+	return code
+}
+
+func messageOr(message, fallback string) string {
+	if message == "" {
+		return fallback
+	}
+	return message
+}
@@ -0,0 +1,624 @@
+// Package tokipaytest provides an in-process TokiPay sandbox for tests and
+// local development.
+package tokipaytest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// Default credentials accepted by a new Server
+const (
+	DefaultUsername   = "tokipay-test"
+	DefaultPassword   = "tokipay-test-password"
+	DefaultMerchantID = "tokipay-test-merchant"
+)
+
+// Payment is the server-side state of a payment request
+type Payment struct {
+	RequestID     string
+	TransactionID string
+	TransNumber   string
+	OrderID       string
+	MerchantID    string
+	Method        string
+	Amount        float64
+	Fee           float64
+	Refunded      float64
+	Status        string
+	Notes         string
+	PhoneNo       string
+	CountryCode   string
+	SuccessURL    string
+	FailureURL    string
+	VATDetails    *tokipay.VATDetails
+	Refunds       []tokipay.RefundResponse
+	VAT           []tokipay.VATRegistrationRequest
+	CreatedAt     time.Time
+}
+
+// Callback is a callback delivery attempted by the server
+type Callback struct {
+	URL        string
+	Request    tokipay.CallbackRequest
+	Headers    tokipay.CallbackHeaders
+	StatusCode int
+	Err        error
+	SentAt     time.Time
+}
+
+// Server is a fake TokiPay third-party service backed by httptest
+type Server struct {
+	*httptest.Server
+
+	// Credentials and merchant accepted by the server. Set before issuing requests.
+	Username   string
+	Password   string
+	MerchantID string
+
+	// FeeRate is applied to the payment amount when a payment is approved
+	FeeRate float64
+
+	// CallbackClient delivers callbacks to success and failure URLs
+	CallbackClient *http.Client
+
+	mu        sync.Mutex
+	seq       int
+	tokens    map[string]bool
+	payments  map[string]*Payment
+	orders    map[string]string
+	callbacks []Callback
+	scenarios []*scriptedScenario
+}
+
+// NewServer starts a new fake TokiPay server. Callers should Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Username:       DefaultUsername,
+		Password:       DefaultPassword,
+		MerchantID:     DefaultMerchantID,
+		CallbackClient: &http.Client{Timeout: 10 * time.Second},
+		tokens:         make(map[string]bool),
+		payments:       make(map[string]*Payment),
+		orders:         make(map[string]string),
+	}
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// NewClient returns a TokiPay client configured against the server
+func (s *Server) NewClient() *tokipay.TokiPayClient {
+	client := tokipay.New(s.URL, s.Username, s.Password, s.MerchantID).(*tokipay.TokiPayClient)
+	client.HTTPClient = s.Client()
+	return client
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+tokipay.TokenEndpoint, s.scripted(EndpointToken, s.handleToken))
+	mux.HandleFunc("POST "+tokipay.QRPaymentEndpoint, s.scripted(EndpointQR, s.authorized(s.handleQRPayment)))
+	mux.HandleFunc("POST "+tokipay.MobilePaymentEndpoint, s.scripted(EndpointMobile, s.authorized(s.handleMobilePayment)))
+	mux.HandleFunc("POST "+tokipay.DeeplinkEndpoint, s.scripted(EndpointDeeplink, s.authorized(s.handleDeeplinkPayment)))
+	mux.HandleFunc("GET "+tokipay.StatusEndpoint, s.scripted(EndpointStatus, s.authorized(s.handleStatus)))
+	mux.HandleFunc("PATCH "+tokipay.CancelEndpoint+"/{requestID}", s.scripted(EndpointCancel, s.authorized(s.handleCancel)))
+	mux.HandleFunc("POST "+tokipay.RefundEndpoint, s.scripted(EndpointRefund, s.authorized(s.handleRefund)))
+	mux.HandleFunc("POST "+tokipay.VATEndpoint, s.scripted(EndpointVAT, s.authorized(s.handleVAT)))
+	return mux
+}
+
+// Payment returns a copy of the payment with the given request ID
+func (s *Server) Payment(requestID string) (Payment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payments[requestID]
+	if !ok {
+		return Payment{}, false
+	}
+	return p.clone(), true
+}
+
+// PaymentByOrder returns a copy of the payment created for the given order ID
+func (s *Server) PaymentByOrder(orderID string) (Payment, bool) {
+	s.mu.Lock()
+	requestID, ok := s.orders[orderID]
+	s.mu.Unlock()
+	if !ok {
+		return Payment{}, false
+	}
+	return s.Payment(requestID)
+}
+
+// Payments returns copies of all payments known to the server
+func (s *Server) Payments() []Payment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payments := make([]Payment, 0, len(s.payments))
+	for i := 1; i <= s.seq; i++ {
+		if p, ok := s.payments[requestIDFor(i)]; ok {
+			payments = append(payments, p.clone())
+		}
+	}
+	return payments
+}
+
+// Callbacks returns every callback delivery attempted so far
+func (s *Server) Callbacks() []Callback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Callback(nil), s.callbacks...)
+}
+
+// Approve marks a pending payment as approved and sends the success callback
+func (s *Server) Approve(requestID string) error {
+	return s.settle(requestID, tokipay.StatusApproved, nil)
+}
+
+// ApproveWithVAT approves a payment for an organization customer. The VAT
+// details are reported by the status endpoint and sent as callback headers.
+func (s *Server) ApproveWithVAT(requestID string, vat tokipay.VATDetails) error {
+	return s.settle(requestID, tokipay.StatusApproved, &vat)
+}
+
+// Expire marks a pending payment as expired and sends the failure callback
+func (s *Server) Expire(requestID string) error {
+	return s.settle(requestID, tokipay.StatusExpired, nil)
+}
+
+// Fail cancels a pending payment as if the customer declined it and sends
+// the failure callback
+func (s *Server) Fail(requestID string) error {
+	return s.settle(requestID, tokipay.StatusCancelled, nil)
+}
+
+func (s *Server) settle(requestID, status string, vat *tokipay.VATDetails) error {
+	s.mu.Lock()
+	p, ok := s.payments[requestID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("payment %s not found", requestID)
+	}
+	if p.Status != tokipay.StatusPending {
+		s.mu.Unlock()
+		return fmt.Errorf("payment %s is %s, not %s", requestID, p.Status, tokipay.StatusPending)
+	}
+
+	p.Status = status
+	p.VATDetails = vat
+	if status == tokipay.StatusApproved {
+		p.TransNumber = strconv.Itoa(3425278 + s.nextSeq())
+		p.Fee = p.Amount * s.FeeRate
+	}
+	payment := p.clone()
+	s.mu.Unlock()
+
+	s.sendCallback(payment)
+	return nil
+}
+
+// sendCallback posts the callback for a settled payment to its success or
+// failure URL and records the attempt
+func (s *Server) sendCallback(p Payment) {
+	cb := Callback{
+		URL: p.FailureURL,
+		Request: tokipay.CallbackRequest{
+			OrderID:       p.OrderID,
+			RequestID:     p.RequestID,
+			Status:        tokipay.StatusFailure,
+			Amount:        p.Amount,
+			Authorization: p.TransactionID,
+		},
+	}
+	if p.Status == tokipay.StatusApproved {
+		cb.URL = p.SuccessURL
+		cb.Request.Status = tokipay.StatusSuccess
+	}
+	if p.VATDetails != nil {
+		cb.Headers = tokipay.CallbackHeaders{VATID: p.VATDetails.VATID, VATType: p.VATDetails.VATType}
+	}
+
+	deliveries := 1
+	if fault := s.match(EndpointCallback, p.OrderID); fault != nil {
+		time.Sleep(fault.Delay)
+		deliveries += fault.Duplicates
+	}
+
+	for range deliveries {
+		cb.SentAt = time.Now()
+		if cb.URL != "" {
+			cb.StatusCode, cb.Err = s.postCallback(cb)
+		}
+
+		s.mu.Lock()
+		s.callbacks = append(s.callbacks, cb)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) postCallback(cb Callback) (int, error) {
+	body, err := json.Marshal(cb.Request)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal callback: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", cb.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create callback request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cb.Headers.VATID != "" {
+		req.Header.Set(tokipay.HeaderVATID, cb.Headers.VATID)
+		req.Header.Set(tokipay.HeaderVATType, cb.Headers.VATType)
+	}
+
+	resp, err := s.CallbackClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to deliver callback: %w", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !ok || user != s.Username || pass != s.Password {
+		writeError(w, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+
+	token := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", user, s.nextSeq())))
+	s.tokens[token] = true
+	writeData(w, tokipay.TokenResponse{AccessToken: token})
+}
+
+// authorized rejects requests without a valid api-key and bearer token
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("api-key") != tokipay.ThirdPartyAPIKey {
+			writeError(w, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		valid := ok && s.tokens[token]
+		s.mu.Unlock()
+
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "invalid or expired access token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleQRPayment(w http.ResponseWriter, r *http.Request) {
+	var req tokipay.QRPaymentRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	p := &Payment{
+		Method:     tokipay.MethodQR,
+		OrderID:    req.OrderID,
+		MerchantID: req.MerchantID,
+		Amount:     req.Amount,
+		Notes:      req.Notes,
+		SuccessURL: req.SuccessURL,
+		FailureURL: req.FailureURL,
+	}
+	if !s.create(w, p) {
+		return
+	}
+
+	writeData(w, tokipay.QRPaymentResponse{RequestID: p.RequestID, TransactionID: p.TransactionID})
+}
+
+func (s *Server) handleMobilePayment(w http.ResponseWriter, r *http.Request) {
+	var req tokipay.MobilePaymentRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.PhoneNo == "" {
+		writeError(w, http.StatusBadRequest, "phoneNo is required")
+		return
+	}
+	if req.Type != tokipay.TypeSPOS && req.Type != tokipay.TypeThirdPartyPay {
+		writeError(w, http.StatusBadRequest, "invalid type: "+req.Type)
+		return
+	}
+
+	p := &Payment{
+		Method:      tokipay.MethodMobile,
+		OrderID:     req.OrderID,
+		MerchantID:  req.MerchantID,
+		Amount:      req.Amount,
+		Notes:       req.Notes,
+		PhoneNo:     req.PhoneNo,
+		CountryCode: req.CountryCode,
+		SuccessURL:  req.SuccessURL,
+		FailureURL:  req.FailureURL,
+	}
+	if !s.create(w, p) {
+		return
+	}
+
+	writeData(w, tokipay.MobilePaymentResponse{RequestID: p.RequestID})
+}
+
+func (s *Server) handleDeeplinkPayment(w http.ResponseWriter, r *http.Request) {
+	var req tokipay.DeeplinkPaymentRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	p := &Payment{
+		Method:     tokipay.MethodDeeplink,
+		OrderID:    req.OrderID,
+		MerchantID: req.MerchantID,
+		Amount:     req.Amount,
+		Notes:      req.Notes,
+		SuccessURL: req.SuccessURL,
+		FailureURL: req.FailureURL,
+	}
+	if !s.create(w, p) {
+		return
+	}
+
+	writeData(w, tokipay.DeeplinkPaymentResponse{
+		Deeplink:      "tokipay://payment?requestId=" + p.RequestID,
+		TransactionID: p.TransactionID,
+	})
+}
+
+// create validates and stores a new pending payment, writing an error
+// envelope and returning false when the payment is rejected
+func (s *Server) create(w http.ResponseWriter, p *Payment) bool {
+	switch {
+	case p.OrderID == "":
+		writeError(w, http.StatusBadRequest, "orderId is required")
+		return false
+	case p.Amount <= 0:
+		writeError(w, http.StatusBadRequest, "amount must be greater than 0")
+		return false
+	case p.SuccessURL == "" || p.FailureURL == "":
+		writeError(w, http.StatusBadRequest, "successUrl and failureUrl are required")
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.MerchantID != s.MerchantID {
+		writeError(w, http.StatusForbidden, "unknown merchant: "+p.MerchantID)
+		return false
+	}
+	if _, exists := s.orders[p.OrderID]; exists {
+		writeError(w, http.StatusConflict, "duplicate orderId: "+p.OrderID)
+		return false
+	}
+
+	n := s.nextSeq()
+	p.RequestID = requestIDFor(n)
+	p.TransactionID = fmt.Sprintf("tx-%06d", n)
+	p.Status = tokipay.StatusPending
+	p.CreatedAt = time.Now()
+
+	s.payments[p.RequestID] = p
+	s.orders[p.OrderID] = p.RequestID
+	return true
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	requestID := r.URL.Query().Get("requestId")
+
+	s.mu.Lock()
+	p, ok := s.payments[requestID]
+	var resp tokipay.PaymentStatusResponse
+	if ok {
+		resp = tokipay.PaymentStatusResponse{
+			Status:      p.Status,
+			TransNumber: p.TransNumber,
+			Fee:         p.Fee,
+			VATDetails:  p.VATDetails,
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "payment request not found: "+requestID)
+		return
+	}
+	writeData(w, resp)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	requestID := r.PathValue("requestID")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payments[requestID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "payment request not found: "+requestID)
+		return
+	}
+	if p.Status != tokipay.StatusPending {
+		writeError(w, http.StatusBadRequest, "payment request is already "+p.Status)
+		return
+	}
+
+	p.Status = tokipay.StatusCancelled
+	writeData[any](w, nil)
+}
+
+func (s *Server) handleRefund(w http.ResponseWriter, r *http.Request) {
+	var req tokipay.RefundRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.findByTransNumber(req.TransNumber)
+	if p == nil {
+		writeError(w, http.StatusNotFound, "transaction not found: "+req.TransNumber)
+		return
+	}
+	if req.MerchantID != p.MerchantID {
+		writeError(w, http.StatusForbidden, "unknown merchant: "+req.MerchantID)
+		return
+	}
+	if p.Status != tokipay.StatusApproved {
+		writeError(w, http.StatusBadRequest, "transaction is not approved")
+		return
+	}
+
+	amount := p.Amount - p.Refunded
+	if req.Amount != "" {
+		parsed, err := strconv.ParseFloat(req.Amount, 64)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid amount: "+req.Amount)
+			return
+		}
+		amount = parsed
+	}
+	if amount <= 0 || amount > p.Amount-p.Refunded {
+		writeError(w, http.StatusBadRequest, "refund amount exceeds refundable amount")
+		return
+	}
+
+	p.Refunded += amount
+	resp := tokipay.RefundResponse{
+		TransNumber:      p.TransNumber,
+		Response:         tokipay.StatusSuccess,
+		TxnNumber:        strconv.Itoa(5000000 + s.nextSeq()),
+		TopupTransnumber: strconv.Itoa(7000000 + s.nextSeq()),
+	}
+	p.Refunds = append(p.Refunds, resp)
+
+	writeData(w, resp)
+}
+
+func (s *Server) handleVAT(w http.ResponseWriter, r *http.Request) {
+	var req tokipay.VATRegistrationRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.DDTD == "" {
+		writeError(w, http.StatusBadRequest, "DDTD is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.findByTransNumber(req.TransactionID)
+	if p == nil {
+		p = s.findByTransactionID(req.TransactionID)
+	}
+	if p == nil {
+		writeError(w, http.StatusNotFound, "transaction not found: "+req.TransactionID)
+		return
+	}
+	if p.Status != tokipay.StatusApproved {
+		writeError(w, http.StatusBadRequest, "transaction is not approved")
+		return
+	}
+
+	p.VAT = append(p.VAT, req)
+	writeData(w, tokipay.VATRegistrationResponse{Status: tokipay.StatusSuccess, Message: "VAT registered"})
+}
+
+func (s *Server) findByTransNumber(transNumber string) *Payment {
+	if transNumber == "" {
+		return nil
+	}
+	for _, p := range s.payments {
+		if p.TransNumber == transNumber {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *Server) findByTransactionID(transactionID string) *Payment {
+	for _, p := range s.payments {
+		if p.TransactionID == transactionID {
+			return p
+		}
+	}
+	return nil
+}
+
+// nextSeq must be called with s.mu held
+func (s *Server) nextSeq() int {
+	s.seq++
+	return s.seq
+}
+
+func (p *Payment) clone() Payment {
+	c := *p
+	if p.VATDetails != nil {
+		vat := *p.VATDetails
+		c.VATDetails = &vat
+	}
+	c.Refunds = append([]tokipay.RefundResponse(nil), p.Refunds...)
+	c.VAT = append([]tokipay.VATRegistrationRequest(nil), p.VAT...)
+	return c
+}
+
+func requestIDFor(n int) string {
+	return fmt.Sprintf("rq-%06d", n)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeData[T any](w http.ResponseWriter, data T) {
+	writeEnvelope(w, http.StatusOK, tokipay.TokiPayResponse[T]{
+		Code:      http.StatusOK,
+		Status:    "success",
+		Timestamp: tokipay.NewTimestamp(time.Now()),
+		Data:      data,
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeEnvelope(w, code, tokipay.TokiPayResponse[any]{
+		Code:      code,
+		Status:    "error",
+		Timestamp: tokipay.NewTimestamp(time.Now()),
+		Error:     &tokipay.APIError{Message: message},
+	})
+}
+
+func writeEnvelope(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,209 @@
+package tokipaytest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+	"github.com/techpartners-asia/tokipay-third-party-service-go/tokipaytest"
+)
+
+// responseCode returns the envelope code of a failed call, or 200 for nil
+func responseCode(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return http.StatusOK
+	}
+	var rerr *tokipay.ResponseError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("error %v is not a *ResponseError", err)
+	}
+	return rerr.Code
+}
+
+func qrPayment(client tokipay.TokiPay, orderID string) error {
+	_, err := client.CreateQRPayment(tokipay.QRPaymentRequest{
+		SuccessURL: "https://example.com/success",
+		FailureURL: "https://example.com/failure",
+		OrderID:    orderID,
+		Amount:     1000,
+	})
+	return err
+}
+
+func TestServerPaymentLifecycle(t *testing.T) {
+	var received []string
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.URL.Path)
+	}))
+	defer app.Close()
+
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	create := func(orderID string) (*tokipay.QRPaymentResponse, error) {
+		return client.CreateQRPayment(tokipay.QRPaymentRequest{
+			SuccessURL: app.URL + "/success",
+			FailureURL: app.URL + "/failure",
+			OrderID:    orderID,
+			Amount:     1000,
+		})
+	}
+	approved, err := create("ORDER_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := create("ORDER_1"); err == nil {
+		t.Error("duplicate order succeeded")
+	}
+	expired, err := create("ORDER_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := create("ORDER_3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Approve(approved.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Approve(approved.RequestID); err == nil {
+		t.Error("approving an approved payment succeeded")
+	}
+	if err := srv.Expire(expired.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CancelPayment(cancelled.RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CancelPayment(expired.RequestID); err == nil {
+		t.Error("cancelling an expired payment succeeded")
+	}
+
+	statuses := map[string]string{
+		approved.RequestID:  tokipay.StatusApproved,
+		expired.RequestID:   tokipay.StatusExpired,
+		cancelled.RequestID: tokipay.StatusCancelled,
+	}
+	for requestID, want := range statuses {
+		status, err := client.CheckPaymentStatus(requestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != want {
+			t.Errorf("status of %s = %s, want %s", requestID, status.Status, want)
+		}
+	}
+	if _, err := client.CheckPaymentStatus("missing"); err == nil {
+		t.Error("status of an unknown request succeeded")
+	}
+
+	p, _ := srv.Payment(approved.RequestID)
+	if _, err := client.RefundPayment(tokipay.RefundRequest{TransNumber: p.TransNumber, Amount: "400"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RefundPayment(tokipay.RefundRequest{TransNumber: p.TransNumber, Amount: "700"}); err == nil {
+		t.Error("refunding more than the rest of the payment succeeded")
+	}
+	if p, _ = srv.Payment(approved.RequestID); p.Refunded != 400 || len(p.Refunds) != 1 {
+		t.Errorf("refunded payment = %+v", p)
+	}
+
+	if len(received) != 2 || received[0] != "/success" || received[1] != "/failure" {
+		t.Errorf("callbacks received = %q", received)
+	}
+	for _, cb := range srv.Callbacks() {
+		if cb.Err != nil || cb.StatusCode != http.StatusOK {
+			t.Errorf("callback to %s: status %d, error %v", cb.URL, cb.StatusCode, cb.Err)
+		}
+	}
+
+	other := srv.NewClient()
+	other.Password = "wrong"
+	if err := other.GetAccessToken(); err == nil {
+		t.Error("token request with a wrong password succeeded")
+	}
+}
+
+func TestFailNextCount(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	srv.FailNext(tokipaytest.EndpointQR, 2, tokipaytest.InternalError())
+	want := []int{500, 500, 200}
+	for i, code := range want {
+		if got := responseCode(t, qrPayment(client, "ORDER_1")); got != code {
+			t.Errorf("request %d code = %d, want %d", i+1, got, code)
+		}
+	}
+	if len(srv.Payments()) != 1 {
+		t.Errorf("payments = %d, want 1 once the faults are used up", len(srv.Payments()))
+	}
+
+	// other endpoints are not affected
+	srv.FailNext(tokipaytest.EndpointRefund, 1, tokipaytest.InternalError())
+	p, _ := srv.PaymentByOrder("ORDER_1")
+	if _, err := client.CheckPaymentStatus(p.RequestID); err != nil {
+		t.Errorf("status with a refund fault error = %v", err)
+	}
+}
+
+func TestScenarioOrdering(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	// scenarios match in registration order, and one that is used up makes
+	// way for the next
+	srv.Script(tokipaytest.Scenario{Endpoint: tokipaytest.EndpointQR, Times: 1, Fault: tokipaytest.Fault{StatusCode: http.StatusBadRequest}})
+	srv.Script(tokipaytest.Scenario{Endpoint: tokipaytest.EndpointQR, Times: 1, Fault: tokipaytest.Fault{StatusCode: http.StatusConflict}})
+	for i, code := range []int{400, 409, 200} {
+		if got := responseCode(t, qrPayment(client, "ORDER_1")); got != code {
+			t.Errorf("request %d code = %d, want %d", i+1, got, code)
+		}
+	}
+
+	// an order scenario only matches its order, even when registered
+	// before a scenario for every order
+	srv.Script(tokipaytest.Scenario{Endpoint: tokipaytest.EndpointQR, OrderID: "ORDER_2", Fault: tokipaytest.Fault{StatusCode: http.StatusForbidden}})
+	srv.Script(tokipaytest.Scenario{Endpoint: tokipaytest.EndpointQR, Times: 1, Fault: tokipaytest.InternalError()})
+	tests := []struct {
+		orderID string
+		code    int
+	}{
+		{"ORDER_3", 500},
+		{"ORDER_2", 403},
+		{"ORDER_2", 403}, // Times zero applies to every matching request
+		{"ORDER_3", 200},
+	}
+	for _, tt := range tests {
+		if got := responseCode(t, qrPayment(client, tt.orderID)); got != tt.code {
+			t.Errorf("%s code = %d, want %d", tt.orderID, got, tt.code)
+		}
+	}
+
+	srv.ClearScenarios()
+	if err := qrPayment(client, "ORDER_2"); err != nil {
+		t.Errorf("request after ClearScenarios error = %v", err)
+	}
+}
+
+func TestScenarioEveryEndpoint(t *testing.T) {
+	srv := tokipaytest.NewServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	// an empty endpoint matches the token request first
+	srv.FailNext("", 1, tokipaytest.Fault{StatusCode: http.StatusUnauthorized})
+	if err := client.GetAccessToken(); responseCode(t, err) != http.StatusUnauthorized {
+		t.Errorf("token error = %v", err)
+	}
+	if err := qrPayment(client, "ORDER_1"); err != nil {
+		t.Errorf("request after the fault error = %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a RetryTransport
+const (
+	DefaultRetryDelay    = 500 * time.Millisecond
+	DefaultRetryMaxDelay = 10 * time.Second
+)
+
+// RetryTransport retries requests that failed in transport or that TokiPay
+// answered with 429, 502, 503 or 504. Only idempotent requests are retried
+// after a transport error or 5xx response: GET, HEAD and DELETE requests,
+// which include the token request and status checks, and the PATCH of a
+// cancellation. A payment or refund request that failed that way may
+// already have been processed, and sending it again could charge or refund
+// twice. A 429 means TokiPay did not process the request, so every request
+// is retried on it.
+//
+// Set it as the Transport of a client's HTTPClient:
+//
+//	client.HTTPClient.Transport = &tokipay.RetryTransport{Attempts: 3}
+type RetryTransport struct {
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Attempts is the maximum number of times a request is sent. Zero or
+	// one disables retries.
+	Attempts int
+
+	// Delay before the second attempt, doubled for every further one up to
+	// MaxDelay. A Retry-After header in seconds is honoured up to MaxDelay.
+	// They default to DefaultRetryDelay and DefaultRetryMaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	delay, maxDelay := t.Delay, t.MaxDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := base.RoundTrip(req)
+		if attempt >= t.Attempts || !retryable(req, resp, err) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		wait := min(delay, maxDelay)
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+				wait = min(time.Duration(seconds)*time.Second, maxDelay)
+			}
+			resp.Body.Close()
+		}
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		delay *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil && errors.Is(err, req.Context().Err()) {
+		return false
+	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if !idempotent(req) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// idempotent reports whether sending req twice has the effect of sending it
+// once
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return true
+	case http.MethodPatch:
+		// cancellation of a payment request: CancelEndpoint/{requestId}
+		_, requestID, ok := strings.Cut(req.URL.Path, CancelEndpoint+"/")
+		return ok && requestID != "" && !strings.Contains(requestID, "/")
+	}
+	return false
+}
+
+// RateLimitTransport limits the rate of requests, such as to stay below a
+// rate TokiPay agreed on for the merchant. Requests wait for their turn
+// until their context is done. Share one RateLimitTransport between the
+// clients that share the limit.
+type RateLimitTransport struct {
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// Rate is the number of requests per second; zero disables the limit
+	Rate float64
+
+	// Burst is the number of requests sent at once after an idle period.
+	// Defaults to 1.
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Rate > 0 {
+		if err := sleep(req.Context(), t.reserve()); err != nil {
+			return nil, err
+		}
+	}
+	return base.RoundTrip(req)
+}
+
+// reserve takes a token from the bucket and returns how long to wait for it
+func (t *RateLimitTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	burst := float64(max(t.Burst, 1))
+	now := time.Now()
+	if t.last.IsZero() {
+		t.tokens = burst
+	} else {
+		t.tokens = min(burst, t.tokens+now.Sub(t.last).Seconds()*t.Rate)
+	}
+	t.last = now
+
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.Rate * float64(time.Second))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+package tokipay_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tokipay "github.com/techpartners-asia/tokipay-third-party-service-go"
+)
+
+// scriptedServer answers requests with the statuses in order, then with
+// 200, and records each request's time and body
+type scriptedServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	times    []time.Time
+	bodies   []string
+}
+
+func newScriptedServer(t *testing.T, header http.Header, statuses ...int) *scriptedServer {
+	s := &scriptedServer{statuses: statuses, header: header}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.times = append(s.times, time.Now())
+		s.bodies = append(s.bodies, string(body))
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+			for k, v := range s.header {
+				w.Header()[k] = v
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *scriptedServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.times)
+}
+
+func TestRetryTransport(t *testing.T) {
+	unavailable := http.StatusServiceUnavailable
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		statuses []int
+		want     int // requests sent
+		status   int // final status
+	}{
+		{"GET retried until success", "GET", tokipay.StatusEndpoint, []int{unavailable, http.StatusBadGateway}, 3, http.StatusOK},
+		{"GET gives up after Attempts", "GET", tokipay.StatusEndpoint, []int{unavailable, unavailable, unavailable, unavailable}, 3, unavailable},
+		{"cancellation retried", "PATCH", tokipay.CancelEndpoint + "/req-1", []int{unavailable}, 2, http.StatusOK},
+		{"other PATCH not retried", "PATCH", tokipay.CancelEndpoint + "/req-1/items", []int{unavailable}, 1, unavailable},
+		{"POST not retried after 5xx", "POST", tokipay.RefundEndpoint, []int{unavailable}, 1, unavailable},
+		{"POST retried after 429", "POST", tokipay.RefundEndpoint, []int{http.StatusTooManyRequests}, 2, http.StatusOK},
+		{"client error not retried", "GET", tokipay.StatusEndpoint, []int{http.StatusBadRequest}, 1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newScriptedServer(t, nil, tt.statuses...)
+			client := &http.Client{Transport: &tokipay.RetryTransport{Attempts: 3, Delay: time.Millisecond}}
+
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(`{"amount":"400"}`))
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || srv.requests() != tt.want {
+				t.Errorf("status %d after %d requests, want %d after %d", resp.StatusCode, srv.requests(), tt.status, tt.want)
+			}
+			for i, body := range srv.bodies {
+				if body != `{"amount":"400"}` {
+					t.Errorf("body of request %d = %q, want it rewound", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportBackoff(t *testing.T) {
+	unavailable := http.StatusServiceUnavailable
+	srv := newScriptedServer(t, nil, unavailable, unavailable, unavailable)
+	client := &http.Client{Transport: &tokipay.RetryTransport{Attempts: 4, Delay: 20 * time.Millisecond, MaxDelay: 30 * time.Millisecond}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// delays of 20ms, then 40ms and 80ms capped at 30ms
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond} {
+		if gap := srv.times[i+1].Sub(srv.times[i]); gap < want || gap > want+200*time.Millisecond {
+			t.Errorf("delay before attempt %d = %v, want %v", i+2, gap, want)
+		}
+	}
+
+	// Retry-After takes precedence over the delay, capped at MaxDelay
+	srv = newScriptedServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	client = &http.Client{Transport: &tokipay.RetryTransport{Attempts: 2, Delay: time.Millisecond, MaxDelay: 50 * time.Millisecond}}
+	resp, err = client.Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if gap := srv.times[1].Sub(srv.times[0]); gap < 50*time.Millisecond || gap > 500*time.Millisecond {
+		t.Errorf("delay after Retry-After = %v, want the 50ms MaxDelay", gap)
+	}
+
+	// a cancelled context stops the retries
+	srv = newScriptedServer(t, nil, unavailable, unavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	client = &http.Client{Transport: &tokipay.RetryTransport{Attempts: 3, Delay: time.Second}}
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("retry after the deadline error = %v", err)
+	}
+	if srv.requests() != 1 {
+		t.Errorf("%d requests sent, want 1", srv.requests())
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	srv := newScriptedServer(t, nil)
+	limit := &tokipay.RateLimitTransport{Rate: 50, Burst: 2}
+	client := &http.Client{Transport: limit}
+
+	for range 4 {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// the burst goes at once, then one request every 20ms
+	if gap := srv.times[1].Sub(srv.times[0]); gap > 15*time.Millisecond {
+		t.Errorf("burst spaced by %v", gap)
+	}
+	if elapsed := srv.times[3].Sub(srv.times[0]); elapsed < 35*time.Millisecond {
+		t.Errorf("4 requests at 50/s with a burst of 2 took %v, want at least 40ms", elapsed)
+	}
+
+	slow := &http.Client{Transport: &tokipay.RateLimitTransport{Rate: 0.1}}
+	resp, err := slow.Get(srv.URL) // uses the burst
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if _, err := slow.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting request with a cancelled context error = %v", err)
+	}
+}
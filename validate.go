@@ -0,0 +1,210 @@
+package tokipay
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ValidationError reports an invalid field value
+type ValidationError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+func invalid(field, value, format string, args ...any) error {
+	return &ValidationError{Field: field, Value: value, Reason: fmt.Sprintf(format, args...)}
+}
+
+// forField renames the field of a ValidationError
+func forField(err error, field string) error {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return &ValidationError{Field: field, Value: verr.Value, Reason: verr.Reason}
+	}
+	return err
+}
+
+// Identifier lengths. A DDTD is e-barimt's 33-digit receipt ID or the
+// 11-digit form shown in TokiPay's documentation.
+const (
+	OrganizationRegNoLength = 7
+	DDTDLength              = 33
+	ShortDDTDLength         = 11
+)
+
+// ValidateOrganizationRegNo checks a legal entity registration number,
+// which is 7 digits
+func ValidateOrganizationRegNo(s string) error {
+	const field = "organization registration number"
+	if err := digits(field, s); err != nil {
+		return err
+	}
+	if len(s) != OrganizationRegNoLength {
+		return invalid(field, s, "must be %d digits, got %d", OrganizationRegNoLength, len(s))
+	}
+	return nil
+}
+
+// ValidateCitizenRegNo checks a citizen registration number: two Mongolian
+// Cyrillic letters followed by 8 digits that begin with the birth date as
+// YYMMDD. Months of people born from 2000 on are increased by 20.
+func ValidateCitizenRegNo(s string) error {
+	const field = "citizen registration number"
+	if utf8.RuneCountInString(s) != 10 {
+		return invalid(field, s, "must be 2 letters and 8 digits, got %d characters", utf8.RuneCountInString(s))
+	}
+
+	runes := []rune(s)
+	for i, r := range runes[:2] {
+		if !unicode.Is(unicode.Cyrillic, r) || !unicode.IsUpper(r) {
+			return invalid(field, s, "character %d must be an upper-case Cyrillic letter", i+1)
+		}
+	}
+	number := string(runes[2:])
+	if err := digits(field, number); err != nil {
+		return invalid(field, s, "must end in 8 digits")
+	}
+
+	year, _ := strconv.Atoi(number[0:2])
+	month, _ := strconv.Atoi(number[2:4])
+	day, _ := strconv.Atoi(number[4:6])
+	year += 1900
+	if month > 20 {
+		year += 100
+		month -= 20
+	}
+	if month < 1 || month > 12 {
+		return invalid(field, s, "birth month %s is not 01-12 or 21-32", number[2:4])
+	}
+	if day < 1 || day > daysIn(time.Month(month), year) {
+		return invalid(field, s, "birth date %04d-%02d-%s does not exist", year, month, number[4:6])
+	}
+	return nil
+}
+
+// ValidateTIN checks a taxpayer identification number. Organization
+// registration numbers, 11 or 12 digit TINs and citizen registration
+// numbers are accepted. No checksum is published for any of them, so only
+// the format and, for citizens, the birth date are checked.
+func ValidateTIN(s string) error {
+	const field = "TIN"
+	switch {
+	case s == "":
+		return invalid(field, s, "is empty")
+	case !unicode.IsDigit([]rune(s)[0]):
+		return forField(ValidateCitizenRegNo(s), field)
+	}
+
+	if err := digits(field, s); err != nil {
+		return err
+	}
+	switch len(s) {
+	case OrganizationRegNoLength, 11, 12:
+		return nil
+	}
+	return invalid(field, s, "must be %d, 11 or 12 digits, got %d", OrganizationRegNoLength, len(s))
+}
+
+// ValidateDDTD checks an e-barimt receipt identifier: a DDTD of 33 digits
+// or of 11, such as "19910000004" in TokiPay's documentation, or a lottery
+// number of two letters and 8 digits, such as "AB 12345678". No checksum is
+// published for either.
+func ValidateDDTD(s string) error {
+	const field = "DDTD"
+	if s == "" {
+		return invalid(field, s, "is empty")
+	}
+
+	if r, _ := utf8.DecodeRuneInString(s); unicode.IsLetter(r) {
+		runes := []rune(strings.Replace(s, " ", "", 1))
+		if len(runes) != 10 || !unicode.IsUpper(runes[0]) || !unicode.IsUpper(runes[1]) || digits(field, string(runes[2:])) != nil {
+			return invalid(field, s, "lottery number must be 2 upper-case letters and 8 digits")
+		}
+		return nil
+	}
+
+	if err := digits(field, s); err != nil {
+		return err
+	}
+	if len(s) != DDTDLength && len(s) != ShortDDTDLength {
+		return invalid(field, s, "must be %d or %d digits, got %d", ShortDDTDLength, DDTDLength, len(s))
+	}
+	return nil
+}
+
+// Validate checks the request before it is sent to TokiPay
+func (r VATRegistrationRequest) Validate() error {
+	if r.TransactionID == "" {
+		return invalid("transactionId", "", "is required")
+	}
+	if err := ValidateDDTD(r.DDTD); err != nil {
+		return err
+	}
+	if r.MerchantTIN != "" {
+		if err := ValidateTIN(r.MerchantTIN); err != nil {
+			return forField(err, "merchantTin")
+		}
+	}
+
+	var total, vat float64
+	var err error
+	if r.TotalAmount != "" {
+		if total, err = strconv.ParseFloat(r.TotalAmount, 64); err != nil || total < 0 {
+			return invalid("totalAmount", r.TotalAmount, "must be a non-negative number")
+		}
+	}
+	if r.VATAmount != "" {
+		if vat, err = strconv.ParseFloat(r.VATAmount, 64); err != nil || vat < 0 {
+			return invalid("vatAmount", r.VATAmount, "must be a non-negative number")
+		}
+		if r.TotalAmount != "" && vat > total {
+			return invalid("vatAmount", r.VATAmount, "exceeds totalAmount %s", r.TotalAmount)
+		}
+	}
+	return nil
+}
+
+// Validate checks the VAT headers of an organization callback. Headers
+// without a VAT ID are valid.
+func (h CallbackHeaders) Validate() error {
+	if h.VATID == "" {
+		if h.VATType != "" {
+			return invalid(HeaderVATID, "", "header is missing while %s is %s", HeaderVATType, h.VATType)
+		}
+		return nil
+	}
+	if h.VATType == "" {
+		return invalid(HeaderVATType, "", "header is missing while %s is set", HeaderVATID)
+	}
+	return forField(ValidateTIN(h.VATID), HeaderVATID)
+}
+
+// digits checks that s is a non-empty string of ASCII digits
+func digits(field, s string) error {
+	if s == "" {
+		return invalid(field, s, "is empty")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return invalid(field, s, "must contain only digits")
+		}
+	}
+	return nil
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
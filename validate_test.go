@@ -0,0 +1,177 @@
+package tokipay
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTIN(t *testing.T) {
+	tests := []struct {
+		name  string
+		tin   string
+		valid bool
+	}{
+		{"organization", "1234567", true},
+		{"11 digit TIN", "12345678901", true},
+		{"12 digit TIN", "123456789012", true},
+		{"citizen", "УБ90010112", true},
+		{"citizen born 2000 on", "ТА05250112", true},
+		{"empty", "", false},
+		{"6 digits", "123456", false},
+		{"8 digits", "12345678", false},
+		{"letters in number", "12345a7", false},
+		{"Latin letters", "UB90010112", false},
+		{"lower-case letters", "уб90010112", false},
+		{"month 13", "УБ90130112", false},
+		{"month 33", "УБ05330112", false},
+		{"February 30", "УБ90023012", false},
+		{"leap day", "УБ04222912", true},
+		{"leap day in common year", "УБ05222912", false},
+		{"short citizen", "УБ9001011", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTIN(tt.tin)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateTIN(%q) = %v, want valid %v", tt.tin, err, tt.valid)
+			}
+			var verr *ValidationError
+			if err != nil && (!errors.As(err, &verr) || verr.Field != "TIN") {
+				t.Errorf("ValidateTIN(%q) = %#v, want a TIN ValidationError", tt.tin, err)
+			}
+		})
+	}
+}
+
+func TestValidateDDTD(t *testing.T) {
+	tests := []struct {
+		name  string
+		ddtd  string
+		valid bool
+	}{
+		{"documented", "19910000004", true},
+		{"33 digits", "000490101002417140000110010075612", true},
+		{"too short", "1", false},
+		{"12 digits", "199100000041", false},
+		{"dashes", "1991-0000004", false},
+		{"lottery", "AB 12345678", true},
+		{"lottery without space", "AB12345678", true},
+		{"lottery lower-case", "ab 12345678", false},
+		{"lottery short", "AB 1234567", false},
+		{"letter inside", "1991000000A", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateDDTD(tt.ddtd); (err == nil) != tt.valid {
+				t.Errorf("ValidateDDTD(%q) = %v, want valid %v", tt.ddtd, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestVATRegistrationRequestValidate(t *testing.T) {
+	valid := VATRegistrationRequest{
+		TransactionID: "3425279",
+		DDTD:          "19910000004",
+		TotalAmount:   "1000",
+		VATAmount:     "90.09",
+		CreatedDate:   NewDate(time.Now()),
+		MerchantName:  "Test Merchant",
+		MerchantTIN:   "1234567",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*VATRegistrationRequest)
+		field  string
+	}{
+		{"valid", func(*VATRegistrationRequest) {}, ""},
+		{"optional fields empty", func(r *VATRegistrationRequest) {
+			r.TotalAmount, r.VATAmount, r.CreatedDate, r.MerchantTIN = "", "", Date{}, ""
+		}, ""},
+		{"no transaction ID", func(r *VATRegistrationRequest) { r.TransactionID = "" }, "transactionId"},
+		{"bad DDTD", func(r *VATRegistrationRequest) { r.DDTD = "1991-0000004" }, "DDTD"},
+		{"bad merchant TIN", func(r *VATRegistrationRequest) { r.MerchantTIN = "123" }, "merchantTin"},
+		{"total not a number", func(r *VATRegistrationRequest) { r.TotalAmount = "1,000" }, "totalAmount"},
+		{"negative VAT", func(r *VATRegistrationRequest) { r.VATAmount = "-1" }, "vatAmount"},
+		{"VAT over total", func(r *VATRegistrationRequest) { r.VATAmount = "1000.01" }, "vatAmount"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.modify(&req)
+			err := req.Validate()
+
+			var verr *ValidationError
+			switch {
+			case tt.field == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.field != "" && !errors.As(err, &verr):
+				t.Errorf("Validate() = %v, want a ValidationError", err)
+			case tt.field != "" && verr.Field != tt.field:
+				t.Errorf("Validate() field = %q, want %q", verr.Field, tt.field)
+			}
+		})
+	}
+}
+
+func TestCallbackHeadersValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers CallbackHeaders
+		valid   bool
+	}{
+		{"no VAT", CallbackHeaders{}, true},
+		{"organization", CallbackHeaders{VATID: "1234567", VATType: VATTypeOrganization}, true},
+		{"citizen", CallbackHeaders{VATID: "УБ90010112", VATType: "CITIZEN"}, true},
+		{"type without ID", CallbackHeaders{VATType: VATTypeOrganization}, false},
+		{"ID without type", CallbackHeaders{VATID: "1234567"}, false},
+		{"bad ID", CallbackHeaders{VATID: "12345", VATType: VATTypeOrganization}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.headers.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestParseCallbackVATHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		vatID   string
+		vatType string
+		valid   bool
+	}{
+		{"no VAT", "", "", true},
+		{"organization", "1234567", VATTypeOrganization, true},
+		{"bad ID", "12", VATTypeOrganization, false},
+		{"ID without type", "1234567", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/success", strings.NewReader(`{"orderId":"ORDER_1","requestId":"rq-1","status":"SUCCESS","amount":1000}`))
+			r.Header.Set(HeaderVATID, tt.vatID)
+			r.Header.Set(HeaderVATType, tt.vatType)
+
+			// the payment status is kept whatever the VAT headers
+			callback, headers, err := ParseCallback(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if callback.Status != StatusSuccess || headers.VATID != tt.vatID {
+				t.Errorf("ParseCallback = %+v, %+v", *callback, *headers)
+			}
+			var verr *ValidationError
+			if tt.valid != (headers.VATError == nil) || !tt.valid && !errors.As(headers.VATError, &verr) {
+				t.Errorf("VATError = %v, want valid %v", headers.VATError, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package tokipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrVATRegistrationNotFound is returned by a VATRegistrationStore when no
+// registration matches
+var ErrVATRegistrationNotFound = errors.New("VAT registration not found")
+
+// ErrReceiptNotReady is returned by a VATWorkflow's Receipt func when the
+// e-barimt receipt of an order has not been issued yet. The payment is
+// retried on the next sweep.
+var ErrReceiptNotReady = errors.New("e-barimt receipt not ready")
+
+// VAT registration statuses recorded in a VATRegistrationStore, and
+// reported in VATWorkflowEvent with VATRegistrationWaiting
+const (
+	VATRegistrationRegistered = "REGISTERED"
+	VATRegistrationFailed     = "FAILED"
+	VATRegistrationWaiting    = "WAITING" // receipt not ready, never stored
+)
+
+// Defaults of a VATWorkflow
+const (
+	DefaultVATAttempts   = 3
+	DefaultVATRetryDelay = 2 * time.Second
+	DefaultVATInterval   = 30 * time.Second
+	DefaultVATBatchSize  = 100
+)
+
+// VATRegistration is the result of registering VAT for a payment
+type VATRegistration struct {
+	PaymentKey string                 `json:"paymentKey"` // Key of the payment
+	OrderID    string                 `json:"orderId"`
+	VATType    string                 `json:"vatType"`
+	VATID      string                 `json:"vatId"`
+	Request    VATRegistrationRequest `json:"request"`
+	Status     string                 `json:"status"`
+	Attempts   int                    `json:"attempts"`          // calls to TokiPay, resubmissions included
+	Message    string                 `json:"message,omitempty"` // TokiPay's response message
+	Error      string                 `json:"error,omitempty"`   // last error of a failed registration
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+}
+
+// VATRegistrationStore persists VAT registration results
+type VATRegistrationStore interface {
+	// SaveVATRegistration inserts the registration or updates the one with the same PaymentKey
+	SaveVATRegistration(ctx context.Context, r *VATRegistration) error
+
+	// GetVATRegistration returns the registration of the payment with the given Key
+	GetVATRegistration(ctx context.Context, key string) (*VATRegistration, error)
+
+	// ListVATRegistrations returns registrations with the status, or all
+	// registrations if status is empty, oldest first
+	ListVATRegistrations(ctx context.Context, status string) ([]VATRegistration, error)
+}
+
+// VATWorkflowEvent reports the outcome of registering VAT for a payment
+type VATWorkflowEvent struct {
+	Time       time.Time `json:"time"`
+	PaymentKey string    `json:"paymentKey"`
+	OrderID    string    `json:"orderId"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts,omitempty"`
+	Err        error     `json:"-"`
+}
+
+// VATWorkflow registers VAT for approved organization payments. Payments
+// are found in the payment store: every approved payment with organization
+// VAT details that is not yet registered is registered once, and the
+// result is saved in the registration store. Only requests that did not
+// reach TokiPay are retried; registrations that fail are left for Failed
+// and Resubmit, and are not retried by Run.
+type VATWorkflow struct {
+	Client        TokiPay
+	Payments      PaymentStore
+	Registrations VATRegistrationStore
+
+	// Receipt returns the e-barimt receipt issued for the payment's order.
+	// The receipt's TransactionID and Date default to the payment's
+	// TransNumber and creation time. Return ErrReceiptNotReady to retry on
+	// the next sweep.
+	Receipt func(ctx context.Context, p *Payment) (VATReceipt, error)
+
+	// Calculator derives the VAT amount. Defaults to DefaultTaxCalculator.
+	Calculator *TaxCalculator
+
+	// CityTax reports whether payment amounts include city tax
+	CityTax bool
+
+	// Attempts is how often TokiPay is called before a registration fails.
+	// Defaults to DefaultVATAttempts.
+	Attempts int
+
+	// RetryDelay before the second attempt, doubled for every further one.
+	// Defaults to DefaultVATRetryDelay.
+	RetryDelay time.Duration
+
+	// Interval between sweeps in Run. Defaults to DefaultVATInterval.
+	Interval time.Duration
+
+	// BatchSize is the number of payments a sweep lists at a time.
+	// Defaults to DefaultVATBatchSize.
+	BatchSize int
+
+	// OnEvent is called for every payment the workflow acts on
+	OnEvent func(VATWorkflowEvent)
+
+	// OnError is called when a sweep fails to list payments. Run keeps
+	// running and retries on the next sweep.
+	OnError func(error)
+}
+
+// NewVATWorkflow creates a workflow that registers VAT with the receipts
+// returned by receipt
+func NewVATWorkflow(client TokiPay, payments PaymentStore, registrations VATRegistrationStore,
+	receipt func(ctx context.Context, p *Payment) (VATReceipt, error)) *VATWorkflow {
+	return &VATWorkflow{Client: client, Payments: payments, Registrations: registrations, Receipt: receipt}
+}
+
+// NeedsVATRegistration reports whether a payment is approved for an
+// organization customer and its VAT is not registered yet
+func NeedsVATRegistration(p *Payment) bool {
+	return p.Status == StatusApproved && p.VATType == VATTypeOrganization && p.VATID != "" && !p.VATRegistered
+}
+
+// Run sweeps for payments to register every Interval until ctx is done,
+// then returns ctx.Err()
+func (w *VATWorkflow) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultVATInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Sweep(ctx); err != nil && ctx.Err() == nil && w.OnError != nil {
+			w.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep registers VAT for every payment that needs it and has no stored
+// registration, and returns the number registered. Only approved payments
+// with VAT pending are listed, BatchSize at a time.
+func (w *VATWorkflow) Sweep(ctx context.Context) (int, error) {
+	filter := PaymentFilter{Statuses: []string{StatusApproved}, VATPending: true, Limit: w.BatchSize}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultVATBatchSize
+	}
+
+	registered := 0
+	for {
+		payments, err := w.Payments.ListPayments(ctx, filter)
+		if err != nil {
+			return registered, fmt.Errorf("failed to list payments with VAT pending: %w", err)
+		}
+
+		for _, p := range payments {
+			if err := ctx.Err(); err != nil {
+				return registered, err
+			}
+			if !NeedsVATRegistration(&p) {
+				continue
+			}
+			_, err := w.Registrations.GetVATRegistration(ctx, p.Key)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, ErrVATRegistrationNotFound) {
+				return registered, fmt.Errorf("failed to get VAT registration: %w", err)
+			}
+
+			r, _ := w.Register(ctx, &p)
+			if r != nil && r.Status == VATRegistrationRegistered {
+				registered++
+			}
+		}
+
+		if len(payments) < filter.Limit {
+			return registered, nil
+		}
+		filter.After = &payments[len(payments)-1]
+	}
+}
+
+// Register registers VAT for one payment, for example right after its
+// callback was recorded. A payment approved by callback alone has no
+// TransNumber yet, so its status is checked first, unless it is a deeplink
+// payment, which has no request ID to check it with. The registration is
+// returned with the last error when it failed, and nil when the receipt is
+// not ready.
+func (w *VATWorkflow) Register(ctx context.Context, p *Payment) (*VATRegistration, error) {
+	client := w.recordingClient()
+	if p.TransNumber == "" && p.RequestID != "" {
+		if _, err := client.CheckPaymentStatus(p.RequestID); err != nil {
+			var serr *StoreError
+			if !errors.As(err, &serr) {
+				return nil, w.report(p, VATRegistrationFailed, 0, fmt.Errorf("failed to check status: %w", err))
+			}
+		}
+		updated, err := w.Payments.GetPayment(ctx, p.Key)
+		if err != nil {
+			return nil, w.report(p, VATRegistrationFailed, 0, err)
+		}
+		p = updated
+	}
+
+	req, err := w.request(ctx, p)
+	if errors.Is(err, ErrReceiptNotReady) {
+		return nil, w.report(p, VATRegistrationWaiting, 0, err)
+	}
+
+	r := &VATRegistration{PaymentKey: p.Key, OrderID: p.OrderID, VATType: p.VATType, VATID: p.VATID}
+	if prev, gerr := w.Registrations.GetVATRegistration(ctx, p.Key); gerr == nil {
+		r = prev
+	}
+	if err != nil {
+		return w.finish(ctx, p, r, 0, nil, err)
+	}
+	r.Request = req
+	return w.submit(ctx, client, p, r)
+}
+
+// Failed returns the registrations that failed, for Resubmit
+func (w *VATWorkflow) Failed(ctx context.Context) ([]VATRegistration, error) {
+	return w.Registrations.ListVATRegistrations(ctx, VATRegistrationFailed)
+}
+
+// Resubmit retries a failed registration. The request is rebuilt from the
+// payment and its receipt, so a corrected receipt is picked up; without a
+// Receipt func the stored request is sent again. key is the Key of the
+// payment.
+func (w *VATWorkflow) Resubmit(ctx context.Context, key string) (*VATRegistration, error) {
+	r, err := w.Registrations.GetVATRegistration(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status == VATRegistrationRegistered {
+		return r, fmt.Errorf("VAT of payment %s is already registered", key)
+	}
+	p, err := w.Payments.GetPayment(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Receipt != nil {
+		return w.Register(ctx, p)
+	}
+	if r.Request.DDTD == "" {
+		return r, fmt.Errorf("VAT registration of payment %s has no request to resubmit", key)
+	}
+	return w.submit(ctx, w.recordingClient(), p, r)
+}
+
+// request builds the registration request from the payment and its receipt
+func (w *VATWorkflow) request(ctx context.Context, p *Payment) (VATRegistrationRequest, error) {
+	if w.Receipt == nil {
+		return VATRegistrationRequest{}, errors.New("VAT workflow has no Receipt func")
+	}
+	receipt, err := w.Receipt(ctx, p)
+	if err != nil {
+		return VATRegistrationRequest{}, err
+	}
+
+	calc := DefaultTaxCalculator
+	if w.Calculator != nil {
+		calc = *w.Calculator
+	}
+	return calc.RegistrationFromPayment(receipt, p, w.CityTax)
+}
+
+// submit calls TokiPay until the registration succeeds, fails in a way
+// retryVAT does not retry, or runs out of attempts
+func (w *VATWorkflow) submit(ctx context.Context, client *RecordingClient, p *Payment, r *VATRegistration) (*VATRegistration, error) {
+	attempts := w.Attempts
+	if attempts <= 0 {
+		attempts = DefaultVATAttempts
+	}
+	delay := w.RetryDelay
+	if delay <= 0 {
+		delay = DefaultVATRetryDelay
+	}
+
+	var resp *VATRegistrationResponse
+	var err error
+	n := 0
+	for n < attempts {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return w.finish(ctx, p, r, n, nil, errors.Join(err, ctx.Err()))
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		n++
+		resp, err = client.RegisterVAT(r.Request)
+		var serr *StoreError
+		if err == nil || errors.As(err, &serr) || !retryVAT(err) {
+			break
+		}
+	}
+	return w.finish(ctx, p, r, n, resp, err)
+}
+
+// finish saves the outcome of a registration and reports it. A response
+// with a *StoreError counts as registered.
+func (w *VATWorkflow) finish(ctx context.Context, p *Payment, r *VATRegistration, attempts int,
+	resp *VATRegistrationResponse, err error) (*VATRegistration, error) {
+	now := time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+	r.Attempts += attempts
+	if resp != nil {
+		r.Status, r.Message, r.Error = VATRegistrationRegistered, resp.Message, ""
+	} else {
+		r.Status, r.Error = VATRegistrationFailed, err.Error()
+	}
+
+	if serr := w.Registrations.SaveVATRegistration(ctx, r); serr != nil {
+		err = errors.Join(err, &StoreError{Op: "VAT registration result", Err: serr})
+	}
+	w.report(p, r.Status, r.Attempts, err)
+	return r, err
+}
+
+func (w *VATWorkflow) report(p *Payment, status string, attempts int, err error) error {
+	if w.OnEvent != nil {
+		w.OnEvent(VATWorkflowEvent{
+			Time:       time.Now(),
+			PaymentKey: p.Key,
+			OrderID:    p.OrderID,
+			Status:     status,
+			Attempts:   attempts,
+			Err:        err,
+		})
+	}
+	return err
+}
+
+// recordingClient returns a client that records status checks and VAT
+// registrations. A RecordingClient passed as Client is used as is.
+func (w *VATWorkflow) recordingClient() *RecordingClient {
+	if rc, ok := w.Client.(*RecordingClient); ok {
+		return rc
+	}
+	return NewRecordingClient(w.Client, w.Payments)
+}
+
+// retryVAT reports whether a failed registration can be sent again.
+// RegisterVAT is not idempotent, so only failures that happened before the
+// request reached TokiPay are retried: a connection that could not be
+// opened, or a token request that did not succeed. After any other failure
+// the receipt may have been registered, as it is after a timeout, so the
+// registration is left for Resubmit.
+func retryVAT(err error) bool {
+	var oerr *net.OpError
+	if errors.As(err, &oerr) && oerr.Op == "dial" {
+		return true
+	}
+	var rerr *ResponseError
+	return errors.As(err, &rerr) && rerr.Op == "token request" && !rerr.Rejected()
+}